@@ -34,44 +34,10 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 	src := net.JoinHostPort(srcIP.String(), "68")
 	dst := "255.255.255.255:67"
 
-	// form a DHCP request packet, try to emulate existing client as much as possible
-	xID := make([]byte, 4)
-	n, err := rand.Read(xID)
-	if n != 4 && err == nil {
-		err = fmt.Errorf("Generated less than 4 bytes")
-	}
-	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't generate random bytes")
-	}
-	hostname, err := os.Hostname()
+	packet, xID, err := newDiscoverPacket(iface)
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't get hostname")
-	}
-	requestList := []byte{
-		byte(dhcp4.OptionSubnetMask),
-		byte(dhcp4.OptionClasslessRouteFormat),
-		byte(dhcp4.OptionRouter),
-		byte(dhcp4.OptionDomainNameServer),
-		byte(dhcp4.OptionDomainName),
-		byte(dhcp4.OptionDomainSearch),
-		252, // private/proxy autodiscovery
-		95,  // LDAP
-		byte(dhcp4.OptionNetBIOSOverTCPIPNameServer),
-		byte(dhcp4.OptionNetBIOSOverTCPIPNodeType),
-	}
-	maxUDPsizeRaw := make([]byte, 2)
-	binary.BigEndian.PutUint16(maxUDPsizeRaw, 1500)
-	leaseTimeRaw := make([]byte, 4)
-	leaseTime := uint32(math.RoundToEven((time.Hour * 24 * 90).Seconds()))
-	binary.BigEndian.PutUint32(leaseTimeRaw, leaseTime)
-	options := []dhcp4.Option{
-		{Code: dhcp4.OptionParameterRequestList, Value: requestList},
-		{Code: dhcp4.OptionMaximumDHCPMessageSize, Value: maxUDPsizeRaw},
-		{Code: dhcp4.OptionClientIdentifier, Value: append([]byte{0x01}, iface.HardwareAddr...)},
-		{Code: dhcp4.OptionIPAddressLeaseTime, Value: leaseTimeRaw},
-		{Code: dhcp4.OptionHostName, Value: []byte(hostname)},
+		return false, err
 	}
-	packet := dhcp4.RequestPacket(dhcp4.Discover, iface.HardwareAddr, nil, xID, false, options)
 
 	// resolve 0.0.0.0:68
 	udpAddr, err := net.ResolveUDPAddr("udp4", src)
@@ -113,7 +79,7 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 		// TODO: replicate dhclient's behaviour of retrying several times with progressively bigger timeouts
 		b := make([]byte, 1500)
 		_ = c.SetReadDeadline(time.Now().Add(defaultDiscoverTime))
-		n, _, _, err = c.ReadFrom(b)
+		n, _, _, err := c.ReadFrom(b)
 		if isTimeout(err) {
 			// timed out -- no DHCP servers
 			return false, nil
@@ -149,3 +115,47 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 		return true, nil
 	}
 }
+
+// Create DHCP Discover packet from the network interface
+// Return the packet and its transaction ID
+func newDiscoverPacket(iface *net.Interface) (dhcp4.Packet, []byte, error) {
+	// form a DHCP request packet, try to emulate existing client as much as possible
+	xID := make([]byte, 4)
+	n, err := rand.Read(xID)
+	if n != 4 && err == nil {
+		err = fmt.Errorf("Generated less than 4 bytes")
+	}
+	if err != nil {
+		return nil, nil, wrapErrPrint(err, "Couldn't generate random bytes")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, nil, wrapErrPrint(err, "Couldn't get hostname")
+	}
+	requestList := []byte{
+		byte(dhcp4.OptionSubnetMask),
+		byte(dhcp4.OptionClasslessRouteFormat),
+		byte(dhcp4.OptionRouter),
+		byte(dhcp4.OptionDomainNameServer),
+		byte(dhcp4.OptionDomainName),
+		byte(dhcp4.OptionDomainSearch),
+		252, // private/proxy autodiscovery
+		95,  // LDAP
+		byte(dhcp4.OptionNetBIOSOverTCPIPNameServer),
+		byte(dhcp4.OptionNetBIOSOverTCPIPNodeType),
+	}
+	maxUDPsizeRaw := make([]byte, 2)
+	binary.BigEndian.PutUint16(maxUDPsizeRaw, 1500)
+	leaseTimeRaw := make([]byte, 4)
+	leaseTime := uint32(math.RoundToEven((time.Hour * 24 * 90).Seconds()))
+	binary.BigEndian.PutUint32(leaseTimeRaw, leaseTime)
+	options := []dhcp4.Option{
+		{Code: dhcp4.OptionParameterRequestList, Value: requestList},
+		{Code: dhcp4.OptionMaximumDHCPMessageSize, Value: maxUDPsizeRaw},
+		{Code: dhcp4.OptionClientIdentifier, Value: append([]byte{0x01}, iface.HardwareAddr...)},
+		{Code: dhcp4.OptionIPAddressLeaseTime, Value: leaseTimeRaw},
+		{Code: dhcp4.OptionHostName, Value: []byte(hostname)},
+	}
+	packet := dhcp4.RequestPacket(dhcp4.Discover, iface.HardwareAddr, nil, xID, false, options)
+	return packet, xID, nil
+}
@@ -21,6 +21,20 @@ type leaseJSON struct {
 	IP       []byte `json:"ip"`
 	Hostname string `json:"host"`
 	Expiry   int64  `json:"exp"`
+
+	VendorClass  string `json:"vendor,omitempty"`
+	DUID         []byte `json:"duid,omitempty"`
+	ParamReqList []byte `json:"prl,omitempty"`
+
+	Options []DHCPOption `json:"options,omitempty"`
+
+	ClientID        []byte   `json:"client_id,omitempty"`
+	HostnamePattern string   `json:"host_pattern,omitempty"`
+	BoundHWAddr     []byte   `json:"bound_mac,omitempty"`
+	BoundExpiry     int64    `json:"bound_exp,omitempty"`
+	LeaseDuration   uint32   `json:"lease_duration,omitempty"`
+	Gateway         net.IP   `json:"gateway,omitempty"`
+	DNS             []net.IP `json:"dns,omitempty"`
 }
 
 func normalizeIP(ip net.IP) net.IP {
@@ -43,9 +57,8 @@ func ipInRange(start, stop, ip net.IP) bool {
 // Load lease table from DB
 func (s *Server) dbLoad() {
 	s.leases = nil
+	s.leases6 = nil
 	s.IPpool = make(map[[4]byte]net.HardwareAddr)
-	dynLeases := []*Lease{}
-	staticLeases := []*Lease{}
 
 	data, err := ioutil.ReadFile(s.conf.DBFilePath)
 	if err != nil {
@@ -62,12 +75,40 @@ func (s *Server) dbLoad() {
 		return
 	}
 
-	numLeases := len(obj)
+	s.setLeasesJSON(obj)
+	log.Info("DHCP: loaded %d (%d) leases from DB", len(s.leases)+len(s.leases6), len(obj))
+}
+
+// Replace the lease table with the leases from DB or from the HA peer
+func (s *Server) setLeasesJSON(obj []leaseJSON) {
+	s.IPpool = make(map[[4]byte]net.HardwareAddr)
+	dynLeases := []*Lease{}
+	staticLeases := []*Lease{}
+	leases6 := []*Lease{}
+	var err error
+
 	for i := range obj {
 		obj[i].IP = normalizeIP(obj[i].IP)
 
+		if len(obj[i].IP) == 16 {
+			if obj[i].Expiry != leaseExpireStatic && !s.ip6InRange(obj[i].IP) {
+				log.Tracef("Skipping a lease with IP %v: not within current IP range", obj[i].IP)
+				continue
+			}
+			leases6 = append(leases6, &Lease{
+				HWAddr:   obj[i].HWAddr,
+				IP:       obj[i].IP,
+				Hostname: obj[i].Hostname,
+				Expiry:   time.Unix(obj[i].Expiry, 0),
+
+				VendorClass: obj[i].VendorClass,
+				DUID:        obj[i].DUID,
+			})
+			continue
+		}
+
 		if obj[i].Expiry != leaseExpireStatic &&
-			!ipInRange(s.leaseStart, s.leaseStop, obj[i].IP) {
+			s.findScopeByIP(obj[i].IP) == nil {
 
 			log.Tracef("Skipping a lease with IP %v: not within current IP range", obj[i].IP)
 			continue
@@ -78,6 +119,27 @@ func (s *Server) dbLoad() {
 			IP:       obj[i].IP,
 			Hostname: obj[i].Hostname,
 			Expiry:   time.Unix(obj[i].Expiry, 0),
+
+			VendorClass:  obj[i].VendorClass,
+			ParamReqList: obj[i].ParamReqList,
+			Options:      obj[i].Options,
+
+			ClientID:        obj[i].ClientID,
+			HostnamePattern: obj[i].HostnamePattern,
+			LeaseDuration:   obj[i].LeaseDuration,
+			Gateway:         obj[i].Gateway,
+			DNS:             obj[i].DNS,
+		}
+
+		if len(obj[i].BoundHWAddr) != 0 {
+			lease.BoundHWAddr = obj[i].BoundHWAddr
+			lease.BoundExpiry = time.Unix(obj[i].BoundExpiry, 0)
+		}
+
+		_, err = parseOptions(lease.Options)
+		if err != nil {
+			log.Error("DHCP: lease %s: %s", lease.HWAddr, err)
+			lease.Options = nil
 		}
 
 		if obj[i].Expiry == leaseExpireStatic {
@@ -88,12 +150,11 @@ func (s *Server) dbLoad() {
 	}
 
 	s.leases = normalizeLeases(staticLeases, dynLeases)
+	s.leases6 = normalizeLeases(nil, leases6)
 
 	for _, lease := range s.leases {
 		s.reserveIP(lease.IP, lease.HWAddr)
 	}
-
-	log.Info("DHCP: loaded %d (%d) leases from DB", len(s.leases), numLeases)
 }
 
 // Skip duplicate leases
@@ -103,6 +164,10 @@ func normalizeLeases(staticLeases, dynLeases []*Lease) []*Lease {
 	index := map[string]int{}
 
 	for i, lease := range staticLeases {
+		if len(lease.HWAddr) == 0 {
+			leases = append(leases, lease) // matched by client ID or host name
+			continue
+		}
 		_, ok := index[lease.HWAddr.String()]
 		if ok {
 			continue // skip the lease with the same HW address
@@ -112,11 +177,15 @@ func normalizeLeases(staticLeases, dynLeases []*Lease) []*Lease {
 	}
 
 	for i, lease := range dynLeases {
-		_, ok := index[lease.HWAddr.String()]
+		key := lease.HWAddr.String()
+		if len(lease.DUID) != 0 {
+			key = "duid " + formatClientID(lease.DUID) // DHCPv6 lease
+		}
+		_, ok := index[key]
 		if ok {
 			continue // skip the lease with the same HW address
 		}
-		index[lease.HWAddr.String()] = i
+		index[key] = i
 		leases = append(leases, lease)
 	}
 
@@ -125,20 +194,8 @@ func normalizeLeases(staticLeases, dynLeases []*Lease) []*Lease {
 
 // Store lease table in DB
 func (s *Server) dbStore() {
-	var leases []leaseJSON
-
-	for i := range s.leases {
-		if s.leases[i].Expiry.Unix() == 0 {
-			continue
-		}
-		lease := leaseJSON{
-			HWAddr:   s.leases[i].HWAddr,
-			IP:       s.leases[i].IP,
-			Hostname: s.leases[i].Hostname,
-			Expiry:   s.leases[i].Expiry.Unix(),
-		}
-		leases = append(leases, lease)
-	}
+	s.leasesVersion++
+	leases := s.leasesJSON()
 
 	data, err := json.Marshal(leases)
 	if err != nil {
@@ -154,3 +211,39 @@ func (s *Server) dbStore() {
 	}
 	log.Info("DHCP: stored %d leases in DB", len(leases))
 }
+
+// Get the lease table in DB format
+func (s *Server) leasesJSON() []leaseJSON {
+	leases := []leaseJSON{}
+
+	all := append([]*Lease{}, s.leases...)
+	all = append(all, s.leases6...)
+	for _, l := range all {
+		if l.Expiry.Unix() == 0 {
+			continue
+		}
+		lease := leaseJSON{
+			HWAddr:   l.HWAddr,
+			IP:       l.IP,
+			Hostname: l.Hostname,
+			Expiry:   l.Expiry.Unix(),
+
+			VendorClass:  l.VendorClass,
+			DUID:         l.DUID,
+			ParamReqList: l.ParamReqList,
+			Options:      l.Options,
+
+			ClientID:        l.ClientID,
+			HostnamePattern: l.HostnamePattern,
+			LeaseDuration:   l.LeaseDuration,
+			Gateway:         l.Gateway,
+			DNS:             l.DNS,
+		}
+		if len(l.BoundHWAddr) != 0 {
+			lease.BoundHWAddr = l.BoundHWAddr
+			lease.BoundExpiry = l.BoundExpiry.Unix()
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
@@ -22,10 +22,10 @@ func httpError(r *http.Request, w http.ResponseWriter, code int, format string,
 }
 
 // []Lease -> JSON
-func convertLeases(inputLeases []Lease, includeExpires bool) []map[string]string {
-	leases := []map[string]string{}
+func convertLeases(inputLeases []Lease, includeExpires bool) []map[string]interface{} {
+	leases := []map[string]interface{}{}
 	for _, l := range inputLeases {
-		lease := map[string]string{
+		lease := map[string]interface{}{
 			"mac":      l.HWAddr.String(),
 			"ip":       l.IP.String(),
 			"hostname": l.Hostname,
@@ -34,6 +34,34 @@ func convertLeases(inputLeases []Lease, includeExpires bool) []map[string]string
 		if includeExpires {
 			lease["expires"] = l.Expiry.Format(time.RFC3339)
 		}
+		if len(l.Options) != 0 {
+			lease["options"] = l.Options
+		}
+		if len(l.ClientID) != 0 {
+			lease["client_id"] = formatClientID(l.ClientID)
+		}
+		if len(l.DUID) != 0 {
+			lease["duid"] = formatClientID(l.DUID)
+		}
+		if len(l.HostnamePattern) != 0 {
+			lease["hostname_pattern"] = l.HostnamePattern
+		}
+		if l.bound() {
+			lease["bound_mac"] = l.BoundHWAddr.String()
+		}
+		if l.LeaseDuration != 0 {
+			lease["lease_duration"] = l.LeaseDuration
+		}
+		if l.Gateway != nil {
+			lease["gateway"] = l.Gateway.String()
+		}
+		if len(l.DNS) != 0 {
+			dns := []string{}
+			for _, ip := range l.DNS {
+				dns = append(dns, ip.String())
+			}
+			lease["dns"] = dns
+		}
 
 		leases = append(leases, lease)
 	}
@@ -43,11 +71,21 @@ func convertLeases(inputLeases []Lease, includeExpires bool) []map[string]string
 func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 	leases := convertLeases(s.Leases(LeasesDynamic), true)
 	staticLeases := convertLeases(s.Leases(LeasesStatic), false)
+	conf := s.conf
+	conf.HA.Secret = "" // don't show the secret
 	status := map[string]interface{}{
-		"config":        s.conf,
+		"config":        conf,
 		"leases":        leases,
 		"static_leases": staticLeases,
 	}
+	rogue := s.rogueServers()
+	if len(rogue) != 0 {
+		status["rogue_servers"] = rogue
+	}
+	ha := s.haStatus()
+	if ha != nil {
+		status["ha"] = ha
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(status)
@@ -58,9 +96,64 @@ func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 type staticLeaseJSON struct {
-	HWAddr   string `json:"mac"`
-	IP       string `json:"ip"`
-	Hostname string `json:"hostname"`
+	HWAddr   string       `json:"mac"`
+	IP       string       `json:"ip"`
+	Hostname string       `json:"hostname"`
+	Options  []DHCPOption `json:"options"`
+
+	ClientID        string   `json:"client_id"`        // hex, e.g. "01:aa:bb:cc:dd:ee:ff"
+	HostnamePattern string   `json:"hostname_pattern"` // e.g. "iot-*"
+	LeaseDuration   uint32   `json:"lease_duration"`   // in seconds
+	Gateway         string   `json:"gateway"`
+	DNS             []string `json:"dns"`
+}
+
+// Convert the static lease from JSON
+func (lj staticLeaseJSON) toLease() (Lease, error) {
+	ip, _ := parseIPv4(lj.IP)
+	if ip == nil {
+		return Lease{}, fmt.Errorf("invalid IP")
+	}
+
+	var mac net.HardwareAddr
+	var err error
+	if len(lj.HWAddr) != 0 {
+		mac, err = net.ParseMAC(lj.HWAddr)
+		if err != nil {
+			return Lease{}, fmt.Errorf("invalid MAC")
+		}
+	}
+
+	clientID, err := parseClientID(lj.ClientID)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	lease := Lease{
+		IP:       ip,
+		HWAddr:   mac,
+		Hostname: lj.Hostname,
+		Options:  lj.Options,
+
+		ClientID:        clientID,
+		HostnamePattern: lj.HostnamePattern,
+		LeaseDuration:   lj.LeaseDuration,
+	}
+
+	if len(lj.Gateway) != 0 {
+		lease.Gateway, err = parseIPv4(lj.Gateway)
+		if err != nil {
+			return Lease{}, fmt.Errorf("invalid gateway: %s", err)
+		}
+	}
+	for _, s := range lj.DNS {
+		ip, err := parseIPv4(s)
+		if err != nil {
+			return Lease{}, fmt.Errorf("invalid DNS server: %s", err)
+		}
+		lease.DNS = append(lease.DNS, ip)
+	}
+	return lease, nil
 }
 
 type dhcpServerConfigJSON struct {
@@ -75,6 +168,10 @@ func (s *Server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 		httpError(r, w, http.StatusBadRequest, "Failed to parse new DHCP config json: %s", err)
 		return
 	}
+	newconfig.Hooks = s.conf.Hooks // not configurable by HTTP request
+	if len(newconfig.HA.Secret) == 0 {
+		newconfig.HA.Secret = s.conf.HA.Secret
+	}
 
 	err = s.CheckConfig(newconfig.ServerConfig)
 	if err != nil {
@@ -246,19 +343,12 @@ func (s *Server) handleDHCPAddStaticLease(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	ip, _ := parseIPv4(lj.IP)
-	if ip == nil {
-		httpError(r, w, http.StatusBadRequest, "invalid IP")
+	lease, err := lj.toLease()
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
 		return
 	}
 
-	mac, _ := net.ParseMAC(lj.HWAddr)
-
-	lease := Lease{
-		IP:       ip,
-		HWAddr:   mac,
-		Hostname: lj.Hostname,
-	}
 	err = s.AddStaticLease(lease)
 	if err != nil {
 		httpError(r, w, http.StatusBadRequest, "%s", err)
@@ -310,13 +400,75 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 	s.conf = ServerConfig{}
 	s.conf.LeaseDuration = 86400
 	s.conf.ICMPTimeout = 1000
+	s.conf.HistoryDays = 90
+	s.conf.RogueCheckInterval = 10
 	s.conf.WorkDir = oldconf.WorkDir
 	s.conf.HTTPRegister = oldconf.HTTPRegister
 	s.conf.ConfigModified = oldconf.ConfigModified
 	s.conf.DBFilePath = oldconf.DBFilePath
+	s.history.clear()
+	s.history.setDays(s.conf.HistoryDays)
 	s.conf.ConfigModified()
 }
 
+// Get the lease history of the device (?mac=...) or the list of all devices
+func (s *Server) handleDHCPHistory(w http.ResponseWriter, r *http.Request) {
+	var resp interface{}
+	q := r.URL.Query().Get("mac")
+	if len(q) != 0 {
+		mac, err := net.ParseMAC(q)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "invalid MAC: %s", err)
+			return
+		}
+		dev := s.history.device(mac.String())
+		if dev == nil {
+			httpError(r, w, http.StatusNotFound, "device %s not found", mac)
+			return
+		}
+		resp = dev
+	} else {
+		resp = map[string]interface{}{
+			"devices": s.history.devices(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+type importLeasesJSON struct {
+	Format string `json:"format"` // "dnsmasq", "isc" or "" (detect by the data)
+	Data   string `json:"data"`   // the content of the configuration or lease file
+	DryRun bool   `json:"dry_run"`
+}
+
+func (s *Server) handleDHCPImport(w http.ResponseWriter, r *http.Request) {
+	req := importLeasesJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	report, err := s.ImportLeases(req.Format, req.Data, req.DryRun)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
 func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister("GET", "/control/dhcp/status", s.handleDHCPStatus)
 	s.conf.HTTPRegister("GET", "/control/dhcp/interfaces", s.handleDHCPInterfaces)
@@ -325,4 +477,7 @@ func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister("POST", "/control/dhcp/add_static_lease", s.handleDHCPAddStaticLease)
 	s.conf.HTTPRegister("POST", "/control/dhcp/remove_static_lease", s.handleDHCPRemoveStaticLease)
 	s.conf.HTTPRegister("POST", "/control/dhcp/reset", s.handleReset)
+	s.conf.HTTPRegister("POST", "/control/dhcp/import", s.handleDHCPImport)
+	s.conf.HTTPRegister("GET", "/control/dhcp/history", s.handleDHCPHistory)
+	s.conf.HTTPRegister("", haPath, s.handleHA) // authenticated by the shared secret
 }
@@ -30,6 +30,32 @@ type Lease struct {
 	// Lease expiration time
 	// 1: static lease
 	Expiry time.Time `json:"expires"`
+
+	// Information used for device fingerprinting
+	VendorClass  string `json:"vendor_class"` // DHCP option 60
+	ParamReqList []byte `json:"-"`            // DHCP option 55
+
+	// DHCP options which override the server's options (static leases only)
+	Options []DHCPOption `json:"options,omitempty" yaml:"options,omitempty"`
+
+	// Static leases only:
+	// the client is matched by MAC address, client identifier (DHCP option 61) or host name pattern
+	ClientID        []byte `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+	HostnamePattern string `json:"hostname_pattern,omitempty" yaml:"hostname_pattern,omitempty"` // e.g. "iot-*"
+
+	// Static leases matched by host name pattern only:
+	// the MAC address of the client which holds the lease and the time until which it's bound to this client
+	BoundHWAddr net.HardwareAddr `json:"-" yaml:"-"`
+	BoundExpiry time.Time        `json:"-" yaml:"-"`
+
+	// DHCPv6 leases only: the client's DUID which identifies the lease
+	// HWAddr is taken from DUID-LLT or DUID-LL and is empty for the other DUID types.
+	DUID []byte `json:"-" yaml:"-"`
+
+	// Static leases only: the overrides of the server's settings
+	LeaseDuration uint32   `json:"lease_duration,omitempty" yaml:"lease_duration,omitempty"` // in seconds
+	Gateway       net.IP   `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	DNS           []net.IP `json:"dns,omitempty" yaml:"dns,omitempty"`
 }
 
 // ServerConfig - DHCP server configuration
@@ -47,6 +73,29 @@ type ServerConfig struct {
 	// 0: disable
 	ICMPTimeout uint32 `json:"icmp_timeout_msec" yaml:"icmp_timeout_msec"`
 
+	// Additional DHCP options which are sent to the clients,
+	//  e.g. NTP servers (42), domain name (15), TFTP server name (66), boot file name (67)
+	Options []DHCPOption `json:"options" yaml:"options"`
+
+	// Additional subnets, e.g. other VLANs or the subnets behind DHCP relays
+	Scopes []ScopeConfig `json:"scopes" yaml:"scopes"`
+
+	// Commands and URLs which are notified about lease events
+	// They are set in the configuration file only: the web API can't run commands.
+	Hooks []HookConfig `json:"-" yaml:"hooks"`
+
+	// How often to check for other DHCP servers in the network (in minutes);  0: disable the monitor
+	RogueCheckInterval uint32 `json:"rogue_check_interval" yaml:"rogue_check_interval"`
+
+	// How long to keep the lease history (in days);  0: the history is disabled
+	HistoryDays uint32 `json:"history_days" yaml:"history_days"`
+
+	// Active-passive high availability with another instance
+	HA HAConfig `json:"ha" yaml:"ha"`
+
+	// DHCPv6 server and Router Advertisement settings
+	V6 V6ServerConfig `json:"v6" yaml:"v6"`
+
 	WorkDir    string `json:"-" yaml:"-"`
 	DBFilePath string `json:"-" yaml:"-"` // path to DB file
 
@@ -71,7 +120,11 @@ const (
 type Server struct {
 	conn *filterConn // listening UDP socket
 
-	ipnet *net.IPNet // if interface name changes, this needs to be reset
+	// The main scope: the subnet of the network interface from config InterfaceName
+	// if interface name changes, this needs to be reset
+	dhcpScope
+
+	scopes []*dhcpScope // additional scopes from config Scopes
 
 	cond     *sync.Cond // Synchronize worker thread with main thread
 	mutex    sync.Mutex // Mutex for 'cond'
@@ -79,16 +132,23 @@ type Server struct {
 	stopping bool       // Set if the worker thread should be stopped
 
 	// leases
-	leases       []*Lease
-	leasesLock   sync.RWMutex
-	leaseStart   net.IP        // parsed from config RangeStart
-	leaseStop    net.IP        // parsed from config RangeEnd
-	leaseTime    time.Duration // parsed from config LeaseDuration
-	leaseOptions dhcp4.Options // parsed from config GatewayIP and SubnetMask
+	leases     []*Lease
+	leasesLock sync.RWMutex
+
+	// Incremented each time the lease table is stored to DB (protected by leasesLock)
+	leasesVersion uint64
 
 	// IP address pool -- if entry is in the pool, then it's attached to a lease
 	IPpool map[[4]byte]net.HardwareAddr
 
+	leases6 []*Lease // DHCPv6 leases (protected by leasesLock)
+	v6      v6Server
+
+	hooks   hooksRunner
+	history leaseHistory
+	rogue   rogueMonitor
+	ha      haState
+
 	conf ServerConfig
 
 	// Called when the leases DB is modified
@@ -132,6 +192,7 @@ func Create(config ServerConfig) *Server {
 	// we can't delay database loading until DHCP server is started,
 	//  because we need static leases functionality available beforehand
 	s.dbLoad()
+	s.history.init(filepath.Join(config.WorkDir, historyFilename), s.conf.HistoryDays)
 	return &s
 }
 
@@ -171,50 +232,51 @@ func (s *Server) setConfig(config ServerConfig) error {
 	}
 
 	// get ipv4 address of an interface
-	s.ipnet = getIfaceIPv4(iface)
-	if s.ipnet == nil {
+	ipnet := getIfaceIPv4(iface)
+	if ipnet == nil {
 		return wrapErrPrint(err, "Couldn't find IPv4 address of interface %s %+v", config.InterfaceName, iface)
 	}
 
-	if config.LeaseDuration == 0 {
-		s.leaseTime = time.Hour * 2
-	} else {
-		s.leaseTime = time.Second * time.Duration(config.LeaseDuration)
+	mainConf := ScopeConfig{
+		GatewayIP:     config.GatewayIP,
+		SubnetMask:    config.SubnetMask,
+		RangeStart:    config.RangeStart,
+		RangeEnd:      config.RangeEnd,
+		LeaseDuration: config.LeaseDuration,
+		Options:       config.Options,
 	}
-
-	s.leaseStart, err = parseIPv4(config.RangeStart)
+	main, err := parseScope(mainConf, ipnet)
 	if err != nil {
-		return wrapErrPrint(err, "Failed to parse range start address %s", config.RangeStart)
+		return err
 	}
+	main.iface = iface
 
-	s.leaseStop, err = parseIPv4(config.RangeEnd)
+	scopes, err := parseScopes(config.Scopes, main)
 	if err != nil {
-		return wrapErrPrint(err, "Failed to parse range end address %s", config.RangeEnd)
-	}
-	if dhcp4.IPRange(s.leaseStart, s.leaseStop) <= 0 {
-		return wrapErrPrint(err, "DHCP: Incorrect range_start/range_end values")
+		return err
 	}
 
-	subnet, err := parseIPv4(config.SubnetMask)
-	if err != nil || !isValidSubnetMask(subnet) {
-		return wrapErrPrint(err, "Failed to parse subnet mask %s", config.SubnetMask)
+	hooks, err := parseHooks(config.Hooks)
+	if err != nil {
+		return wrapErrPrint(err, "Invalid hooks")
 	}
 
-	// if !bytes.Equal(subnet, s.ipnet.Mask) {
-	// 	return wrapErrPrint(err, "specified subnet mask %s does not meatch interface %s subnet mask %s", s.SubnetMask, s.InterfaceName, s.ipnet.Mask)
-	// }
-
-	router, err := parseIPv4(config.GatewayIP)
+	err = s.setConfig6(config.V6)
 	if err != nil {
-		return wrapErrPrint(err, "Failed to parse gateway IP %s", config.GatewayIP)
+		return err
 	}
 
-	s.leaseOptions = dhcp4.Options{
-		dhcp4.OptionSubnetMask:       subnet,
-		dhcp4.OptionRouter:           router,
-		dhcp4.OptionDomainNameServer: s.ipnet.IP,
+	err = s.ha.setConfig(config.HA)
+	if err != nil {
+		return wrapErrPrint(err, "Invalid HA settings")
 	}
 
+	s.dhcpScope = *main
+	s.scopes = scopes
+	s.hooks.list = hooks
+	s.history.setDays(config.HistoryDays)
+	s.rogue.setInterval(config.RogueCheckInterval)
+
 	oldconf := s.conf
 	s.conf = config
 	s.conf.WorkDir = oldconf.WorkDir
@@ -225,7 +287,25 @@ func (s *Server) setConfig(config ServerConfig) error {
 }
 
 // Start will listen on port 67 and serve DHCP requests.
+// With HA enabled, the standby instance serves DHCP requests only when the primary is down.
 func (s *Server) Start() error {
+	if s.conf.HA.Enabled {
+		return s.startHA()
+	}
+	return s.start()
+}
+
+// Stop stops serving DHCP requests
+func (s *Server) Stop() error {
+	err := s.stopHA()
+	if err != nil {
+		return err
+	}
+	return s.stop()
+}
+
+// Listen on port 67 and serve DHCP requests
+func (s *Server) start() error {
 	// TODO: don't close if interface and addresses are the same
 	if s.conn != nil {
 		_ = s.closeConn()
@@ -236,7 +316,7 @@ func (s *Server) Start() error {
 		return wrapErrPrint(err, "Couldn't find interface by name %s", s.conf.InterfaceName)
 	}
 
-	c, err := newFilterConn(*iface, ":67") // it has to be bound to 0.0.0.0:67, otherwise it won't see DHCP discover/request packets
+	c, err := newFilterConn(":67") // it has to be bound to 0.0.0.0:67, otherwise it won't see DHCP discover/request packets
 	if err != nil {
 		return wrapErrPrint(err, "Couldn't start listening socket on 0.0.0.0:67")
 	}
@@ -244,13 +324,26 @@ func (s *Server) Start() error {
 
 	s.conn = c
 	s.cond = sync.NewCond(&s.mutex)
+	s.stopping = false
+
+	s.stop6()
+	s.stopRogueMonitor()
+	s.stopHooks()
+	s.startHooks()
+	s.startRogueMonitor()
+
+	err = s.start6(iface)
+	if err != nil {
+		// DHCPv4 server works anyway
+		log.Error("DHCPv6: %s", err)
+	}
 
 	s.running = true
 	go func() {
 		// operate on c instead of c.conn because c.conn can change over time
-		err := dhcp4.Serve(c, s)
+		err := s.serve4(c)
 		if err != nil && !s.stopping {
-			log.Printf("dhcp4.Serve() returned with error: %s", err)
+			log.Printf("DHCP: serve4() returned with error: %s", err)
 		}
 		_ = c.Close() // in case Serve() exits for other reason than listening socket closure
 		s.running = false
@@ -260,14 +353,15 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop closes the listening UDP socket
-func (s *Server) Stop() error {
+// Close the listening UDP socket
+func (s *Server) stop() error {
 	if s.conn == nil {
 		// nothing to do, return silently
 		return nil
 	}
 
 	s.stopping = true
+	s.stop6()
 
 	err := s.closeConn()
 	if err != nil {
@@ -281,6 +375,9 @@ func (s *Server) Stop() error {
 		s.cond.Wait()
 	}
 	s.mutex.Unlock()
+
+	s.stopRogueMonitor()
+	s.stopHooks()
 	return nil
 }
 
@@ -294,8 +391,8 @@ func (s *Server) closeConn() error {
 	return err
 }
 
-// Reserve a lease for the client
-func (s *Server) reserveLease(p dhcp4.Packet) (*Lease, error) {
+// Reserve a lease for the client in the scope
+func (s *Server) reserveLease(sc *dhcpScope, p dhcp4.Packet) (*Lease, error) {
 	// WARNING: do not remove copy()
 	// the given hwaddr by p.CHAddr() in the packet survives only during ServeDHCP() call
 	// since we need to retain it we need to make our own copy
@@ -303,17 +400,19 @@ func (s *Server) reserveLease(p dhcp4.Packet) (*Lease, error) {
 	hwaddr := make(net.HardwareAddr, len(hwaddrCOW))
 	copy(hwaddr, hwaddrCOW)
 	// not assigned a lease, create new one, find IP from LRU
-	hostname := p.ParseOptions()[dhcp4.OptionHostName]
+	options := p.ParseOptions()
+	hostname := options[dhcp4.OptionHostName]
 	lease := &Lease{HWAddr: hwaddr, Hostname: string(hostname)}
+	setLeaseFingerprint(lease, options)
 
 	log.Tracef("Lease not found for %s: creating new one", hwaddr)
 
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	ip, err := s.findFreeIP(hwaddr)
+	ip, err := s.findFreeIP(sc, hwaddr)
 	if err != nil {
-		i := s.findExpiredLease()
+		i := s.findExpiredLease(sc)
 		if i < 0 {
 			return nil, wrapErrPrint(err, "Couldn't find free IP for the lease %s", hwaddr.String())
 		}
@@ -333,37 +432,62 @@ func (s *Server) reserveLease(p dhcp4.Packet) (*Lease, error) {
 	return lease, nil
 }
 
+// Save the options which describe the client's DHCP implementation
+func setLeaseFingerprint(lease *Lease, options dhcp4.Options) {
+	vendor, ok := options[dhcp4.OptionVendorClassIdentifier]
+	if ok {
+		lease.VendorClass = string(vendor)
+	}
+
+	prl, ok := options[dhcp4.OptionParameterRequestList]
+	if ok {
+		lease.ParamReqList = make([]byte, len(prl))
+		copy(lease.ParamReqList, prl)
+	}
+}
+
 // Find a lease for the client
 func (s *Server) findLease(p dhcp4.Packet) *Lease {
 	hwaddr := p.CHAddr()
+	var dynLease *Lease
 	for i := range s.leases {
 		if bytes.Equal([]byte(hwaddr), []byte(s.leases[i].HWAddr)) {
 			// log.Tracef("bytes.Equal(%s, %s) returned true", hwaddr, s.leases[i].hwaddr)
-			return s.leases[i]
+			if s.leases[i].Expiry.Unix() == leaseExpireStatic {
+				return s.leases[i]
+			}
+			dynLease = s.leases[i]
+			break
 		}
 	}
-	return nil
+
+	lease := s.findStaticLeaseByClient(p)
+	if lease != nil {
+		return lease
+	}
+	return dynLease
 }
 
-// Find an expired lease and return its index or -1
-func (s *Server) findExpiredLease() int {
+// Find an expired lease in the scope and return its index or -1
+func (s *Server) findExpiredLease(sc *dhcpScope) int {
 	now := time.Now().Unix()
 	for i, lease := range s.leases {
-		if lease.Expiry.Unix() <= now && lease.Expiry.Unix() != leaseExpireStatic {
+		if lease.Expiry.Unix() <= now && lease.Expiry.Unix() != leaseExpireStatic &&
+			ipInRange(sc.leaseStart, sc.leaseStop, lease.IP) {
 			return i
 		}
 	}
 	return -1
 }
 
-func (s *Server) findFreeIP(hwaddr net.HardwareAddr) (net.IP, error) {
+func (s *Server) findFreeIP(sc *dhcpScope, hwaddr net.HardwareAddr) (net.IP, error) {
 	// go from start to end, find unreserved IP
 	var foundIP net.IP
-	for i := 0; i < dhcp4.IPRange(s.leaseStart, s.leaseStop); i++ {
-		newIP := dhcp4.IPAdd(s.leaseStart, i)
+	for i := 0; i < dhcp4.IPRange(sc.leaseStart, sc.leaseStop); i++ {
+		newIP := dhcp4.IPAdd(sc.leaseStart, i)
 		foundHWaddr := s.findReservedHWaddr(newIP)
 		log.Tracef("tried IP %v, got hwaddr %v", newIP, foundHWaddr)
-		if foundHWaddr != nil && len(foundHWaddr) != 0 {
+		if s.ipReserved(newIP) {
 			// if !bytes.Equal(foundHWaddr, hwaddr) {
 			// 	log.Tracef("SHOULD NOT HAPPEN: hwaddr in IP pool %s is not equal to hwaddr in lease %s", foundHWaddr, hwaddr)
 			// }
@@ -389,6 +513,15 @@ func (s *Server) findReservedHWaddr(ip net.IP) net.HardwareAddr {
 	return s.IPpool[IP4]
 }
 
+// Return TRUE if the IP address is reserved
+// A static lease without MAC address reserves the IP address with an empty hwaddr.
+func (s *Server) ipReserved(ip net.IP) bool {
+	rawIP := []byte(ip)
+	IP4 := [4]byte{rawIP[0], rawIP[1], rawIP[2], rawIP[3]}
+	_, ok := s.IPpool[IP4]
+	return ok
+}
+
 func (s *Server) reserveIP(ip net.IP, hwaddr net.HardwareAddr) {
 	rawIP := []byte(ip)
 	IP4 := [4]byte{rawIP[0], rawIP[1], rawIP[2], rawIP[3]}
@@ -401,21 +534,24 @@ func (s *Server) unreserveIP(ip net.IP) {
 	delete(s.IPpool, IP4)
 }
 
-// ServeDHCP handles an incoming DHCP request
-func (s *Server) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+// serveDHCP handles an incoming DHCP request for the scope
+func (s *Server) serveDHCP(sc *dhcpScope, p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	if s.isOwnPacket(p) {
+		return nil
+	}
 	s.printLeases()
 
 	switch msgType {
 	case dhcp4.Discover: // Broadcast Packet From Client - Can I have an IP?
-		return s.handleDiscover(p, options)
+		return s.handleDiscover(sc, p, options)
 
 	case dhcp4.Request: // Broadcast From Client - I'll take that IP (Also start for renewals)
 		// start/renew a lease -- update lease time
 		// some clients (OSX) just go right ahead and do Request first from previously known IP, if they get NAK, they restart full cycle with Discover then Request
-		return s.handleDHCP4Request(p, options)
+		return s.handleDHCP4Request(sc, p, options)
 
 	case dhcp4.Decline: // Broadcast From Client - Sorry I can't use that IP
-		return s.handleDecline(p, options)
+		return s.handleDecline(sc, p, options)
 
 	case dhcp4.Release: // From Client, I don't need that IP anymore
 		return s.handleRelease(p, options)
@@ -475,13 +611,13 @@ func (s *Server) addrAvailable(target net.IP) bool {
 }
 
 // Add the specified IP to the black list for a time period
-func (s *Server) blacklistLease(lease *Lease) {
+func (s *Server) blacklistLease(sc *dhcpScope, lease *Lease) {
 	hw := make(net.HardwareAddr, 6)
 	s.leasesLock.Lock()
 	s.reserveIP(lease.IP, hw)
 	lease.HWAddr = hw
 	lease.Hostname = ""
-	lease.Expiry = time.Now().Add(s.leaseTime)
+	lease.Expiry = time.Now().Add(sc.leaseTime)
 	s.dbStore()
 	s.leasesLock.Unlock()
 	s.notify(LeaseChangedBlacklisted)
@@ -498,7 +634,7 @@ func isValidPacket(p dhcp4.Packet) bool {
 	return true
 }
 
-func (s *Server) handleDiscover(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
+func (s *Server) handleDiscover(sc *dhcpScope, p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
 	// find a lease, but don't update lease time
 	var lease *Lease
 	var err error
@@ -513,15 +649,29 @@ func (s *Server) handleDiscover(p dhcp4.Packet, options dhcp4.Options) dhcp4.Pac
 	}
 
 	lease = s.findLease(p)
+	if lease != nil && !sc.leaseAllowed(lease) {
+		if lease.Expiry.Unix() == leaseExpireStatic {
+			log.Tracef("Static lease %s for %s is not within the subnet %s", lease.IP, lease.HWAddr, sc.subnet)
+			return nil
+		}
+
+		// the client has moved to another subnet
+		log.Tracef("Lease %s for %s is not within the current range: removing", lease.IP, lease.HWAddr)
+		s.leasesLock.Lock()
+		_ = s.rmDynamicLeaseWithMAC(lease.HWAddr)
+		s.leasesLock.Unlock()
+		lease = nil
+	}
+
 	for lease == nil {
-		lease, err = s.reserveLease(p)
+		lease, err = s.reserveLease(sc, p)
 		if err != nil {
 			log.Error("Couldn't find free lease: %s", err)
 			return nil
 		}
 
 		if !s.addrAvailable(lease.IP) {
-			s.blacklistLease(lease)
+			s.blacklistLease(sc, lease)
 			lease = nil
 			continue
 		}
@@ -529,13 +679,14 @@ func (s *Server) handleDiscover(p dhcp4.Packet, options dhcp4.Options) dhcp4.Pac
 		break
 	}
 
-	opt := s.leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList])
-	reply := dhcp4.ReplyPacket(p, dhcp4.Offer, s.ipnet.IP, lease.IP, s.leaseTime, opt)
-	log.Tracef("Replying with offer: offered IP %v for %v with options %+v", lease.IP, s.leaseTime, reply.ParseOptions())
+	opt := s.getLeaseOptions(sc, lease).SelectOrderOrAll(options[dhcp4.OptionParameterRequestList])
+	leaseTime := sc.leaseDuration(lease)
+	reply := dhcp4.ReplyPacket(p, dhcp4.Offer, sc.ipnet.IP, lease.IP, leaseTime, opt)
+	log.Tracef("Replying with offer: offered IP %v for %v with options %+v", lease.IP, leaseTime, reply.ParseOptions())
 	return reply
 }
 
-func (s *Server) handleDHCP4Request(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
+func (s *Server) handleDHCP4Request(sc *dhcpScope, p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
 	var lease *Lease
 
 	reqIP := net.IP(options[dhcp4.OptionRequestedIPAddress])
@@ -547,8 +698,8 @@ func (s *Server) handleDHCP4Request(p dhcp4.Packet, options dhcp4.Options) dhcp4
 	}
 
 	server := options[dhcp4.OptionServerIdentifier]
-	if server != nil && !net.IP(server).Equal(s.ipnet.IP) {
-		log.Tracef("Request message not for this DHCP server (%v vs %v)", server, s.ipnet.IP)
+	if server != nil && !net.IP(server).Equal(sc.ipnet.IP) {
+		log.Tracef("Request message not for this DHCP server (%v vs %v)", server, sc.ipnet.IP)
 		return nil // Message not for this dhcp server
 	}
 
@@ -557,32 +708,44 @@ func (s *Server) handleDHCP4Request(p dhcp4.Packet, options dhcp4.Options) dhcp4
 
 	} else if reqIP == nil || reqIP.To4() == nil {
 		log.Tracef("Requested IP isn't a valid IPv4: %s", reqIP)
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, s.ipnet.IP, nil, 0, nil)
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, sc.ipnet.IP, nil, 0, nil)
 	}
 
 	lease = s.findLease(p)
 	if lease == nil {
 		log.Tracef("Lease for %s isn't found", p.CHAddr())
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, s.ipnet.IP, nil, 0, nil)
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, sc.ipnet.IP, nil, 0, nil)
 	}
 
 	if !lease.IP.Equal(reqIP) {
 		log.Tracef("Lease for %s doesn't match requested/client IP: %s vs %s",
 			lease.HWAddr, lease.IP, reqIP)
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, s.ipnet.IP, nil, 0, nil)
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, sc.ipnet.IP, nil, 0, nil)
+	}
+
+	if !sc.leaseAllowed(lease) {
+		log.Tracef("Lease %s for %s is not within the current subnet", lease.IP, lease.HWAddr)
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, sc.ipnet.IP, nil, 0, nil)
 	}
 
 	if lease.Expiry.Unix() != leaseExpireStatic {
-		lease.Expiry = time.Now().Add(s.leaseTime)
 		s.leasesLock.Lock()
+		event := commitEvent(lease)
+		lease.Expiry = time.Now().Add(sc.leaseTime)
+		setLeaseFingerprint(lease, options)
 		s.dbStore()
+		l := *lease
 		s.leasesLock.Unlock()
+		s.leaseEvent(event, &l)
 		s.notify(LeaseChangedAdded) // Note: maybe we shouldn't call this function if only expiration time is updated
+	} else {
+		s.bindStaticLease(lease, p, sc.leaseDuration(lease))
+		s.staticLeaseEvent(lease, p.CHAddr())
 	}
 	log.Tracef("Replying with ACK.  IP: %s  HW: %s  Expire: %s",
 		lease.IP, lease.HWAddr, lease.Expiry)
-	opt := s.leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList])
-	return dhcp4.ReplyPacket(p, dhcp4.ACK, s.ipnet.IP, lease.IP, s.leaseTime, opt)
+	opt := s.getLeaseOptions(sc, lease).SelectOrderOrAll(options[dhcp4.OptionParameterRequestList])
+	return dhcp4.ReplyPacket(p, dhcp4.ACK, sc.ipnet.IP, lease.IP, sc.leaseDuration(lease), opt)
 }
 
 func (s *Server) handleInform(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
@@ -592,40 +755,68 @@ func (s *Server) handleInform(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packe
 	return nil
 }
 
+// The client doesn't need the address anymore: the lease may be used by another client
 func (s *Server) handleRelease(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
 	log.Tracef("Message from client: Release.  IP: %s  HW: %s",
 		p.CIAddr(), p.CHAddr())
 
+	s.leasesLock.Lock()
+	lease := s.findLease(p)
+	if lease == nil || lease.Expiry.Unix() == leaseExpireStatic ||
+		!lease.IP.Equal(p.CIAddr()) || !lease.Expiry.After(time.Now()) {
+		s.leasesLock.Unlock()
+		return nil
+	}
+	lease.Expiry = time.Unix(0, 0) // the lease isn't stored in DB and may be reused
+	s.dbStore()
+	l := *lease
+	s.leasesLock.Unlock()
+	s.leaseEvent(hookEventRelease, &l)
+	s.notify(LeaseChangedAdded)
 	return nil
 }
 
-func (s *Server) handleDecline(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
+// The address is already used by another device: don't use it for a lease time period
+func (s *Server) handleDecline(sc *dhcpScope, p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
 	reqIP := net.IP(options[dhcp4.OptionRequestedIPAddress])
 	log.Tracef("Message from client: Decline.  IP: %s  HW: %s",
 		reqIP, p.CHAddr())
 
+	s.leasesLock.Lock()
+	lease := s.findLease(p)
+	if lease == nil || lease.Expiry.Unix() == leaseExpireStatic ||
+		!lease.IP.Equal(reqIP) {
+		s.leasesLock.Unlock()
+		return nil
+	}
+	l := *lease
+	s.leasesLock.Unlock()
+	s.leaseEvent(hookEventDecline, &l)
+
+	log.Info("DHCP: IP conflict: %v is already used by another device", lease.IP)
+	s.blacklistLease(sc, lease)
 	return nil
 }
 
 // AddStaticLease adds a static lease (thread-safe)
 func (s *Server) AddStaticLease(l Lease) error {
-	if len(l.IP) != 4 {
-		return fmt.Errorf("invalid IP")
-	}
-	if len(l.HWAddr) != 6 {
-		return fmt.Errorf("invalid MAC")
-	}
 	l.Expiry = time.Unix(leaseExpireStatic, 0)
 
 	s.leasesLock.Lock()
 
-	if s.findReservedHWaddr(l.IP) != nil {
+	err := s.checkStaticLease(l)
+	if err != nil {
+		s.leasesLock.Unlock()
+		return err
+	}
+
+	if s.ipReserved(l.IP) {
 		err := s.rmDynamicLeaseWithIP(l.IP)
 		if err != nil {
 			s.leasesLock.Unlock()
 			return err
 		}
-	} else {
+	} else if len(l.HWAddr) != 0 {
 		err := s.rmDynamicLeaseWithMAC(l.HWAddr)
 		if err != nil {
 			s.leasesLock.Unlock()
@@ -697,13 +888,13 @@ func (s *Server) RemoveStaticLease(l Lease) error {
 	if len(l.IP) != 4 {
 		return fmt.Errorf("invalid IP")
 	}
-	if len(l.HWAddr) != 6 {
+	if len(l.HWAddr) != 0 && len(l.HWAddr) != 6 {
 		return fmt.Errorf("invalid MAC")
 	}
 
 	s.leasesLock.Lock()
 
-	if s.findReservedHWaddr(l.IP) == nil {
+	if !s.ipReserved(l.IP) {
 		s.leasesLock.Unlock()
 		return fmt.Errorf("lease not found")
 	}
@@ -737,6 +928,12 @@ func (s *Server) Leases(flags int) []Lease {
 			result = append(result, *lease)
 		}
 	}
+	for _, lease := range s.leases6 {
+		if ((flags&LeasesDynamic) != 0 && lease.Expiry.Unix() > now) ||
+			((flags&LeasesStatic) != 0 && lease.Expiry.Unix() == leaseExpireStatic) {
+			result = append(result, *lease)
+		}
+	}
 	s.leasesLock.RUnlock()
 
 	return result
@@ -771,13 +968,13 @@ func (s *Server) FindMACbyIP(ip net.IP) net.HardwareAddr {
 	s.leasesLock.RLock()
 	defer s.leasesLock.RUnlock()
 
-	ip4 := ip.To4()
-	if ip4 == nil {
-		return nil
+	leases := s.leases
+	if ip.To4() == nil {
+		leases = s.leases6
 	}
 
-	for _, l := range s.leases {
-		if l.IP.Equal(ip4) {
+	for _, l := range leases {
+		if l.IP.Equal(ip) {
 			unix := l.Expiry.Unix()
 			if unix > now || unix == leaseExpireStatic {
 				return l.HWAddr
@@ -791,6 +988,7 @@ func (s *Server) FindMACbyIP(ip net.IP) net.HardwareAddr {
 func (s *Server) reset() {
 	s.leasesLock.Lock()
 	s.leases = nil
+	s.leases6 = nil
 	s.IPpool = make(map[[4]byte]net.HardwareAddr)
 	s.leasesLock.Unlock()
 }
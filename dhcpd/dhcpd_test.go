@@ -47,7 +47,7 @@ func TestDHCP(t *testing.T) {
 	p.SetCHAddr(hw)
 	p.SetCIAddr([]byte{0, 0, 0, 0})
 	opt = make(dhcp4.Options, 10)
-	p2 = s.handleDiscover(p, opt)
+	p2 = s.handleDiscover(&s.dhcpScope, p, opt)
 	opt = p2.ParseOptions()
 	check(t, bytes.Equal(opt[dhcp4.OptionDHCPMessageType], []byte{byte(dhcp4.Offer)}), "dhcp4.Offer")
 	check(t, bytes.Equal(p2.YIAddr(), []byte{1, 1, 1, 1}), "p2.YIAddr")
@@ -62,7 +62,7 @@ func TestDHCP(t *testing.T) {
 	// Reserve an IP - the next IP from the range
 	hw = []byte{2, 2, 3, 4, 5, 6}
 	p.SetCHAddr(hw)
-	lease, _ = s.reserveLease(p)
+	lease, _ = s.reserveLease(&s.dhcpScope, p)
 	check(t, bytes.Equal(lease.HWAddr, hw), "lease.HWAddr")
 	check(t, bytes.Equal(lease.IP, []byte{1, 1, 1, 2}), "lease.IP")
 
@@ -70,7 +70,7 @@ func TestDHCP(t *testing.T) {
 	//  so the first expired (or, in our case, not yet committed) lease is returned
 	hw = []byte{1, 2, 3, 4, 5, 6}
 	p.SetCHAddr(hw)
-	lease, _ = s.reserveLease(p)
+	lease, _ = s.reserveLease(&s.dhcpScope, p)
 	check(t, bytes.Equal(lease.HWAddr, hw), "lease.HWAddr")
 	check(t, bytes.Equal(lease.IP, []byte{1, 1, 1, 1}), "lease.IP")
 
@@ -81,7 +81,7 @@ func TestDHCP(t *testing.T) {
 	opt = make(dhcp4.Options, 10)
 	// ask a different IP
 	opt[dhcp4.OptionRequestedIPAddress] = []byte{1, 1, 1, 2}
-	p2 = s.handleDHCP4Request(p, opt)
+	p2 = s.handleDHCP4Request(&s.dhcpScope, p, opt)
 	opt = p2.ParseOptions()
 	check(t, bytes.Equal(opt[dhcp4.OptionDHCPMessageType], []byte{byte(dhcp4.NAK)}), "dhcp4.NAK")
 
@@ -91,7 +91,7 @@ func TestDHCP(t *testing.T) {
 	p.SetCIAddr([]byte{0, 0, 0, 0})
 	opt = make(dhcp4.Options, 10)
 	opt[dhcp4.OptionRequestedIPAddress] = []byte{1, 1, 1, 1}
-	p2 = s.handleDHCP4Request(p, opt)
+	p2 = s.handleDHCP4Request(&s.dhcpScope, p, opt)
 	opt = p2.ParseOptions()
 	check(t, bytes.Equal(opt[dhcp4.OptionDHCPMessageType], []byte{byte(dhcp4.ACK)}), "dhcp4.ACK")
 	check(t, bytes.Equal(p2.YIAddr(), []byte{1, 1, 1, 1}), "p2.YIAddr")
@@ -107,13 +107,13 @@ func TestDHCP(t *testing.T) {
 	p.SetCIAddr([]byte{0, 0, 0, 0})
 	opt = make(dhcp4.Options, 10)
 	opt[dhcp4.OptionRequestedIPAddress] = []byte{1, 1, 1, 2}
-	p2 = s.handleDHCP4Request(p, opt)
+	p2 = s.handleDHCP4Request(&s.dhcpScope, p, opt)
 	check(t, bytes.Equal(p2.YIAddr(), []byte{1, 1, 1, 2}), "p2.YIAddr")
 
 	// Reserve an IP - we have no more available IPs
 	hw = []byte{3, 2, 3, 4, 5, 6}
 	p.SetCHAddr(hw)
-	lease, _ = s.reserveLease(p)
+	lease, _ = s.reserveLease(&s.dhcpScope, p)
 	check(t, lease == nil, "lease == nil")
 
 	s.reset()
@@ -184,7 +184,7 @@ func misc(t *testing.T, s *Server) {
 	p.SetCIAddr([]byte{0, 0, 0, 0})
 	opt = make(dhcp4.Options, 10)
 	opt[dhcp4.OptionRequestedIPAddress] = []byte{1, 1, 1, 1}
-	p2 = s.handleDHCP4Request(p, opt)
+	p2 = s.handleDHCP4Request(&s.dhcpScope, p, opt)
 	opt = p2.ParseOptions()
 	check(t, bytes.Equal(opt[dhcp4.OptionDHCPMessageType], []byte{byte(dhcp4.NAK)}), "dhcp4.NAK")
 }
@@ -211,12 +211,12 @@ func TestDB(t *testing.T) {
 
 	hw1 = []byte{1, 2, 3, 4, 5, 6}
 	p.SetCHAddr(hw1)
-	lease, _ = s.reserveLease(p)
+	lease, _ = s.reserveLease(&s.dhcpScope, p)
 	lease.Expiry = time.Unix(4000000001, 0)
 
 	hw2 = []byte{2, 2, 3, 4, 5, 6}
 	p.SetCHAddr(hw2)
-	lease, _ = s.reserveLease(p)
+	lease, _ = s.reserveLease(&s.dhcpScope, p)
 	lease.Expiry = time.Unix(4000000002, 0)
 
 	_ = os.Remove("leases.db")
@@ -0,0 +1,554 @@
+// DHCPv6 server
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/net/ipv6"
+)
+
+const maxLeases6 = 0x10000 // max number of addresses in DHCPv6 range
+
+// V6ServerConfig - DHCPv6 server configuration
+// field ordering is important -- yaml fields will mirror ordering from here
+type V6ServerConfig struct {
+	Enabled       bool   `json:"enabled" yaml:"enabled"`
+	RangeStart    string `json:"range_start" yaml:"range_start"`
+	RangeEnd      string `json:"range_end" yaml:"range_end"`
+	LeaseDuration uint32 `json:"lease_duration" yaml:"lease_duration"` // in seconds
+
+	// Send ICMPv6 Router Advertisement messages with the address of our DNS server
+	RAEnabled bool `json:"ra_enabled" yaml:"ra_enabled"`
+
+	// Announce the network prefix in Router Advertisement messages
+	//  so that the clients can configure their addresses themselves (SLAAC)
+	RASLAAC bool `json:"ra_slaac" yaml:"ra_slaac"`
+}
+
+// v6Server - the state of DHCPv6 server
+type v6Server struct {
+	conn *ipv6.PacketConn // listening UDP socket
+	ra   *raSender        // Router Advertisement sender
+	wg   sync.WaitGroup   // worker thread
+
+	rangeStart net.IP        // parsed from config RangeStart
+	rangeSize  uint32        // number of addresses in range
+	leaseTime  time.Duration // parsed from config LeaseDuration
+	dnsIP      net.IP        // our IPv6 address which is sent to the clients as DNS server
+	sid        []byte        // server DUID
+}
+
+// Parse DHCPv6 configuration
+func (s *Server) setConfig6(conf V6ServerConfig) error {
+	s.v6.rangeStart = nil
+	s.v6.rangeSize = 0
+	if !conf.Enabled {
+		return nil
+	}
+
+	if conf.LeaseDuration == 0 {
+		s.v6.leaseTime = time.Hour * 24
+	} else {
+		s.v6.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
+	}
+
+	start, err := parseIPv6(conf.RangeStart)
+	if err != nil {
+		return wrapErrPrint(err, "Failed to parse DHCPv6 range start address %s", conf.RangeStart)
+	}
+	end, err := parseIPv6(conf.RangeEnd)
+	if err != nil {
+		return wrapErrPrint(err, "Failed to parse DHCPv6 range end address %s", conf.RangeEnd)
+	}
+
+	// the range must be within 1 /64 network
+	if !bytes.Equal(start[:8], end[:8]) {
+		return wrapErrPrint(nil, "DHCPv6: range_start and range_end must be within the same /64 network")
+	}
+	first := binary.BigEndian.Uint64(start[8:])
+	last := binary.BigEndian.Uint64(end[8:])
+	if last < first || last-first >= maxLeases6 {
+		return wrapErrPrint(nil, "DHCPv6: Incorrect range_start/range_end values (max %d addresses)", maxLeases6)
+	}
+
+	s.v6.rangeStart = start
+	s.v6.rangeSize = uint32(last-first) + 1
+	return nil
+}
+
+func parseIPv6(text string) (net.IP, error) {
+	result := net.ParseIP(text)
+	if result == nil {
+		return nil, fmt.Errorf("%s is not an IP address", text)
+	}
+	if result.To4() != nil {
+		return nil, fmt.Errorf("%s is not an IPv6 address", text)
+	}
+	return result, nil
+}
+
+// Get the IP address at the specified offset in DHCPv6 range
+func (s *Server) ip6At(i uint32) net.IP {
+	ip := make(net.IP, 16)
+	copy(ip, s.v6.rangeStart)
+	n := binary.BigEndian.Uint64(ip[8:]) + uint64(i)
+	binary.BigEndian.PutUint64(ip[8:], n)
+	return ip
+}
+
+// Return TRUE if the IP address is within DHCPv6 range
+func (s *Server) ip6InRange(ip net.IP) bool {
+	if s.v6.rangeStart == nil || len(ip) != 16 || !bytes.Equal(ip[:8], s.v6.rangeStart[:8]) {
+		return false
+	}
+	first := binary.BigEndian.Uint64(s.v6.rangeStart[8:])
+	n := binary.BigEndian.Uint64(ip[8:])
+	return n >= first && n-first < uint64(s.v6.rangeSize)
+}
+
+// Get IPv6 addresses of the network interface:
+// the first link-local address and the first address of the other scope (e.g. global or ULA)
+func getIfaceIPv6(iface *net.Interface) (linkLocal net.IP, global *net.IPNet) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		log.Error("DHCPv6: %s", err)
+		return nil, nil
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil {
+			continue
+		}
+		if ipnet.IP.IsLinkLocalUnicast() {
+			if linkLocal == nil {
+				linkLocal = ipnet.IP
+			}
+		} else if global == nil && ipnet.IP.IsGlobalUnicast() {
+			global = ipnet
+		}
+	}
+	return linkLocal, global
+}
+
+// Start DHCPv6 server and Router Advertisement sender
+func (s *Server) start6(iface *net.Interface) error {
+	conf := s.conf.V6
+	if !conf.Enabled && !conf.RAEnabled {
+		return nil
+	}
+
+	linkLocal, global := getIfaceIPv6(iface)
+	if linkLocal == nil && global == nil {
+		return fmt.Errorf("couldn't find IPv6 address of interface %s", iface.Name)
+	}
+	s.v6.dnsIP = linkLocal
+	if global != nil {
+		s.v6.dnsIP = global.IP
+	}
+	s.v6.sid = newDUIDLL(iface.HardwareAddr)
+
+	if conf.RAEnabled {
+		ra := &raSender{
+			iface:   iface,
+			managed: conf.Enabled,
+			slaac:   conf.RASLAAC,
+			dnsIP:   s.v6.dnsIP,
+		}
+		if global != nil {
+			ra.prefix = global
+		}
+		err := ra.start()
+		if err != nil {
+			return wrapErrPrint(err, "Couldn't start sending Router Advertisement messages")
+		}
+		s.v6.ra = ra
+	}
+
+	if !conf.Enabled {
+		return nil
+	}
+
+	c, err := net.ListenPacket("udp6", "[::]:547")
+	if err != nil {
+		return wrapErrPrint(err, "Couldn't start listening socket on [::]:547")
+	}
+	p := ipv6.NewPacketConn(c)
+	err = p.SetControlMessage(ipv6.FlagInterface, true)
+	if err != nil {
+		_ = c.Close()
+		return wrapErrPrint(err, "Couldn't set control message FlagInterface on connection")
+	}
+	// All_DHCP_Relay_Agents_and_Servers
+	err = p.JoinGroup(iface, &net.UDPAddr{IP: net.ParseIP("ff02::1:2")})
+	if err != nil {
+		_ = c.Close()
+		return wrapErrPrint(err, "Couldn't join DHCPv6 multicast group")
+	}
+	log.Info("DHCPv6: listening on [::]:547")
+
+	s.v6.conn = p
+	s.v6.wg.Add(1)
+	go s.serve6(p, iface)
+	return nil
+}
+
+// Stop DHCPv6 server and Router Advertisement sender
+func (s *Server) stop6() {
+	if s.v6.ra != nil {
+		s.v6.ra.stop()
+		s.v6.ra = nil
+	}
+	if s.v6.conn != nil {
+		_ = s.v6.conn.Close()
+		s.v6.wg.Wait()
+		s.v6.conn = nil
+	}
+}
+
+// Receive DHCPv6 messages and send the responses
+func (s *Server) serve6(conn *ipv6.PacketConn, iface *net.Interface) {
+	defer s.v6.wg.Done()
+
+	buf := make([]byte, 4096)
+	for {
+		n, cm, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !s.stopping {
+				log.Error("DHCPv6: ReadFrom: %s", err)
+			}
+			return
+		}
+		if cm != nil && cm.IfIndex != iface.Index {
+			continue
+		}
+
+		req, err := parseV6Msg(buf[:n])
+		if err != nil {
+			log.Debug("DHCPv6: invalid message from %s: %s", addr, err)
+			continue
+		}
+
+		resp := s.process6(req)
+		if resp == nil {
+			continue
+		}
+
+		wcm := ipv6.ControlMessage{IfIndex: iface.Index}
+		_, err = conn.WriteTo(resp.pack(), &wcm, addr)
+		if err != nil {
+			log.Debug("DHCPv6: WriteTo: %s", err)
+		}
+	}
+}
+
+// Process a message from DHCPv6 client
+// Return nil if there's nothing to send back
+func (s *Server) process6(req *v6Msg) *v6Msg {
+	cid := req.get(v6OptClientID)
+	if cid == nil {
+		log.Tracef("DHCPv6: message type %d without Client ID", req.typ)
+		return nil
+	}
+
+	sid := req.get(v6OptServerID)
+	switch req.typ {
+	case v6MsgSolicit, v6MsgConfirm, v6MsgRebind:
+		if sid != nil {
+			return nil
+		}
+	case v6MsgRequest, v6MsgRenew, v6MsgRelease, v6MsgDecline:
+		if !bytes.Equal(sid, s.v6.sid) {
+			log.Tracef("DHCPv6: message not for this server")
+			return nil
+		}
+	case v6MsgInfoRequest:
+		if sid != nil && !bytes.Equal(sid, s.v6.sid) {
+			return nil
+		}
+	default:
+		log.Tracef("DHCPv6: unsupported message type %d", req.typ)
+		return nil
+	}
+
+	resp := &v6Msg{typ: v6MsgReply, xid: req.xid}
+	resp.add(v6OptServerID, s.v6.sid)
+	resp.add(v6OptClientID, cid)
+	if s.v6.dnsIP != nil {
+		resp.add(v6OptDNSServers, s.v6.dnsIP.To16())
+	}
+
+	if req.typ == v6MsgInfoRequest {
+		return resp
+	}
+
+	client := newV6Client(cid)
+	if s.v6.rangeStart == nil {
+		return nil
+	}
+
+	var ia *v6IANA
+	data := req.get(v6OptIANA)
+	if data != nil {
+		var err error
+		ia, err = parseV6IANA(data)
+		if err != nil {
+			log.Debug("DHCPv6: %s", err)
+			return nil
+		}
+	}
+
+	log.Tracef("DHCPv6: message type %d from %s", req.typ, client)
+
+	switch req.typ {
+	case v6MsgSolicit:
+		if ia == nil {
+			return nil
+		}
+		return s.handleSolicit6(req, resp, client, ia)
+
+	case v6MsgRequest, v6MsgRenew, v6MsgRebind:
+		if ia == nil {
+			return nil
+		}
+		return s.handleRequest6(req, resp, client, ia)
+
+	case v6MsgConfirm:
+		return s.handleConfirm6(resp, ia)
+
+	case v6MsgRelease:
+		s.handleRelease6(client, ia)
+		resp.add(v6OptStatusCode, packV6Status(v6StatusSuccess, ""))
+		return resp
+
+	case v6MsgDecline:
+		s.handleDecline6(client, ia)
+		resp.add(v6OptStatusCode, packV6Status(v6StatusSuccess, ""))
+		return resp
+	}
+	return nil
+}
+
+// Save the client's host name and the options which describe the client's DHCP implementation
+func setLeaseInfo6(lease *Lease, req *v6Msg) {
+	fqdn := req.get(v6OptClientFQDN)
+	if fqdn != nil {
+		lease.Hostname = parseV6FQDN(fqdn)
+	}
+	vendor := req.get(v6OptVendorClass)
+	if vendor != nil {
+		lease.VendorClass = parseV6VendorClass(vendor)
+	}
+}
+
+func (s *Server) handleSolicit6(req, resp *v6Msg, client v6Client, ia *v6IANA) *v6Msg {
+	s.leasesLock.Lock()
+	lease := s.findLease6(client)
+	if lease == nil {
+		lease = s.reserveLease6(client)
+		if lease == nil {
+			s.leasesLock.Unlock()
+			log.Info("DHCPv6: no free IP addresses for %s", client)
+			resp.typ = v6MsgAdvertise
+			resp.add(v6OptStatusCode, packV6Status(v6StatusNoAddrsAvail, "no addresses available"))
+			return resp
+		}
+		setLeaseInfo6(lease, req)
+	}
+
+	// the client is ready to use the address right away
+	rapid := req.has(v6OptRapidCommit)
+	event := ""
+	if rapid {
+		event = commitEvent(lease)
+		s.commitLease6(lease)
+	}
+	l := *lease
+	s.leasesLock.Unlock()
+
+	if rapid {
+		s.leaseEvent(event, &l)
+		s.notify(LeaseChangedAdded)
+		resp.add(v6OptRapidCommit, nil)
+	} else {
+		resp.typ = v6MsgAdvertise
+	}
+
+	log.Tracef("DHCPv6: offering %s to %s", lease.IP, client)
+	resp.add(v6OptIANA, packV6IANA(ia.iaid, lease.IP, s.leaseTime6(), 0, ""))
+	return resp
+}
+
+// REQUEST, RENEW, REBIND
+func (s *Server) handleRequest6(req, resp *v6Msg, client v6Client, ia *v6IANA) *v6Msg {
+	s.leasesLock.Lock()
+	lease := s.findLease6(client)
+	if lease == nil && req.typ == v6MsgRequest {
+		lease = s.reserveLease6(client)
+	}
+	if lease == nil {
+		s.leasesLock.Unlock()
+
+		status := uint16(v6StatusNoBinding)
+		if req.typ == v6MsgRequest {
+			status = v6StatusNoAddrsAvail
+		}
+		log.Tracef("DHCPv6: no lease for %s", client)
+		resp.add(v6OptIANA, packV6IANA(ia.iaid, nil, 0, status, "no lease"))
+		return resp
+	}
+
+	setLeaseInfo6(lease, req)
+	lease.DUID = client.duid // the lease loaded from an old DB has only MAC address
+	event := commitEvent(lease)
+	s.commitLease6(lease)
+	l := *lease
+	s.leasesLock.Unlock()
+	s.leaseEvent(event, &l)
+	s.notify(LeaseChangedAdded)
+
+	log.Tracef("DHCPv6: leased %s to %s until %s", lease.IP, client, lease.Expiry)
+	resp.add(v6OptIANA, packV6IANA(ia.iaid, lease.IP, s.leaseTime6(), 0, ""))
+	return resp
+}
+
+// Check whether the client's addresses are appropriate for our link
+func (s *Server) handleConfirm6(resp *v6Msg, ia *v6IANA) *v6Msg {
+	if ia == nil || len(ia.addrs) == 0 {
+		return nil
+	}
+	for _, ip := range ia.addrs {
+		if !s.ip6InRange(ip) {
+			resp.add(v6OptStatusCode, packV6Status(v6StatusNotOnLink, "address is not on link"))
+			return resp
+		}
+	}
+	resp.add(v6OptStatusCode, packV6Status(v6StatusSuccess, ""))
+	return resp
+}
+
+// The client doesn't need the address anymore: the lease expires right away
+func (s *Server) handleRelease6(client v6Client, ia *v6IANA) {
+	s.leasesLock.Lock()
+	lease := s.findLease6(client)
+	if lease == nil || lease.Expiry.Unix() == leaseExpireStatic ||
+		(ia != nil && len(ia.addrs) != 0 && !lease.IP.Equal(ia.addrs[0])) {
+		s.leasesLock.Unlock()
+		return
+	}
+	log.Tracef("DHCPv6: %s released %s", client, lease.IP)
+	lease.Expiry = time.Unix(0, 0) // the lease isn't stored in DB and may be reused
+	s.dbStore()
+	l := *lease
+	s.leasesLock.Unlock()
+	s.leaseEvent(hookEventRelease, &l)
+}
+
+// The address is already used by another device: don't use it for a lease time period
+func (s *Server) handleDecline6(client v6Client, ia *v6IANA) {
+	s.leasesLock.Lock()
+	lease := s.findLease6(client)
+	if lease == nil || lease.Expiry.Unix() == leaseExpireStatic {
+		s.leasesLock.Unlock()
+		return
+	}
+	log.Info("DHCPv6: IP conflict: %v is already used by another device", lease.IP)
+	l := *lease
+	lease.HWAddr = make(net.HardwareAddr, 6)
+	lease.DUID = nil
+	lease.Hostname = ""
+	lease.Expiry = time.Now().Add(s.v6.leaseTime)
+	s.dbStore()
+	s.leasesLock.Unlock()
+	s.leaseEvent(hookEventDecline, &l)
+}
+
+func (s *Server) leaseTime6() uint32 {
+	return uint32(s.v6.leaseTime / time.Second)
+}
+
+// Update lease expiration time and store it
+func (s *Server) commitLease6(lease *Lease) {
+	if lease.Expiry.Unix() == leaseExpireStatic {
+		return
+	}
+	lease.Expiry = time.Now().Add(s.v6.leaseTime)
+	s.dbStore()
+}
+
+// v6Client - the client's identity: DUID from Client ID option and MAC address from DUID (if any)
+type v6Client struct {
+	duid   []byte
+	hwaddr net.HardwareAddr
+}
+
+func newV6Client(duid []byte) v6Client {
+	c := v6Client{duid: make([]byte, len(duid))}
+	copy(c.duid, duid)
+	c.hwaddr = duidHWAddr(duid)
+	return c
+}
+
+func (c v6Client) String() string {
+	if c.hwaddr != nil {
+		return c.hwaddr.String()
+	}
+	return "DUID " + formatClientID(c.duid)
+}
+
+// Find a DHCPv6 lease by the client's DUID
+// The leases stored by the previous versions have only MAC address: they're matched by MAC address from DUID.
+func (s *Server) findLease6(client v6Client) *Lease {
+	for _, l := range s.leases6 {
+		if len(l.DUID) != 0 {
+			if bytes.Equal(l.DUID, client.duid) {
+				return l
+			}
+		} else if client.hwaddr != nil && bytes.Equal(l.HWAddr, client.hwaddr) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Reserve an IP address for the client: find a free IP or use the IP of an expired lease
+// Return nil if there are no free IP addresses
+func (s *Server) reserveLease6(client v6Client) *Lease {
+	used := map[[16]byte]bool{}
+	for _, l := range s.leases6 {
+		var ip [16]byte
+		copy(ip[:], l.IP)
+		used[ip] = true
+	}
+
+	lease := &Lease{HWAddr: client.hwaddr, DUID: client.duid}
+	for i := uint32(0); i != s.v6.rangeSize; i++ {
+		ip := s.ip6At(i)
+		var key [16]byte
+		copy(key[:], ip)
+		if !used[key] {
+			lease.IP = ip
+			s.leases6 = append(s.leases6, lease)
+			return lease
+		}
+	}
+
+	now := time.Now().Unix()
+	for i, l := range s.leases6 {
+		if l.Expiry.Unix() <= now && l.Expiry.Unix() != leaseExpireStatic {
+			log.Tracef("DHCPv6: assigning IP address %s to %s (lease for %s expired at %s)",
+				l.IP, client, l.HWAddr, l.Expiry)
+			lease.IP = l.IP
+			s.leases6[i] = lease
+			return lease
+		}
+	}
+	return nil
+}
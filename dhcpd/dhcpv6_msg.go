@@ -0,0 +1,252 @@
+// DHCPv6 message format (RFC 8415)
+
+package dhcpd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DHCPv6 message types
+const (
+	v6MsgSolicit     = 1
+	v6MsgAdvertise   = 2
+	v6MsgRequest     = 3
+	v6MsgConfirm     = 4
+	v6MsgRenew       = 5
+	v6MsgRebind      = 6
+	v6MsgReply       = 7
+	v6MsgRelease     = 8
+	v6MsgDecline     = 9
+	v6MsgInfoRequest = 11
+)
+
+// DHCPv6 option codes
+const (
+	v6OptClientID    = 1
+	v6OptServerID    = 2
+	v6OptIANA        = 3
+	v6OptIAAddr      = 5
+	v6OptStatusCode  = 13
+	v6OptRapidCommit = 14
+	v6OptVendorClass = 16
+	v6OptDNSServers  = 23
+	v6OptClientFQDN  = 39
+)
+
+// DHCPv6 status codes
+const (
+	v6StatusSuccess      = 0
+	v6StatusNoAddrsAvail = 2
+	v6StatusNoBinding    = 3
+	v6StatusNotOnLink    = 4
+)
+
+// DUID types
+const (
+	duidLLT = 1 // link-layer address plus time
+	duidLL  = 3 // link-layer address
+)
+
+// v6Opt - DHCPv6 option
+type v6Opt struct {
+	code uint16
+	data []byte
+}
+
+// v6Msg - DHCPv6 message (client/server message format)
+type v6Msg struct {
+	typ  byte
+	xid  [3]byte // transaction ID
+	opts []v6Opt
+}
+
+// Parse options from the buffer
+func parseV6Opts(b []byte) ([]v6Opt, error) {
+	opts := []v6Opt{}
+	for len(b) != 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("option header is too short")
+		}
+		code := binary.BigEndian.Uint16(b)
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return nil, fmt.Errorf("option %d is too short", code)
+		}
+		opts = append(opts, v6Opt{code: code, data: b[4 : 4+n]})
+		b = b[4+n:]
+	}
+	return opts, nil
+}
+
+// Serialize options
+func packV6Opts(opts []v6Opt) []byte {
+	b := []byte{}
+	for _, o := range opts {
+		hdr := make([]byte, 4)
+		binary.BigEndian.PutUint16(hdr, o.code)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(o.data)))
+		b = append(b, hdr...)
+		b = append(b, o.data...)
+	}
+	return b
+}
+
+// Get the first option with the specified code
+func getV6Opt(opts []v6Opt, code uint16) []byte {
+	for _, o := range opts {
+		if o.code == code {
+			return o.data
+		}
+	}
+	return nil
+}
+
+func parseV6Msg(b []byte) (*v6Msg, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("message is too short")
+	}
+	m := v6Msg{typ: b[0]}
+	copy(m.xid[:], b[1:4])
+	var err error
+	m.opts, err = parseV6Opts(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *v6Msg) pack() []byte {
+	b := []byte{m.typ, m.xid[0], m.xid[1], m.xid[2]}
+	return append(b, packV6Opts(m.opts)...)
+}
+
+func (m *v6Msg) get(code uint16) []byte {
+	return getV6Opt(m.opts, code)
+}
+
+func (m *v6Msg) has(code uint16) bool {
+	for _, o := range m.opts {
+		if o.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *v6Msg) add(code uint16, data []byte) {
+	m.opts = append(m.opts, v6Opt{code: code, data: data})
+}
+
+// v6IANA - Identity Association for Non-temporary Addresses option
+type v6IANA struct {
+	iaid  [4]byte
+	t1    uint32
+	t2    uint32
+	addrs []net.IP // addresses from IA Address options
+}
+
+func parseV6IANA(b []byte) (*v6IANA, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("IA_NA option is too short")
+	}
+	ia := v6IANA{}
+	copy(ia.iaid[:], b)
+	ia.t1 = binary.BigEndian.Uint32(b[4:])
+	ia.t2 = binary.BigEndian.Uint32(b[8:])
+	opts, err := parseV6Opts(b[12:])
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range opts {
+		if o.code == v6OptIAAddr && len(o.data) >= 24 {
+			ia.addrs = append(ia.addrs, net.IP(o.data[:16]))
+		}
+	}
+	return &ia, nil
+}
+
+// Build IA_NA option data with 1 address
+// If ip is nil, the status option is added instead
+func packV6IANA(iaid [4]byte, ip net.IP, lifetime uint32, status uint16, statusMsg string) []byte {
+	b := make([]byte, 12)
+	copy(b, iaid[:])
+	if ip == nil {
+		return append(b, packV6Opts([]v6Opt{{code: v6OptStatusCode, data: packV6Status(status, statusMsg)}})...)
+	}
+
+	binary.BigEndian.PutUint32(b[4:], lifetime/2)   // T1
+	binary.BigEndian.PutUint32(b[8:], lifetime*4/5) // T2
+
+	addr := make([]byte, 24)
+	copy(addr, ip.To16())
+	binary.BigEndian.PutUint32(addr[16:], lifetime) // preferred lifetime
+	binary.BigEndian.PutUint32(addr[20:], lifetime) // valid lifetime
+	return append(b, packV6Opts([]v6Opt{{code: v6OptIAAddr, data: addr}})...)
+}
+
+func packV6Status(code uint16, msg string) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, code)
+	return append(b, msg...)
+}
+
+// Get MAC address from DUID-LLT or DUID-LL
+// Returns nil for the other DUID types
+func duidHWAddr(duid []byte) net.HardwareAddr {
+	if len(duid) < 4 {
+		return nil
+	}
+	var mac []byte
+	switch binary.BigEndian.Uint16(duid) {
+	case duidLLT:
+		if len(duid) < 8 {
+			return nil
+		}
+		mac = duid[8:]
+	case duidLL:
+		mac = duid[4:]
+	default:
+		return nil
+	}
+	if len(mac) != 6 {
+		return nil
+	}
+	hwaddr := make(net.HardwareAddr, 6)
+	copy(hwaddr, mac)
+	return hwaddr
+}
+
+// Create DUID-LL for the network interface
+func newDUIDLL(hwaddr net.HardwareAddr) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, duidLL)
+	binary.BigEndian.PutUint16(b[2:], 1) // hardware type: Ethernet
+	return append(b, hwaddr...)
+}
+
+// Get host name from Client FQDN option: the first label of the domain name
+func parseV6FQDN(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	n := int(b[1])
+	if n == 0 || len(b) < 2+n {
+		return ""
+	}
+	return strings.ToLower(string(b[2 : 2+n]))
+}
+
+// Get the first vendor class string from Vendor Class option
+func parseV6VendorClass(b []byte) string {
+	if len(b) < 6 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < 6+n {
+		return ""
+	}
+	return string(b[6 : 6+n])
+}
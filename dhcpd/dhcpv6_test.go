@@ -0,0 +1,243 @@
+package dhcpd
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer6() *Server {
+	s := &Server{}
+	s.conf.DBFilePath = dbFilename
+	s.reset()
+	_ = s.setConfig6(V6ServerConfig{
+		Enabled:       true,
+		RangeStart:    "2001::1",
+		RangeEnd:      "2001::2",
+		LeaseDuration: 3600,
+	})
+	s.v6.sid = newDUIDLL([]byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	s.v6.dnsIP = net.ParseIP("2001::ffff")
+	return s
+}
+
+func newTestMsg6(typ byte, hwaddr net.HardwareAddr, sid []byte) *v6Msg {
+	m := &v6Msg{typ: typ, xid: [3]byte{1, 2, 3}}
+	m.add(v6OptClientID, newDUIDLL(hwaddr))
+	if sid != nil {
+		m.add(v6OptServerID, sid)
+	}
+	m.add(v6OptIANA, make([]byte, 12))
+	return m
+}
+
+// Get the address and the status code from IA_NA option of the response
+func getIANA6(t *testing.T, m *v6Msg) (net.IP, uint16) {
+	data := m.get(v6OptIANA)
+	assert.NotNil(t, data)
+	opts, err := parseV6Opts(data[12:])
+	assert.Nil(t, err)
+	addr := getV6Opt(opts, v6OptIAAddr)
+	if addr != nil {
+		return net.IP(addr[:16]), v6StatusSuccess
+	}
+	return nil, binary.BigEndian.Uint16(getV6Opt(opts, v6OptStatusCode))
+}
+
+func TestV6Msg(t *testing.T) {
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	m := newTestMsg6(v6MsgSolicit, hw, nil)
+	m.add(v6OptClientFQDN, []byte{0, 7, 'P', 'r', 'i', 'n', 't', 'e', 'r', 3, 'l', 'a', 'n', 0})
+
+	m2, err := parseV6Msg(m.pack())
+	assert.Nil(t, err)
+	assert.Equal(t, byte(v6MsgSolicit), m2.typ)
+	assert.Equal(t, [3]byte{1, 2, 3}, m2.xid)
+	assert.Equal(t, hw, duidHWAddr(m2.get(v6OptClientID)))
+	assert.Equal(t, "printer", parseV6FQDN(m2.get(v6OptClientFQDN)))
+	assert.False(t, m2.has(v6OptServerID))
+
+	// DUID-LLT
+	duid := []byte{0, 1, 0, 1, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6}
+	assert.Equal(t, hw, duidHWAddr(duid))
+	// DUID-EN
+	assert.Nil(t, duidHWAddr([]byte{0, 2, 0, 0, 0, 1, 1, 2}))
+
+	_, err = parseV6Msg([]byte{1, 2, 3, 4, 0, 1, 0, 10, 1})
+	assert.NotNil(t, err)
+}
+
+func TestDHCPv6(t *testing.T) {
+	s := newTestServer6()
+	defer func() { _ = os.Remove(dbFilename) }()
+	hw1 := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	hw2 := net.HardwareAddr{2, 2, 3, 4, 5, 6}
+	hw3 := net.HardwareAddr{3, 2, 3, 4, 5, 6}
+
+	// Solicit -> Advertise
+	resp := s.process6(newTestMsg6(v6MsgSolicit, hw1, nil))
+	assert.Equal(t, byte(v6MsgAdvertise), resp.typ)
+	assert.Equal(t, s.v6.sid, resp.get(v6OptServerID))
+	assert.Equal(t, net.ParseIP("2001::ffff"), net.IP(resp.get(v6OptDNSServers)))
+	ip, _ := getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::1"), ip)
+	assert.Equal(t, 0, len(s.Leases(LeasesAll)))
+
+	// Request with a wrong server ID is ignored
+	assert.Nil(t, s.process6(newTestMsg6(v6MsgRequest, hw1, []byte{0, 3, 0, 1, 1})))
+
+	// Request -> Reply
+	resp = s.process6(newTestMsg6(v6MsgRequest, hw1, s.v6.sid))
+	assert.Equal(t, byte(v6MsgReply), resp.typ)
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::1"), ip)
+	ll := s.Leases(LeasesDynamic)
+	assert.Equal(t, 1, len(ll))
+	assert.Equal(t, hw1, ll[0].HWAddr)
+	assert.True(t, ll[0].Expiry.After(time.Now().Add(59*time.Minute)))
+	assert.Equal(t, hw1, s.FindMACbyIP(net.ParseIP("2001::1")))
+
+	// Solicit with Rapid Commit -> Reply
+	req := newTestMsg6(v6MsgSolicit, hw2, nil)
+	req.add(v6OptRapidCommit, nil)
+	resp = s.process6(req)
+	assert.Equal(t, byte(v6MsgReply), resp.typ)
+	assert.True(t, resp.has(v6OptRapidCommit))
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::2"), ip)
+	assert.Equal(t, 2, len(s.Leases(LeasesDynamic)))
+
+	// no more free addresses
+	resp = s.process6(newTestMsg6(v6MsgSolicit, hw3, nil))
+	assert.Equal(t, byte(v6MsgAdvertise), resp.typ)
+	assert.False(t, resp.has(v6OptIANA))
+
+	// Renew a lease which doesn't exist
+	resp = s.process6(newTestMsg6(v6MsgRenew, hw3, s.v6.sid))
+	_, status := getIANA6(t, resp)
+	assert.Equal(t, uint16(v6StatusNoBinding), status)
+
+	// Release -> the address may be used by another client
+	resp = s.process6(newTestMsg6(v6MsgRelease, hw2, s.v6.sid))
+	assert.Equal(t, byte(v6MsgReply), resp.typ)
+	assert.Equal(t, 1, len(s.Leases(LeasesDynamic)))
+	resp = s.process6(newTestMsg6(v6MsgRequest, hw3, s.v6.sid))
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::2"), ip)
+
+	// Confirm
+	req = &v6Msg{typ: v6MsgConfirm}
+	req.add(v6OptClientID, newDUIDLL(hw1))
+	ia := packV6IANA([4]byte{}, net.ParseIP("2002::1"), 0, 0, "")
+	req.add(v6OptIANA, ia)
+	resp = s.process6(req)
+	assert.Equal(t, uint16(v6StatusNotOnLink), binary.BigEndian.Uint16(resp.get(v6OptStatusCode)))
+
+	// the leases are stored in DB
+	s.reset()
+	s.dbLoad()
+	ll = s.Leases(LeasesDynamic)
+	assert.Equal(t, 2, len(ll))
+	assert.Equal(t, hw1, s.FindMACbyIP(net.ParseIP("2001::1")))
+	assert.Equal(t, hw3, s.FindMACbyIP(net.ParseIP("2001::2")))
+}
+
+// The leases are identified by DUID: the clients with DUID-EN and DUID-UUID don't have MAC address
+func TestDHCPv6DUID(t *testing.T) {
+	s := newTestServer6()
+	defer func() { _ = os.Remove(dbFilename) }()
+	duidEN := []byte{0, 2, 0, 0, 0, 9, 1, 2, 3, 4}
+	duidUUID := []byte{0, 4, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	newMsg := func(typ byte, duid []byte) *v6Msg {
+		m := &v6Msg{typ: typ, xid: [3]byte{1, 2, 3}}
+		m.add(v6OptClientID, duid)
+		m.add(v6OptServerID, s.v6.sid)
+		m.add(v6OptIANA, make([]byte, 12))
+		return m
+	}
+
+	resp := s.process6(newMsg(v6MsgRequest, duidEN))
+	ip, _ := getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::1"), ip)
+	resp = s.process6(newMsg(v6MsgRequest, duidUUID))
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::2"), ip)
+
+	// each client renews its own lease
+	resp = s.process6(newMsg(v6MsgRenew, duidUUID))
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::2"), ip)
+	ll := s.Leases(LeasesDynamic)
+	assert.Equal(t, 2, len(ll))
+	assert.Nil(t, ll[0].HWAddr)
+	assert.Equal(t, duidEN, ll[0].DUID)
+
+	// the leases are stored in DB
+	s.reset()
+	s.dbLoad()
+	resp = s.process6(newMsg(v6MsgRenew, duidEN))
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::1"), ip)
+
+	// a new client doesn't get the address of another client
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	resp = s.process6(newTestMsg6(v6MsgRequest, hw, s.v6.sid))
+	_, status := getIANA6(t, resp)
+	assert.Equal(t, uint16(v6StatusNoAddrsAvail), status)
+
+	// a lease without DUID (stored by a previous version) is matched by MAC address
+	s.leases6[0].DUID = nil
+	s.leases6[0].HWAddr = hw
+	resp = s.process6(newTestMsg6(v6MsgRenew, hw, s.v6.sid))
+	ip, _ = getIANA6(t, resp)
+	assert.Equal(t, net.ParseIP("2001::1"), ip)
+	assert.Equal(t, newDUIDLL(hw), s.leases6[0].DUID)
+}
+
+func TestV6Config(t *testing.T) {
+	s := Server{}
+	assert.Nil(t, s.setConfig6(V6ServerConfig{}))
+	assert.NotNil(t, s.setConfig6(V6ServerConfig{Enabled: true, RangeStart: "2001::1", RangeEnd: "2001:0:0:1::1"}))
+	assert.NotNil(t, s.setConfig6(V6ServerConfig{Enabled: true, RangeStart: "2001::10", RangeEnd: "2001::1"}))
+	assert.NotNil(t, s.setConfig6(V6ServerConfig{Enabled: true, RangeStart: "1.1.1.1", RangeEnd: "2001::1"}))
+	assert.Nil(t, s.setConfig6(V6ServerConfig{Enabled: true, RangeStart: "2001::10", RangeEnd: "2001::20"}))
+	assert.Equal(t, uint32(0x11), s.v6.rangeSize)
+	assert.True(t, s.ip6InRange(net.ParseIP("2001::20")))
+	assert.False(t, s.ip6InRange(net.ParseIP("2001::21")))
+}
+
+func TestRouterAdvertisement(t *testing.T) {
+	ra := raSender{
+		iface: &net.Interface{
+			HardwareAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6},
+			MTU:          1500,
+		},
+		managed: true,
+		slaac:   true,
+		prefix: &net.IPNet{
+			IP:   net.ParseIP("2001:db8::1"),
+			Mask: net.CIDRMask(64, 128),
+		},
+		dnsIP: net.ParseIP("2001:db8::1"),
+	}
+	b := ra.createPacket()
+	assert.Equal(t, 16+8+8+32+24, len(b))
+	assert.Equal(t, byte(134), b[0])
+	assert.Equal(t, byte(0xc0), b[5])
+	assert.Equal(t, uint16(0), binary.BigEndian.Uint16(b[6:])) // not a default router
+
+	// Prefix Information
+	opt := b[32:64]
+	assert.Equal(t, []byte{3, 4, 64, 0xc0}, opt[:4])
+	assert.Equal(t, net.ParseIP("2001:db8::"), net.IP(opt[16:32]))
+
+	// RDNSS
+	opt = b[64:]
+	assert.Equal(t, []byte{25, 3}, opt[:2])
+	assert.Equal(t, net.ParseIP("2001:db8::1"), net.IP(opt[8:24]))
+}
@@ -7,18 +7,18 @@ import (
 	"golang.org/x/net/ipv4"
 )
 
-// filterConn listens to 0.0.0.0:67, but accepts packets only from specific interface
+// filterConn listens to 0.0.0.0:67 and returns the index of the interface which has received the packet,
+// so the server can select the scope and accept packets only from specific interfaces.
 // This is necessary for DHCP daemon to work, since binding to IP address doesn't
 // us access to see Discover/Request packets from clients.
 //
 // TODO: on windows, controlmessage does not work, try to find out another way
 // https://github.com/golang/net/blob/master/ipv4/payload.go#L13
 type filterConn struct {
-	iface net.Interface
-	conn  *ipv4.PacketConn
+	conn *ipv4.PacketConn
 }
 
-func newFilterConn(iface net.Interface, address string) (*filterConn, error) {
+func newFilterConn(address string) (*filterConn, error) {
 	c, err := net.ListenPacket("udp4", address)
 	if err != nil {
 		return nil, errorx.Decorate(err, "Couldn't listen to %s on UDP4", address)
@@ -31,29 +31,31 @@ func newFilterConn(iface net.Interface, address string) (*filterConn, error) {
 		return nil, errorx.Decorate(err, "Couldn't set control message FlagInterface on connection")
 	}
 
-	return &filterConn{iface: iface, conn: p}, nil
+	return &filterConn{conn: p}, nil
 }
 
-func (f *filterConn) ReadFrom(b []byte) (int, net.Addr, error) {
-	for { // read until we find a suitable packet
-		n, cm, addr, err := f.conn.ReadFrom(b)
-		if err != nil {
-			return 0, addr, errorx.Decorate(err, "Error when reading from socket")
-		}
-		if cm == nil {
-			// no controlmessage was passed, so pass the packet to the caller
-			return n, addr, nil
-		}
-		if cm.IfIndex == f.iface.Index {
-			return n, addr, nil
-		}
-		// packet doesn't match criteria, drop it
+// Read a packet
+// ifIndex: index of the interface which has received the packet;  0 if unknown
+func (f *filterConn) readFrom(b []byte) (n int, ifIndex int, addr net.Addr, err error) {
+	n, cm, addr, err := f.conn.ReadFrom(b)
+	if err != nil {
+		return 0, 0, addr, errorx.Decorate(err, "Error when reading from socket")
+	}
+	if cm == nil {
+		// no controlmessage was passed, so pass the packet to the caller
+		return n, 0, addr, nil
 	}
+	return n, cm.IfIndex, addr, nil
 }
 
-func (f *filterConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+// Send a packet via the specified interface
+// ifIndex: 0 - the interface is selected by OS
+func (f *filterConn) writeTo(b []byte, ifIndex int, addr net.Addr) (int, error) {
+	if ifIndex == 0 {
+		return f.conn.WriteTo(b, nil, addr)
+	}
 	cm := ipv4.ControlMessage{
-		IfIndex: f.iface.Index,
+		IfIndex: ifIndex,
 	}
 	return f.conn.WriteTo(b, &cm, addr)
 }
@@ -0,0 +1,473 @@
+// High availability: 2 instances share the lease table, only one of them serves DHCP requests
+
+package dhcpd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// HA roles
+const (
+	haRolePrimary = "primary" // serves DHCP requests while it's running
+	haRoleStandby = "standby" // serves DHCP requests only when the primary doesn't send heartbeats
+)
+
+const (
+	haPath            = "/control/dhcp/ha"       // HTTP handler for the messages from the peer
+	haSignatureHeader = "X-AdGuard-HA-Signature" // hex-encoded HMAC-SHA256 of the request/response body
+	haMaxClockSkew    = 60                       // max. difference (in seconds) between the message time and the current time
+	haMaxMessageSize  = 64 * 1024 * 1024
+
+	haDefaultHeartbeatInterval = 5  // in seconds
+	haDefaultFailoverTimeout   = 30 // in seconds
+)
+
+// HAConfig - high availability settings
+type HAConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Role    string `json:"role" yaml:"role"` // "primary" or "standby"
+
+	// Base URL of the peer's web interface, e.g. "http://192.168.1.3:3000"
+	PeerURL string `json:"peer_url" yaml:"peer_url"`
+
+	// Shared secret for message authentication;  must be the same on both instances
+	// It isn't returned by "/control/dhcp/status";  an empty value in "/control/dhcp/set_config" keeps the current secret.
+	Secret string `json:"secret,omitempty" yaml:"secret"`
+
+	HeartbeatInterval uint32 `json:"heartbeat_interval" yaml:"heartbeat_interval"` // in seconds;  0: default (5)
+	FailoverTimeout   uint32 `json:"failover_timeout" yaml:"failover_timeout"`     // in seconds;  0: default (30)
+}
+
+// haMessage - the message which is sent to the peer (request and response)
+type haMessage struct {
+	Role    string      `json:"role"`    // the sender's role
+	Time    int64       `json:"time"`    // the time when the message was sent (UNIX time)
+	Seq     uint64      `json:"seq"`     // the sender's message counter: strictly increasing, even after restart
+	Serving bool        `json:"serving"` // the sender serves DHCP requests
+	Sync    bool        `json:"sync"`    // Leases contains the sender's lease table
+	Leases  []leaseJSON `json:"leases,omitempty"`
+}
+
+// haState - the current state of HA
+type haState struct {
+	lock sync.Mutex
+
+	conf              HAConfig
+	heartbeatInterval time.Duration
+	failoverTimeout   time.Duration
+	peerURL           string
+
+	serving       bool      // we serve DHCP requests
+	peerLastSeen  time.Time // the time of the last message from the peer
+	sentVersion   uint64    // the version of the lease table which was sent to the peer
+	seq           uint64    // the counter of the last message which we've sent
+	peerSeq       uint64    // the counter of the last message which we've received from the peer
+	startServing  func() error
+	stopServing   func() error
+	client        *http.Client
+	quit          chan bool
+	wg            sync.WaitGroup
+	running       bool
+	lastPeerError string
+}
+
+// Check and apply the HA settings
+func (ha *haState) setConfig(conf HAConfig) error {
+	if !conf.Enabled {
+		ha.conf = conf
+		return nil
+	}
+
+	if conf.Role != haRolePrimary && conf.Role != haRoleStandby {
+		return fmt.Errorf("invalid role %s", conf.Role)
+	}
+
+	u, err := url.Parse(conf.PeerURL)
+	if err != nil || !(u.Scheme == "http" || u.Scheme == "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid peer URL %s", conf.PeerURL)
+	}
+
+	if len(conf.Secret) < 8 {
+		return fmt.Errorf("secret must contain at least 8 characters")
+	}
+
+	interval := conf.HeartbeatInterval
+	if interval == 0 {
+		interval = haDefaultHeartbeatInterval
+	}
+	timeout := conf.FailoverTimeout
+	if timeout == 0 {
+		timeout = haDefaultFailoverTimeout
+	}
+	if timeout <= interval {
+		return fmt.Errorf("failover_timeout must be greater than heartbeat_interval")
+	}
+
+	ha.conf = conf
+	ha.heartbeatInterval = time.Duration(interval) * time.Second
+	ha.failoverTimeout = time.Duration(timeout) * time.Second
+	ha.peerURL = strings.TrimSuffix(conf.PeerURL, "/") + haPath
+	return nil
+}
+
+// Start HA: the primary starts serving DHCP requests right away,
+// the standby waits for heartbeats from the primary
+func (s *Server) startHA() error {
+	ha := &s.ha
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+	if ha.running {
+		return nil
+	}
+
+	if ha.startServing == nil {
+		ha.startServing = s.start
+		ha.stopServing = s.stop
+	}
+	ha.client = &http.Client{Timeout: ha.heartbeatInterval}
+	ha.quit = make(chan bool)
+	ha.peerLastSeen = time.Now() // give the primary some time to appear
+	ha.sentVersion = 0
+	ha.running = true
+	ha.wg.Add(1)
+	go s.haLoop()
+	log.Info("DHCP: HA: started as %s", ha.conf.Role)
+	return nil
+}
+
+// Stop HA and stop serving DHCP requests
+func (s *Server) stopHA() error {
+	ha := &s.ha
+	ha.lock.Lock()
+	if !ha.running {
+		ha.lock.Unlock()
+		return nil
+	}
+	ha.running = false
+	close(ha.quit)
+	ha.lock.Unlock()
+	ha.wg.Wait()
+
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+	return s.haSetServing(false)
+}
+
+// Start or stop serving DHCP requests
+// ha.lock must be locked
+func (s *Server) haSetServing(serving bool) error {
+	ha := &s.ha
+	if ha.serving == serving {
+		return nil
+	}
+	var err error
+	if serving {
+		err = ha.startServing()
+	} else {
+		err = ha.stopServing()
+	}
+	if err != nil {
+		return err
+	}
+	ha.serving = serving
+	return nil
+}
+
+func (s *Server) haLoop() {
+	ha := &s.ha
+	defer ha.wg.Done()
+
+	if ha.conf.Role == haRolePrimary {
+		// get the leases from the standby (it might have served the requests while we were down)
+		//  before we start serving
+		s.haSendHeartbeat()
+		ha.lock.Lock()
+		err := s.haSetServing(true)
+		ha.lock.Unlock()
+		if err != nil {
+			log.Error("DHCP: HA: %s", err)
+		}
+	}
+
+	t := time.NewTicker(ha.heartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if ha.conf.Role == haRolePrimary {
+				s.haSendHeartbeat()
+			} else {
+				s.haCheckFailover()
+			}
+
+		case <-ha.quit:
+			return
+		}
+	}
+}
+
+// Start serving DHCP requests if the primary hasn't sent heartbeats for too long
+func (s *Server) haCheckFailover() {
+	ha := &s.ha
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+	if ha.serving || time.Since(ha.peerLastSeen) <= ha.failoverTimeout {
+		return
+	}
+
+	log.Info("DHCP: HA: no heartbeats from the primary since %s: taking over", ha.peerLastSeen.Format(time.RFC3339))
+	err := s.haSetServing(true)
+	if err != nil {
+		log.Error("DHCP: HA: %s", err)
+	}
+}
+
+// Send heartbeat to the peer along with the lease table if it has changed
+func (s *Server) haSendHeartbeat() {
+	ha := &s.ha
+	msg := haMessage{}
+	ha.lock.Lock()
+	msg.Role = ha.conf.Role
+	msg.Serving = ha.serving
+	ha.lock.Unlock()
+
+	s.leasesLock.RLock()
+	version := s.leasesVersion
+	if version != ha.sentVersion {
+		msg.Sync = true
+		msg.Leases = s.leasesJSON()
+	}
+	s.leasesLock.RUnlock()
+
+	resp, err := s.haSend(msg)
+	if err != nil {
+		ha.lock.Lock()
+		if ha.lastPeerError != err.Error() {
+			log.Info("DHCP: HA: %s", err)
+		}
+		ha.lastPeerError = err.Error()
+		ha.lock.Unlock()
+		return
+	}
+
+	ha.lock.Lock()
+	ha.peerLastSeen = time.Now()
+	ha.lastPeerError = ""
+	ha.sentVersion = version
+	ha.lock.Unlock()
+
+	if resp.Sync {
+		log.Info("DHCP: HA: received %d leases from the peer", len(resp.Leases))
+		s.haSetLeases(resp.Leases)
+
+		// the peer already has these leases
+		s.leasesLock.RLock()
+		version = s.leasesVersion
+		s.leasesLock.RUnlock()
+		ha.lock.Lock()
+		ha.sentVersion = version
+		ha.lock.Unlock()
+	}
+}
+
+// Replace the lease table with the peer's leases
+func (s *Server) haSetLeases(leases []leaseJSON) {
+	s.leasesLock.Lock()
+	s.setLeasesJSON(leases)
+	s.dbStore()
+	s.leasesLock.Unlock()
+	s.notify(LeaseChangedAdded)
+}
+
+// Get HMAC-SHA256 of the message data
+func (ha *haState) signature(data []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(ha.conf.Secret))
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Sign the message data
+func (ha *haState) sign(data []byte) string {
+	return hex.EncodeToString(ha.signature(data))
+}
+
+// Get the counter for the next message
+// It's based on the current time, so it keeps increasing after restart.
+func (ha *haState) nextSeq() uint64 {
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+	seq := uint64(time.Now().UnixNano())
+	if seq <= ha.seq {
+		seq = ha.seq + 1
+	}
+	ha.seq = seq
+	return seq
+}
+
+// Check the signature and decode the message
+// A message which has already been received (or an older one) is rejected:
+// a captured message can't be replayed to roll back the lease table.
+func (ha *haState) decode(data []byte, signature string) (*haMessage, error) {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, ha.signature(data)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	msg := haMessage{}
+	err = json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, err
+	}
+
+	d := time.Now().Unix() - msg.Time
+	if d > haMaxClockSkew || d < -haMaxClockSkew {
+		return nil, fmt.Errorf("message time %d is out of sync with the current time", msg.Time)
+	}
+	if msg.Role == ha.conf.Role || !(msg.Role == haRolePrimary || msg.Role == haRoleStandby) {
+		return nil, fmt.Errorf("invalid peer role %s", msg.Role)
+	}
+
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+	if msg.Seq <= ha.peerSeq {
+		return nil, fmt.Errorf("message counter %d isn't greater than %d: replayed message", msg.Seq, ha.peerSeq)
+	}
+	ha.peerSeq = msg.Seq
+	return &msg, nil
+}
+
+// Send the message to the peer and get its response
+func (s *Server) haSend(msg haMessage) (*haMessage, error) {
+	ha := &s.ha
+	msg.Time = time.Now().Unix()
+	msg.Seq = ha.nextSeq()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", ha.peerURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(haSignatureHeader, ha.sign(data))
+
+	resp, err := ha.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, haMaxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status code %d: %s", ha.peerURL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return ha.decode(body, resp.Header.Get(haSignatureHeader))
+}
+
+// Process the message from the peer and create the response
+func (s *Server) haProcess(msg *haMessage) haMessage {
+	ha := &s.ha
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+
+	ha.peerLastSeen = time.Now()
+	resp := haMessage{Role: ha.conf.Role}
+
+	if ha.conf.Role == haRoleStandby && ha.serving {
+		// the primary is back: give it the leases which we've issued and stop serving
+		log.Info("DHCP: HA: the primary is back: stopping")
+		err := s.haSetServing(false)
+		if err != nil {
+			log.Error("DHCP: HA: %s", err)
+		}
+		s.leasesLock.RLock()
+		resp.Sync = true
+		resp.Leases = s.leasesJSON()
+		s.leasesLock.RUnlock()
+		// our lease table is newer than the primary's, so we don't use it
+		msg.Sync = false
+	}
+
+	if msg.Sync {
+		log.Debug("DHCP: HA: received %d leases from the peer", len(msg.Leases))
+		s.haSetLeases(msg.Leases)
+	}
+
+	resp.Serving = ha.serving
+	return resp
+}
+
+// Handle the message from the peer
+// The request is authenticated by HMAC, not by the web interface's credentials
+func (s *Server) handleHA(w http.ResponseWriter, r *http.Request) {
+	ha := &s.ha
+	if r.Method != "POST" {
+		http.Error(w, "This request must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ha.lock.Lock()
+	running := ha.running
+	ha.lock.Unlock()
+	if !running {
+		httpError(r, w, http.StatusServiceUnavailable, "HA is disabled")
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, haMaxMessageSize))
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	msg, err := ha.decode(data, r.Header.Get(haSignatureHeader))
+	if err != nil {
+		httpError(r, w, http.StatusForbidden, "%s", err)
+		return
+	}
+
+	resp := s.haProcess(msg)
+	resp.Time = time.Now().Unix()
+	resp.Seq = ha.nextSeq()
+	data, err = json.Marshal(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(haSignatureHeader, ha.sign(data))
+	_, _ = w.Write(data)
+}
+
+// Get HA status for "/control/dhcp/status"
+func (s *Server) haStatus() map[string]interface{} {
+	ha := &s.ha
+	ha.lock.Lock()
+	defer ha.lock.Unlock()
+	if !ha.running {
+		return nil
+	}
+	status := map[string]interface{}{
+		"role":           ha.conf.Role,
+		"serving":        ha.serving,
+		"peer_last_seen": ha.peerLastSeen.Format(time.RFC3339),
+		"peer_error":     ha.lastPeerError,
+	}
+	return status
+}
@@ -0,0 +1,194 @@
+package dhcpd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHAInstance struct {
+	s       *Server
+	web     *httptest.Server
+	serving int32
+}
+
+func newTestHAInstance(t *testing.T, dir string, role string) *testHAInstance {
+	inst := &testHAInstance{s: &Server{}}
+	inst.s.conf.DBFilePath = filepath.Join(dir, role+".db")
+	inst.s.reset()
+	inst.s.ha.startServing = func() error {
+		atomic.StoreInt32(&inst.serving, 1)
+		return nil
+	}
+	inst.s.ha.stopServing = func() error {
+		atomic.StoreInt32(&inst.serving, 0)
+		return nil
+	}
+	inst.web = httptest.NewServer(http.HandlerFunc(inst.s.handleHA))
+	return inst
+}
+
+func (inst *testHAInstance) setConfig(t *testing.T, role string, peer *testHAInstance) {
+	err := inst.s.ha.setConfig(HAConfig{
+		Enabled: true,
+		Role:    role,
+		PeerURL: peer.web.URL,
+		Secret:  "secret123",
+	})
+	assert.Nil(t, err)
+	inst.s.ha.heartbeatInterval = 50 * time.Millisecond
+	inst.s.ha.failoverTimeout = 300 * time.Millisecond
+}
+
+func (inst *testHAInstance) isServing() bool {
+	return atomic.LoadInt32(&inst.serving) == 1
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timeout: %s", what)
+}
+
+func TestHAConfig(t *testing.T) {
+	ha := haState{}
+	assert.Nil(t, ha.setConfig(HAConfig{}))
+	assert.Nil(t, ha.setConfig(HAConfig{Enabled: true, Role: "standby", PeerURL: "http://127.0.0.1:3000", Secret: "secret123"}))
+	assert.Equal(t, 5*time.Second, ha.heartbeatInterval)
+	assert.Equal(t, 30*time.Second, ha.failoverTimeout)
+	assert.Equal(t, "http://127.0.0.1:3000/control/dhcp/ha", ha.peerURL)
+
+	assert.NotNil(t, ha.setConfig(HAConfig{Enabled: true, Role: "master", PeerURL: "http://127.0.0.1:3000", Secret: "secret123"}))
+	assert.NotNil(t, ha.setConfig(HAConfig{Enabled: true, Role: "primary", PeerURL: "127.0.0.1:3000", Secret: "secret123"}))
+	assert.NotNil(t, ha.setConfig(HAConfig{Enabled: true, Role: "primary", PeerURL: "http://127.0.0.1:3000", Secret: "secret"}))
+	assert.NotNil(t, ha.setConfig(HAConfig{Enabled: true, Role: "primary", PeerURL: "http://127.0.0.1:3000", Secret: "secret123",
+		HeartbeatInterval: 10, FailoverTimeout: 10}))
+}
+
+func TestHA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcpha")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	primary := newTestHAInstance(t, dir, "primary")
+	defer primary.web.Close()
+	standby := newTestHAInstance(t, dir, "standby")
+	defer standby.web.Close()
+	primary.setConfig(t, haRolePrimary, standby)
+	standby.setConfig(t, haRoleStandby, primary)
+
+	assert.Nil(t, standby.s.startHA())
+	defer func() { _ = standby.s.stopHA() }()
+	assert.Nil(t, primary.s.startHA())
+	waitFor(t, "primary is serving", primary.isServing)
+	assert.False(t, standby.isServing())
+
+	// the leases are replicated to the standby
+	l1 := Lease{HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}, IP: net.IP{192, 168, 10, 150}}
+	assert.Nil(t, primary.s.AddStaticLease(l1))
+	waitFor(t, "lease is replicated", func() bool {
+		return len(standby.s.Leases(LeasesStatic)) == 1
+	})
+
+	// the primary is down: the standby takes over
+	assert.Nil(t, primary.s.stopHA())
+	assert.False(t, primary.isServing())
+	waitFor(t, "standby is serving", standby.isServing)
+	l2 := Lease{HWAddr: net.HardwareAddr{2, 2, 3, 4, 5, 6}, IP: net.IP{192, 168, 10, 151}}
+	assert.Nil(t, standby.s.AddStaticLease(l2))
+
+	// the primary is back: it gets the leases from the standby and the standby stops serving
+	assert.Nil(t, primary.s.startHA())
+	defer func() { _ = primary.s.stopHA() }()
+	waitFor(t, "primary is serving", primary.isServing)
+	assert.False(t, standby.isServing())
+	assert.Equal(t, 2, len(primary.s.Leases(LeasesStatic)))
+
+	status := standby.s.haStatus()
+	assert.Equal(t, "standby", status["role"])
+	assert.Equal(t, false, status["serving"])
+}
+
+func TestHAAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcpha")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	standby := newTestHAInstance(t, dir, "standby")
+	defer standby.web.Close()
+	standby.setConfig(t, haRoleStandby, standby)
+	assert.Nil(t, standby.s.startHA())
+	defer func() { _ = standby.s.stopHA() }()
+
+	post := func(data []byte, sig string) int {
+		req, _ := http.NewRequest("POST", standby.web.URL, bytes.NewReader(data))
+		req.Header.Set(haSignatureHeader, sig)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	data := []byte(`{"role":"primary","time":` + now + `,"seq":10,"sync":true,"leases":[]}`)
+	assert.Equal(t, http.StatusForbidden, post(data, ""))
+	assert.Equal(t, http.StatusForbidden, post(data, "0123456789abcdef"))
+
+	other := haState{}
+	other.conf.Secret = "another secret"
+	assert.Equal(t, http.StatusForbidden, post(data, other.sign(data)))
+
+	// the message is too old
+	old := []byte(`{"role":"primary","time":1000,"seq":11}`)
+	assert.Equal(t, http.StatusForbidden, post(old, standby.s.ha.sign(old)))
+
+	// the sender's role is the same
+	same := []byte(`{"role":"standby","time":` + now + `,"seq":12}`)
+	assert.Equal(t, http.StatusForbidden, post(same, standby.s.ha.sign(same)))
+
+	assert.Equal(t, http.StatusOK, post(data, standby.s.ha.sign(data)))
+
+	// the message is replayed
+	assert.Equal(t, http.StatusForbidden, post(data, standby.s.ha.sign(data)))
+	older := []byte(`{"role":"primary","time":` + now + `,"seq":9,"sync":true,"leases":[]}`)
+	assert.Equal(t, http.StatusForbidden, post(older, standby.s.ha.sign(older)))
+	newer := []byte(`{"role":"primary","time":` + now + `,"seq":13}`)
+	assert.Equal(t, http.StatusOK, post(newer, standby.s.ha.sign(newer)))
+}
+
+func TestHASeq(t *testing.T) {
+	ha := haState{}
+	s1 := ha.nextSeq()
+	s2 := ha.nextSeq()
+	assert.True(t, s2 > s1)
+
+	// the counter keeps increasing after restart
+	time.Sleep(time.Millisecond)
+	restarted := haState{}
+	assert.True(t, restarted.nextSeq() > s2)
+}
+
+func TestHASecretHidden(t *testing.T) {
+	s := Server{}
+	s.conf.HA = HAConfig{Enabled: true, Role: "primary", Secret: "secret123"}
+	w := httptest.NewRecorder()
+	s.handleDHCPStatus(w, httptest.NewRequest("GET", "/control/dhcp/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, strings.Contains(w.Body.String(), "secret123"))
+	assert.Equal(t, "secret123", s.conf.HA.Secret)
+}
@@ -0,0 +1,259 @@
+// Lease history: the lease events for each MAC address, first-seen and last-seen times of the devices
+
+package dhcpd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	historyFilename      = "leases_history.json"
+	historyPruneInterval = 24 * time.Hour // how often to remove the old events
+)
+
+// historyRecord - a lease event which is stored in the history file (one JSON object per line)
+type historyRecord struct {
+	Time     int64  `json:"time"` // UNIX time
+	Event    string `json:"event"`
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// leaseHistory - the history of lease events
+// The events are appended to the file;  the file is rewritten when the old events are removed.
+type leaseHistory struct {
+	lock      sync.Mutex
+	filename  string
+	keep      time.Duration   // how long to keep the events;  0: the history is disabled
+	records   []historyRecord // sorted by time
+	lastPrune time.Time
+}
+
+// historyEventJSON - a lease event for "/control/dhcp/history"
+type historyEventJSON struct {
+	Time     string `json:"time"`
+	Event    string `json:"event"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+}
+
+// deviceHistoryJSON - the device's history for "/control/dhcp/history"
+type deviceHistoryJSON struct {
+	MAC       string             `json:"mac"`
+	FirstSeen string             `json:"first_seen"`
+	LastSeen  string             `json:"last_seen"`
+	IP        string             `json:"ip"`       // the last IP address
+	Hostname  string             `json:"hostname"` // the last known host name
+	Events    []historyEventJSON `json:"events,omitempty"`
+}
+
+// Set the time period to keep the events for
+// days: 0 - the history is disabled
+func (h *leaseHistory) setDays(days uint32) {
+	h.lock.Lock()
+	h.keep = time.Duration(days) * 24 * time.Hour
+	h.lock.Unlock()
+}
+
+// Return TRUE if the events are stored
+func (h *leaseHistory) enabled() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.keep != 0 && len(h.filename) != 0
+}
+
+// Load the history from the file and remove the old events
+func (h *leaseHistory) init(filename string, days uint32) {
+	h.setDays(days)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.filename = filename
+	h.records = nil
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("DHCP: history: %s", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := historyRecord{}
+		err = json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			log.Debug("DHCP: history: invalid record: %s", err)
+			continue
+		}
+		h.records = append(h.records, r)
+	}
+	log.Debug("DHCP: history: loaded %d events", len(h.records))
+
+	if h.keep != 0 {
+		h.prune(time.Now())
+	}
+}
+
+// Remove all events
+func (h *leaseHistory) clear() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = nil
+	if len(h.filename) == 0 {
+		return
+	}
+	err := os.Remove(h.filename)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("DHCP: history: %s", err)
+	}
+}
+
+// Add the lease event to the history
+// The file is written here: don't call it while the leases are locked
+func (h *leaseHistory) add(event string, lease *Lease) {
+	if !h.enabled() {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	r := historyRecord{
+		Time:     now.Unix(),
+		Event:    event,
+		MAC:      lease.HWAddr.String(),
+		IP:       lease.IP.String(),
+		Hostname: lease.Hostname,
+	}
+	h.records = append(h.records, r)
+
+	if now.Sub(h.lastPrune) >= historyPruneInterval {
+		h.prune(now)
+		return
+	}
+
+	data, _ := json.Marshal(r)
+	f, err := os.OpenFile(h.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Error("DHCP: history: %s", err)
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		log.Error("DHCP: history: %s: %s", h.filename, err)
+	}
+}
+
+// Remove the events which are older than the retention period and rewrite the file
+// The first event of a device which has been seen during this period is kept:
+// it holds the device's first-seen time.
+func (h *leaseHistory) prune(now time.Time) {
+	h.lastPrune = now
+	cutoff := now.Add(-h.keep).Unix()
+	first := map[string]int{}  // MAC -> index of the first event
+	last := map[string]int64{} // MAC -> time of the last event
+	for i, r := range h.records {
+		_, ok := first[r.MAC]
+		if !ok {
+			first[r.MAC] = i
+		}
+		last[r.MAC] = r.Time
+	}
+
+	records := []historyRecord{}
+	var buf bytes.Buffer
+	for i, r := range h.records {
+		if r.Time < cutoff && !(first[r.MAC] == i && last[r.MAC] >= cutoff) {
+			continue
+		}
+		records = append(records, r)
+		data, _ := json.Marshal(r)
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	log.Debug("DHCP: history: removed %d old events", len(h.records)-len(records))
+	h.records = records
+
+	err := file.SafeWrite(h.filename, buf.Bytes())
+	if err != nil {
+		log.Error("DHCP: history: %s: %s", h.filename, err)
+	}
+}
+
+// Get the device's history
+// Return nil if the device isn't found
+func (h *leaseHistory) device(mac string) *deviceHistoryJSON {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var dev *deviceHistoryJSON
+	for _, r := range h.records {
+		if r.MAC != mac {
+			continue
+		}
+		if dev == nil {
+			dev = newDeviceHistory(r)
+		}
+		dev.update(r)
+		dev.Events = append(dev.Events, historyEventJSON{
+			Time:     time.Unix(r.Time, 0).Format(time.RFC3339),
+			Event:    r.Event,
+			IP:       r.IP,
+			Hostname: r.Hostname,
+		})
+	}
+	return dev
+}
+
+// Get all devices (without the events);  the recently appeared devices are the first
+func (h *leaseHistory) devices() []deviceHistoryJSON {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	index := map[string]*deviceHistoryJSON{}
+	list := []*deviceHistoryJSON{}
+	for _, r := range h.records {
+		dev, ok := index[r.MAC]
+		if !ok {
+			dev = newDeviceHistory(r)
+			index[r.MAC] = dev
+			list = append(list, dev)
+		}
+		dev.update(r)
+	}
+
+	// the events are sorted by time, so the list is sorted by first-seen time
+	result := []deviceHistoryJSON{}
+	for i := len(list) - 1; i >= 0; i-- {
+		result = append(result, *list[i])
+	}
+	return result
+}
+
+func newDeviceHistory(first historyRecord) *deviceHistoryJSON {
+	return &deviceHistoryJSON{
+		MAC:       first.MAC,
+		FirstSeen: time.Unix(first.Time, 0).Format(time.RFC3339),
+	}
+}
+
+// Update the last-seen time, IP address and host name
+func (dev *deviceHistoryJSON) update(r historyRecord) {
+	dev.LastSeen = time.Unix(r.Time, 0).Format(time.RFC3339)
+	dev.IP = r.IP
+	if len(r.Hostname) != 0 {
+		dev.Hostname = r.Hostname
+	}
+}
@@ -0,0 +1,131 @@
+package dhcpd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcphistory")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, historyFilename)
+
+	h := leaseHistory{}
+	h.init(fn, 1)
+	assert.True(t, h.enabled())
+	l1 := &Lease{HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}, IP: net.IP{192, 168, 1, 100}, Hostname: "phone"}
+	l2 := &Lease{HWAddr: net.HardwareAddr{2, 2, 3, 4, 5, 6}, IP: net.IP{192, 168, 1, 101}}
+	h.add(hookEventAdd, l1)
+	h.add(hookEventAdd, l2)
+	l1.Hostname = ""
+	h.add(hookEventRenew, l1)
+
+	dev := h.device("01:02:03:04:05:06")
+	assert.NotNil(t, dev)
+	assert.Equal(t, "phone", dev.Hostname)
+	assert.Equal(t, "192.168.1.100", dev.IP)
+	assert.Equal(t, 2, len(dev.Events))
+	assert.Equal(t, "add", dev.Events[0].Event)
+	assert.Equal(t, "renew", dev.Events[1].Event)
+	assert.Nil(t, h.device("03:02:03:04:05:06"))
+
+	devs := h.devices()
+	assert.Equal(t, 2, len(devs))
+	assert.Equal(t, "01:02:03:04:05:06", devs[1].MAC)
+	assert.Equal(t, 0, len(devs[1].Events))
+
+	// the events are stored in the file
+	h2 := leaseHistory{}
+	h2.init(fn, 1)
+	assert.Equal(t, 3, len(h2.records))
+
+	// the old events are removed, but the first event of the active device is kept
+	now := time.Now()
+	h2.records = []historyRecord{
+		{Time: now.Add(-72 * time.Hour).Unix(), Event: "add", MAC: "01:02:03:04:05:06"},
+		{Time: now.Add(-71 * time.Hour).Unix(), Event: "add", MAC: "02:02:03:04:05:06"},
+		{Time: now.Add(-48 * time.Hour).Unix(), Event: "renew", MAC: "01:02:03:04:05:06"},
+		{Time: now.Add(-time.Hour).Unix(), Event: "renew", MAC: "01:02:03:04:05:06"},
+	}
+	h2.prune(now)
+	assert.Equal(t, 2, len(h2.records))
+	dev = h2.device("01:02:03:04:05:06")
+	assert.Equal(t, time.Unix(h2.records[0].Time, 0).Format(time.RFC3339), dev.FirstSeen)
+	assert.Nil(t, h2.device("02:02:03:04:05:06"))
+
+	h.init(fn, 1)
+	assert.Equal(t, 2, len(h.records))
+
+	// the history is disabled
+	h.setDays(0)
+	h.add(hookEventAdd, l2)
+	assert.Equal(t, 2, len(h.records))
+
+	h.clear()
+	assert.Equal(t, 0, len(h.devices()))
+	_, err = os.Stat(fn)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHistoryEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcphistory")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.history.init(filepath.Join(dir, historyFilename), 30)
+
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	p := newTestPacket(hw, net.IPv4zero)
+	_ = s.handleDiscover(&s.dhcpScope, p, dhcp4.Options{})
+	opt := dhcp4.Options{dhcp4.OptionRequestedIPAddress: []byte{192, 168, 1, 100}}
+	_ = s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	p.SetCIAddr(net.IP{192, 168, 1, 100})
+	_ = s.handleRelease(p, dhcp4.Options{})
+
+	dev := s.history.device(hw.String())
+	assert.NotNil(t, dev)
+	assert.Equal(t, 2, len(dev.Events))
+	assert.Equal(t, "add", dev.Events[0].Event)
+	assert.Equal(t, "release", dev.Events[1].Event)
+}
+
+func TestHistoryStaticLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcphistory")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.history.init(filepath.Join(dir, historyFilename), 30)
+	s.hooks.queue = make(chan hookEvent, 10)
+
+	// the static lease matched by client ID: the event has the client's MAC address
+	clientID := []byte{1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	assert.Nil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 10}, ClientID: clientID}))
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	p := newTestPacket(hw, net.IPv4zero)
+	p.AddOption(dhcp4.OptionClientIdentifier, clientID)
+	opt := dhcp4.Options{dhcp4.OptionRequestedIPAddress: []byte{192, 168, 1, 10}}
+	reply := s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	assert.Equal(t, dhcp4.ACK, dhcp4.MessageType(reply.ParseOptions()[dhcp4.OptionDHCPMessageType][0]))
+	_ = s.handleDHCP4Request(&s.dhcpScope, p, opt)
+
+	dev := s.history.device(hw.String())
+	assert.NotNil(t, dev)
+	assert.Equal(t, 2, len(dev.Events))
+	assert.Equal(t, "renew", dev.Events[0].Event)
+	assert.Equal(t, "192.168.1.10", dev.IP)
+
+	// the hooks aren't notified about static leases
+	assert.Equal(t, 0, len(s.hooks.queue))
+}
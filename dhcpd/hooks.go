@@ -0,0 +1,279 @@
+// Lease event hooks: run a command or send a request to a URL when a lease changes
+
+package dhcpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Lease events
+const (
+	hookEventAdd     = "add"     // a client has got a new lease
+	hookEventRenew   = "renew"   // a client has renewed its lease
+	hookEventRelease = "release" // a client has released its lease
+	hookEventExpire  = "expire"  // a lease has expired
+	hookEventDecline = "decline" // a client has declined the address because it's used by another device
+	hookEventRogue   = "rogue"   // another DHCP server is detected in the network (MAC and IP of the server)
+)
+
+const (
+	hookTimeout         = 10 * time.Second // time limit for a command or HTTP request
+	hookQueueSize       = 256              // max. number of the events waiting to be sent
+	expireCheckInterval = time.Minute      // how often to check for expired leases
+)
+
+// HookConfig - a command or URL which is notified about lease events
+// Only one of Command and URL may be set.
+type HookConfig struct {
+	// Events to notify about: "add", "renew", "release", "expire", "decline", "rogue"
+	// Empty: all events
+	Events []string `json:"events" yaml:"events"`
+
+	// Path to the executable file which is run with the arguments: -- <event> <MAC> <IP> <hostname>
+	// "--" stops option parsing: the host name is set by the client.
+	// The event data in JSON format is passed to stdin.
+	Command string `json:"command" yaml:"command"`
+
+	// URL where the event data in JSON format is sent by POST request
+	URL string `json:"url" yaml:"url"`
+}
+
+// hookEvent - the event data which is sent to hooks
+type hookEvent struct {
+	Event       string `json:"event"`
+	MAC         string `json:"mac"`
+	IP          string `json:"ip"`
+	Hostname    string `json:"hostname"`
+	VendorClass string `json:"vendor_class"`
+	Expires     string `json:"expires,omitempty"` // lease expiration time (for "add" and "renew")
+	Time        string `json:"time"`              // event time
+}
+
+// hook - the parsed hook settings
+type hook struct {
+	events  map[string]bool // empty: all events
+	command string
+	url     string
+}
+
+// hooksRunner - sends the events to hooks in background
+type hooksRunner struct {
+	list   []hook         // parsed from config Hooks
+	queue  chan hookEvent // the events waiting to be sent
+	quit   chan bool
+	wg     sync.WaitGroup
+	client *http.Client
+}
+
+// Check and parse the hooks settings
+func parseHooks(list []HookConfig) ([]hook, error) {
+	hooks := []hook{}
+	for i, conf := range list {
+		h := hook{
+			events:  map[string]bool{},
+			command: conf.Command,
+			url:     conf.URL,
+		}
+
+		if (len(h.command) == 0) == (len(h.url) == 0) {
+			return nil, fmt.Errorf("hook #%d: either command or url must be set", i+1)
+		}
+		if len(h.url) != 0 {
+			u, err := url.Parse(h.url)
+			if err != nil || !(u.Scheme == "http" || u.Scheme == "https") || len(u.Host) == 0 {
+				return nil, fmt.Errorf("hook #%d: invalid URL %s", i+1, h.url)
+			}
+		}
+
+		for _, e := range conf.Events {
+			switch e {
+			case hookEventAdd, hookEventRenew, hookEventRelease, hookEventExpire, hookEventDecline, hookEventRogue:
+				h.events[e] = true
+			default:
+				return nil, fmt.Errorf("hook #%d: unknown event %s", i+1, e)
+			}
+		}
+
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// Start sending the events
+func (s *Server) startHooks() {
+	h := &s.hooks
+	if len(h.list) == 0 && !s.history.enabled() {
+		return
+	}
+	h.quit = make(chan bool)
+	if len(h.list) != 0 {
+		h.queue = make(chan hookEvent, hookQueueSize)
+		h.client = &http.Client{Timeout: hookTimeout}
+		h.wg.Add(1)
+		go s.runHooks()
+	}
+	h.wg.Add(1)
+	go s.watchExpiredLeases()
+}
+
+// Stop sending the events
+func (s *Server) stopHooks() {
+	h := &s.hooks
+	if h.quit == nil {
+		return
+	}
+	close(h.quit)
+	h.wg.Wait()
+	h.quit = nil
+	h.queue = nil
+}
+
+// Add the lease event to the history and send it to hooks
+// The history file is written here, so it must be called after the leases are unlocked:
+// pass a copy of the lease.
+// The events for static leases are added to the history only.
+func (s *Server) leaseEvent(event string, lease *Lease) {
+	s.history.add(event, lease)
+	if lease.Expiry.Unix() == leaseExpireStatic {
+		return
+	}
+	s.hookEvent(event, lease)
+}
+
+// Add the lease event to the queue
+// The lease data is copied, so it may be called while the lease is locked
+func (s *Server) hookEvent(event string, lease *Lease) {
+	queue := s.hooks.queue
+	if queue == nil {
+		return
+	}
+
+	now := time.Now()
+	e := hookEvent{
+		Event:       event,
+		MAC:         lease.HWAddr.String(),
+		IP:          lease.IP.String(),
+		Hostname:    lease.Hostname,
+		VendorClass: lease.VendorClass,
+		Time:        now.Format(time.RFC3339),
+	}
+	if event == hookEventAdd || event == hookEventRenew {
+		e.Expires = lease.Expiry.Format(time.RFC3339)
+	}
+
+	select {
+	case queue <- e:
+	default:
+		log.Error("DHCP: hooks: the queue is full, skipping the event %s for %s", event, e.MAC)
+	}
+}
+
+// Get the event for the lease which is being committed: "add" or "renew"
+// Must be called before the lease expiration time is updated
+// A static lease is always assigned to the device, so it's renewed.
+func commitEvent(lease *Lease) string {
+	if lease.Expiry.Unix() == leaseExpireStatic || lease.Expiry.After(time.Now()) {
+		return hookEventRenew
+	}
+	return hookEventAdd
+}
+
+func (s *Server) runHooks() {
+	defer s.hooks.wg.Done()
+	for {
+		select {
+		case e := <-s.hooks.queue:
+			for _, h := range s.hooks.list {
+				if len(h.events) != 0 && !h.events[e.Event] {
+					continue
+				}
+				s.sendHookEvent(h, e)
+			}
+
+		case <-s.hooks.quit:
+			return
+		}
+	}
+}
+
+// Run the command or send HTTP request
+func (s *Server) sendHookEvent(h hook, e hookEvent) {
+	data, _ := json.Marshal(e)
+
+	if len(h.url) != 0 {
+		resp, err := s.hooks.client.Post(h.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Error("DHCP: hooks: %s: %s", h.url, err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Error("DHCP: hooks: %s: status code %d", h.url, resp.StatusCode)
+			return
+		}
+		log.Debug("DHCP: hooks: sent %s event for %s to %s", e.Event, e.MAC, h.url)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.command, "--", e.Event, e.MAC, e.IP, e.Hostname)
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Error("DHCP: hooks: %s: %s: %s", h.command, err, out)
+		return
+	}
+	log.Debug("DHCP: hooks: ran %s for %s event for %s", h.command, e.Event, e.MAC)
+}
+
+// Get the dynamic leases which have expired within the time period (from, to]
+func (s *Server) expiredLeases(from, to time.Time) []*Lease {
+	zeroMAC := make(net.HardwareAddr, 6)
+	result := []*Lease{}
+
+	s.leasesLock.RLock()
+	defer s.leasesLock.RUnlock()
+	for _, leases := range [][]*Lease{s.leases, s.leases6} {
+		for _, l := range leases {
+			if l.Expiry.After(from) && !l.Expiry.After(to) &&
+				!bytes.Equal(l.HWAddr, zeroMAC) { // skip blacklisted addresses
+				lease := *l
+				result = append(result, &lease)
+			}
+		}
+	}
+	return result
+}
+
+// Send "expire" events for the expired leases
+func (s *Server) watchExpiredLeases() {
+	defer s.hooks.wg.Done()
+	t := time.NewTicker(expireCheckInterval)
+	defer t.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-t.C:
+			for _, l := range s.expiredLeases(last, now) {
+				log.Debug("DHCP: lease %s for %s has expired", l.IP, l.HWAddr)
+				s.leaseEvent(hookEventExpire, l)
+			}
+			last = now
+
+		case <-s.hooks.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package dhcpd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHooks(t *testing.T) {
+	_, err := parseHooks([]HookConfig{{URL: "http://127.0.0.1/hook", Events: []string{"add", "expire"}}})
+	assert.Nil(t, err)
+	_, err = parseHooks([]HookConfig{{Command: "/bin/true"}})
+	assert.Nil(t, err)
+
+	_, err = parseHooks([]HookConfig{{}})
+	assert.NotNil(t, err)
+	_, err = parseHooks([]HookConfig{{Command: "/bin/true", URL: "http://127.0.0.1/hook"}})
+	assert.NotNil(t, err)
+	_, err = parseHooks([]HookConfig{{URL: "ftp://127.0.0.1/hook"}})
+	assert.NotNil(t, err)
+	_, err = parseHooks([]HookConfig{{URL: "http://127.0.0.1/hook", Events: []string{"delete"}}})
+	assert.NotNil(t, err)
+}
+
+func TestHooksJSON(t *testing.T) {
+	// the hooks aren't returned and can't be set by HTTP API
+	conf := ServerConfig{Hooks: []HookConfig{{Command: "/bin/true"}}}
+	data, err := json.Marshal(conf)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(data), "/bin/true"))
+
+	newconf := dhcpServerConfigJSON{}
+	err = json.Unmarshal([]byte(`{"hooks":[{"command":"/bin/sh"}]}`), &newconf)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(newconf.Hooks))
+}
+
+func TestHooks(t *testing.T) {
+	events := make(chan hookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := hookEvent{}
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+
+	s := Server{}
+	s.conf.DBFilePath = dbFilename
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.reset()
+	s.leaseStart = []byte{1, 1, 1, 1}
+	s.leaseStop = []byte{1, 1, 1, 2}
+	s.leaseTime = 5 * time.Second
+	s.leaseOptions = dhcp4.Options{}
+	s.ipnet = &net.IPNet{
+		IP:   []byte{1, 2, 3, 4},
+		Mask: []byte{0xff, 0xff, 0xff, 0xff},
+	}
+
+	var err error
+	s.hooks.list, err = parseHooks([]HookConfig{{URL: srv.URL, Events: []string{"add", "renew", "release"}}})
+	assert.Nil(t, err)
+	s.startHooks()
+	defer s.stopHooks()
+
+	wait := func() hookEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return hookEvent{}
+	}
+
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	p := newTestPacket(hw, net.IPv4zero)
+	p.AddOption(dhcp4.OptionVendorClassIdentifier, []byte("MSFT 5.0"))
+	p.AddOption(dhcp4.OptionHostName, []byte("host1"))
+	_ = s.handleDiscover(&s.dhcpScope, p, p.ParseOptions())
+
+	opt := p.ParseOptions()
+	opt[dhcp4.OptionRequestedIPAddress] = []byte{1, 1, 1, 1}
+	_ = s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	e := wait()
+	assert.Equal(t, "add", e.Event)
+	assert.Equal(t, "01:02:03:04:05:06", e.MAC)
+	assert.Equal(t, "1.1.1.1", e.IP)
+	assert.Equal(t, "host1", e.Hostname)
+	assert.Equal(t, "MSFT 5.0", e.VendorClass)
+
+	_ = s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	assert.Equal(t, "renew", wait().Event)
+
+	p.SetCIAddr(net.IP{1, 1, 1, 1})
+	_ = s.handleRelease(p, dhcp4.Options{})
+	assert.Equal(t, "release", wait().Event)
+	assert.Equal(t, 0, len(s.Leases(LeasesDynamic)))
+
+	// the lease may be used again
+	_ = s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	assert.Equal(t, "add", wait().Event)
+}
+
+func TestHookCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts aren't supported")
+	}
+
+	dir, err := ioutil.TempDir("", "dhcphooks")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	data := "#!/bin/sh\necho \"$@\" > " + out + "\ncat >> " + out + "\n"
+	assert.Nil(t, ioutil.WriteFile(script, []byte(data), 0755))
+
+	s := Server{}
+	h, err := parseHooks([]HookConfig{{Command: script}})
+	assert.Nil(t, err)
+	s.sendHookEvent(h[0], hookEvent{Event: "expire", MAC: "01:02:03:04:05:06", IP: "1.1.1.1", Hostname: "host1"})
+
+	b, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	lines := strings.SplitN(string(b), "\n", 2)
+	assert.Equal(t, "-- expire 01:02:03:04:05:06 1.1.1.1 host1", lines[0])
+	assert.True(t, strings.Contains(lines[1], `"event":"expire"`))
+}
+
+func TestExpiredLeases(t *testing.T) {
+	s := Server{}
+	s.reset()
+	now := time.Now()
+	s.leases = []*Lease{
+		{HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}, IP: net.IP{1, 1, 1, 1}, Expiry: now.Add(-30 * time.Second)},
+		{HWAddr: net.HardwareAddr{2, 2, 3, 4, 5, 6}, IP: net.IP{1, 1, 1, 2}, Expiry: now.Add(-2 * time.Minute)},
+		{HWAddr: net.HardwareAddr{3, 2, 3, 4, 5, 6}, IP: net.IP{1, 1, 1, 3}, Expiry: time.Unix(leaseExpireStatic, 0)},
+		{HWAddr: make(net.HardwareAddr, 6), IP: net.IP{1, 1, 1, 4}, Expiry: now.Add(-30 * time.Second)},
+	}
+	s.leases6 = []*Lease{
+		{HWAddr: net.HardwareAddr{4, 2, 3, 4, 5, 6}, IP: net.ParseIP("2001::1"), Expiry: now.Add(-10 * time.Second)},
+	}
+
+	ll := s.expiredLeases(now.Add(-time.Minute), now)
+	assert.Equal(t, 2, len(ll))
+	assert.Equal(t, net.IP{1, 1, 1, 1}, ll[0].IP)
+	assert.Equal(t, net.ParseIP("2001::1"), ll[1].IP)
+}
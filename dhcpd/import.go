@@ -0,0 +1,521 @@
+// Import leases and reservations from dnsmasq and ISC dhcpd
+
+package dhcpd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Import formats
+const (
+	ImportDnsmasq = "dnsmasq" // dnsmasq "dhcp-host" lines and lease file
+	ImportISC     = "isc"     // ISC dhcpd "host" blocks and dhcpd.leases
+)
+
+// ImportResult - the result of importing a lease
+type ImportResult struct {
+	Line     int    `json:"line"` // the line number in the input data
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+	Static   bool   `json:"static"`
+	Expires  string `json:"expires,omitempty"` // for dynamic leases
+	Reason   string `json:"reason,omitempty"`  // why the lease is skipped
+}
+
+// ImportReport - the result of importing leases
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"` // the lease table isn't changed
+	Added   []ImportResult `json:"added"`
+	Skipped []ImportResult `json:"skipped"`
+}
+
+// importEntry - a lease which is parsed from the input data
+type importEntry struct {
+	line     int
+	mac      string
+	ip       string
+	hostname string
+	static   bool
+	expiry   time.Time // for dynamic leases
+	err      string    // parsing error
+}
+
+// ImportLeases parses the leases in dnsmasq or ISC dhcpd format,
+// checks them against the configured address ranges and adds them to the lease table (thread-safe)
+// format: "dnsmasq", "isc" or "" (detect by the data)
+// dryRun: don't change the lease table, just return the report
+func (s *Server) ImportLeases(format string, data string, dryRun bool) (*ImportReport, error) {
+	if len(format) == 0 {
+		format = detectImportFormat(data)
+	}
+	var entries []importEntry
+	switch format {
+	case ImportDnsmasq:
+		entries = parseDnsmasq(data)
+	case ImportISC:
+		entries = parseISC(data)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+
+	scopes, err := s.importScopes()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{
+		DryRun:  dryRun,
+		Added:   []ImportResult{},
+		Skipped: []ImportResult{},
+	}
+	now := time.Now()
+
+	s.leasesLock.Lock()
+	leases := []*Lease{}
+	for _, l := range s.leases {
+		lease := *l
+		leases = append(leases, &lease)
+	}
+
+	for _, e := range entries {
+		r := ImportResult{
+			Line:     e.line,
+			MAC:      e.mac,
+			IP:       e.ip,
+			Hostname: e.hostname,
+			Static:   e.static,
+		}
+		if !e.static {
+			r.Expires = e.expiry.Format(time.RFC3339)
+		}
+
+		lease, reason := e.toLease(scopes, now)
+		if lease != nil {
+			leases, reason = mergeImportedLease(leases, lease, now)
+		}
+		if len(reason) != 0 {
+			r.Reason = reason
+			report.Skipped = append(report.Skipped, r)
+			continue
+		}
+		report.Added = append(report.Added, r)
+	}
+
+	if dryRun || len(report.Added) == 0 {
+		s.leasesLock.Unlock()
+		return report, nil
+	}
+
+	s.leases = leases
+	s.IPpool = make(map[[4]byte]net.HardwareAddr)
+	for _, l := range s.leases {
+		s.reserveIP(l.IP, l.HWAddr)
+	}
+	s.dbStore()
+	s.leasesLock.Unlock()
+	s.notify(LeaseChangedAddedStatic)
+	return report, nil
+}
+
+// Get the scopes to check the imported leases against
+// The DHCP server may be disabled (e.g. while the old server is still running),
+// but it must be configured
+func (s *Server) importScopes() ([]*dhcpScope, error) {
+	if s.ipnet != nil {
+		return s.allScopes(), nil
+	}
+	tmp := Server{}
+	err := tmp.setConfig(s.conf)
+	if err != nil {
+		return nil, fmt.Errorf("DHCP server isn't configured: %s", err)
+	}
+	return tmp.allScopes(), nil
+}
+
+// Check the entry and convert it to a lease
+// Return the reason if the entry can't be used
+func (e *importEntry) toLease(scopes []*dhcpScope, now time.Time) (*Lease, string) {
+	if len(e.err) != 0 {
+		return nil, e.err
+	}
+
+	mac, err := net.ParseMAC(e.mac)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Sprintf("invalid MAC address %s", e.mac)
+	}
+	ip := net.ParseIP(e.ip).To4()
+	if ip == nil {
+		return nil, fmt.Sprintf("invalid IPv4 address %s", e.ip)
+	}
+
+	lease := &Lease{
+		HWAddr:   mac,
+		IP:       ip,
+		Hostname: e.hostname,
+		Expiry:   e.expiry,
+	}
+	if e.static {
+		lease.Expiry = time.Unix(leaseExpireStatic, 0)
+	} else if !e.expiry.After(now) {
+		return nil, "the lease has expired"
+	}
+
+	for _, sc := range scopes {
+		if ip.Equal(sc.ipnet.IP) {
+			return nil, "the address is used by the DHCP server"
+		}
+		if sc.leaseAllowed(lease) {
+			return lease, ""
+		}
+	}
+	if e.static {
+		return nil, "the address isn't within the configured subnets"
+	}
+	return nil, "the address isn't within the configured address ranges"
+}
+
+// Add the imported lease to the lease table
+// The dynamic leases with the same MAC or IP address are replaced
+// Return the reason if the lease can't be added
+func mergeImportedLease(leases []*Lease, lease *Lease, now time.Time) ([]*Lease, string) {
+	static := lease.Expiry.Unix() == leaseExpireStatic
+	for _, l := range leases {
+		sameMAC := bytes.Equal(l.HWAddr, lease.HWAddr)
+		sameIP := l.IP.Equal(lease.IP)
+		if !sameMAC && !sameIP {
+			continue
+		}
+
+		if l.Expiry.Unix() == leaseExpireStatic {
+			if sameMAC && sameIP {
+				return leases, "a static lease already exists"
+			}
+			if sameMAC {
+				return leases, fmt.Sprintf("the MAC address has a static lease with IP %s", l.IP)
+			}
+			return leases, fmt.Sprintf("the address is used by a static lease for %s", l.HWAddr)
+		}
+
+		if !static && !sameMAC && l.Expiry.After(now) {
+			return leases, fmt.Sprintf("the address is leased to %s", l.HWAddr)
+		}
+	}
+
+	result := []*Lease{}
+	for _, l := range leases {
+		if bytes.Equal(l.HWAddr, lease.HWAddr) || l.IP.Equal(lease.IP) {
+			continue
+		}
+		result = append(result, l)
+	}
+	return append(result, lease), ""
+}
+
+// Detect the format of the data: ISC dhcpd configuration consists of the blocks in curly braces
+func detectImportFormat(data string) string {
+	if strings.Contains(data, "{") {
+		return ImportISC
+	}
+	return ImportDnsmasq
+}
+
+var dnsmasqLeaseTime = regexp.MustCompile(`^([0-9]+[smhdw]?|infinite)$`)
+
+// Parse dnsmasq configuration ("dhcp-host" lines) and lease file
+// The other lines are ignored.
+//
+// dhcp-host=[<hwaddr>][,id:<client_id>|*][,set:<tag>][,tag:<tag>][,<ipaddr>][,<hostname>][,<lease_time>][,ignore]
+// <expiry time> <hwaddr> <ipaddr> <hostname> <client_id>
+func parseDnsmasq(data string) []importEntry {
+	entries := []importEntry{}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "dhcp-host=") {
+			entries = append(entries, parseDnsmasqHost(line[len("dhcp-host="):], i+1))
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		e := importEntry{
+			line:   i + 1,
+			mac:    fields[1],
+			ip:     fields[2],
+			expiry: time.Unix(expiry, 0),
+		}
+		if fields[3] != "*" {
+			e.hostname = fields[3]
+		}
+		if expiry == 0 {
+			e.static = true // infinite lease
+		}
+		if strings.Contains(e.ip, ":") {
+			e.err = "IPv6 leases aren't supported"
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Parse the value of "dhcp-host" option
+func parseDnsmasqHost(value string, line int) importEntry {
+	e := importEntry{line: line, static: true}
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case len(f) == 0:
+			continue
+
+		case f == "ignore":
+			e.err = "the host is ignored"
+
+		case strings.HasPrefix(f, "id:") || strings.HasPrefix(f, "set:") ||
+			strings.HasPrefix(f, "tag:") || strings.HasPrefix(f, "net:") ||
+			strings.HasPrefix(f, "["): // IPv6 address
+			continue
+
+		case strings.Contains(f, ":"):
+			if len(e.mac) != 0 {
+				continue // only the first MAC address is used
+			}
+			e.mac = f
+
+		case net.ParseIP(f) != nil:
+			e.ip = f
+
+		case dnsmasqLeaseTime.MatchString(f):
+			continue
+
+		default:
+			e.hostname = f
+		}
+	}
+
+	if len(e.err) == 0 && (len(e.mac) == 0 || len(e.ip) == 0) {
+		e.err = "both MAC and IP addresses must be set"
+	}
+	return e
+}
+
+// iscToken - a word, a quoted string or a special character ("{", "}", ";")
+type iscToken struct {
+	text   string
+	line   int
+	quoted bool
+}
+
+// Split ISC dhcpd configuration into tokens
+func iscTokens(data string) []iscToken {
+	tokens := []iscToken{}
+	line := 1
+	var word strings.Builder
+	flush := func() {
+		if word.Len() != 0 {
+			tokens = append(tokens, iscToken{text: word.String(), line: line})
+			word.Reset()
+		}
+	}
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '#':
+			flush()
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+
+		case '"':
+			flush()
+			var s strings.Builder
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' && i+1 < len(data) {
+					i++
+				}
+				s.WriteByte(data[i])
+			}
+			tokens = append(tokens, iscToken{text: s.String(), line: line, quoted: true})
+
+		case '{', '}', ';':
+			flush()
+			tokens = append(tokens, iscToken{text: string(c), line: line})
+
+		case ' ', '\t', '\r', '\n', ',':
+			flush()
+			if c == '\n' {
+				line++
+			}
+
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// Get the statements of the block which starts at tokens[i]
+// The nested blocks are skipped.
+// Return the index of the token after the block
+func iscBlock(tokens []iscToken, i int) ([][]string, int) {
+	stmts := [][]string{}
+	stmt := []string{}
+	depth := 0
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		if !t.quoted {
+			switch t.text {
+			case "{":
+				depth++
+				continue
+			case "}":
+				if depth == 0 {
+					return stmts, i + 1
+				}
+				depth--
+				continue
+			case ";":
+				if depth == 0 && len(stmt) != 0 {
+					stmts = append(stmts, stmt)
+				}
+				stmt = []string{}
+				continue
+			}
+		}
+		if depth == 0 {
+			stmt = append(stmt, t.text)
+		}
+	}
+	return stmts, i
+}
+
+// Parse ISC dhcpd configuration ("host" blocks) and dhcpd.leases ("lease" blocks)
+// The other blocks and statements are ignored.
+// dhcpd.leases is a log file: the last "lease" block for an IP address is used.
+func parseISC(data string) []importEntry {
+	entries := []importEntry{}
+	leases := map[string]int{} // IP -> index in entries
+	tokens := iscTokens(data)
+
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		if t.quoted || !(t.text == "host" || t.text == "lease") ||
+			i+2 >= len(tokens) || tokens[i+2].text != "{" {
+			i++
+			continue
+		}
+
+		name := tokens[i+1].text
+		var stmts [][]string
+		stmts, i = iscBlock(tokens, i+3)
+
+		if t.text == "host" {
+			entries = append(entries, parseISCHost(name, stmts, t.line))
+			continue
+		}
+
+		e := parseISCLease(name, stmts, t.line)
+		n, ok := leases[e.ip]
+		if ok {
+			entries[n] = e
+			continue
+		}
+		leases[e.ip] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Parse "host" block:
+// host <name> { hardware ethernet <hwaddr>; fixed-address <ipaddr>; option host-name "<hostname>"; }
+func parseISCHost(name string, stmts [][]string, line int) importEntry {
+	e := importEntry{line: line, static: true, hostname: name}
+	for _, st := range stmts {
+		switch {
+		case len(st) >= 3 && st[0] == "hardware" && st[1] == "ethernet":
+			e.mac = st[2]
+		case len(st) >= 2 && st[0] == "fixed-address":
+			e.ip = st[1]
+		case len(st) >= 3 && st[0] == "option" && st[1] == "host-name":
+			e.hostname = st[2]
+		}
+	}
+
+	if len(e.mac) == 0 {
+		e.err = "no hardware ethernet address"
+	} else if len(e.ip) == 0 {
+		e.err = "no fixed-address"
+	} else if net.ParseIP(e.ip) == nil {
+		e.err = fmt.Sprintf("fixed-address must be an IP address: %s", e.ip)
+	}
+	return e
+}
+
+// Parse "lease" block:
+// lease <ipaddr> { ends <time>; binding state active; hardware ethernet <hwaddr>; client-hostname "<hostname>"; }
+func parseISCLease(ip string, stmts [][]string, line int) importEntry {
+	e := importEntry{line: line, ip: ip}
+	state := ""
+	for _, st := range stmts {
+		switch {
+		case len(st) >= 3 && st[0] == "hardware" && st[1] == "ethernet":
+			e.mac = st[2]
+		case len(st) >= 2 && st[0] == "client-hostname":
+			e.hostname = st[1]
+		case len(st) >= 3 && st[0] == "binding" && st[1] == "state":
+			state = st[2]
+		case len(st) >= 2 && st[0] == "ends":
+			e.static, e.expiry = parseISCTime(st[1:])
+		}
+	}
+
+	if len(state) != 0 && state != "active" {
+		e.err = fmt.Sprintf("binding state is %s", state)
+	} else if len(e.mac) == 0 {
+		e.err = "no hardware ethernet address"
+	} else if !e.static && e.expiry.IsZero() {
+		e.err = "invalid lease end time"
+	}
+	return e
+}
+
+// Parse the lease end time: "never", "epoch <seconds>" or "<weekday> <yyyy/mm/dd> <hh:mm:ss>" (UTC)
+// Return true for "never"
+func parseISCTime(args []string) (bool, time.Time) {
+	if args[0] == "never" {
+		return true, time.Time{}
+	}
+	if args[0] == "epoch" && len(args) >= 2 {
+		sec, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return false, time.Time{}
+		}
+		return false, time.Unix(sec, 0)
+	}
+	if len(args) >= 3 {
+		t, err := time.Parse("2006/01/02 15:04:05", args[1]+" "+args[2])
+		if err != nil {
+			return false, time.Time{}
+		}
+		return false, t
+	}
+	return false, time.Time{}
+}
@@ -0,0 +1,153 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDnsmasq(t *testing.T) {
+	data := `# dnsmasq.conf
+domain=lan
+dhcp-range=192.168.1.100,192.168.1.200,12h
+dhcp-host=aa:bb:cc:dd:ee:01,192.168.1.10,printer,infinite
+dhcp-host=aa:bb:cc:dd:ee:02,id:*,set:tv,192.168.1.11
+dhcp-host=aa:bb:cc:dd:ee:03,laptop
+dhcp-host=aa:bb:cc:dd:ee:04,192.168.1.12,ignore
+1893456000 aa:bb:cc:dd:ee:05 192.168.1.150 phone 01:aa:bb:cc:dd:ee:05
+0 aa:bb:cc:dd:ee:06 192.168.1.151 * *
+`
+	entries := parseDnsmasq(data)
+	assert.Equal(t, 6, len(entries))
+
+	assert.Equal(t, importEntry{line: 4, mac: "aa:bb:cc:dd:ee:01", ip: "192.168.1.10", hostname: "printer", static: true}, entries[0])
+	assert.Equal(t, importEntry{line: 5, mac: "aa:bb:cc:dd:ee:02", ip: "192.168.1.11", static: true}, entries[1])
+	assert.NotEqual(t, "", entries[2].err)
+	assert.NotEqual(t, "", entries[3].err)
+
+	assert.Equal(t, 8, entries[4].line)
+	assert.False(t, entries[4].static)
+	assert.Equal(t, int64(1893456000), entries[4].expiry.Unix())
+	assert.Equal(t, "phone", entries[4].hostname)
+
+	assert.True(t, entries[5].static)
+	assert.Equal(t, "", entries[5].hostname)
+}
+
+func TestParseISC(t *testing.T) {
+	data := `# dhcpd.conf
+subnet 192.168.1.0 netmask 255.255.255.0 {
+  range 192.168.1.100 192.168.1.200;
+  host printer {
+    hardware ethernet aa:bb:cc:dd:ee:01;
+    fixed-address 192.168.1.10;
+  }
+}
+host tv { hardware ethernet aa:bb:cc:dd:ee:02; fixed-address 192.168.1.11; option host-name "tv-living"; }
+host nas { hardware ethernet aa:bb:cc:dd:ee:03; fixed-address nas.lan; }
+
+# dhcpd.leases
+lease 192.168.1.150 {
+  starts 4 2020/01/02 10:00:00;
+  ends 4 2020/01/02 22:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:04;
+  uid "\001\252\273\314\335\356\004";
+  client-hostname "phone";
+}
+lease 192.168.1.151 {
+  ends never;
+  hardware ethernet aa:bb:cc:dd:ee:05;
+}
+lease 192.168.1.150 {
+  ends epoch 1893456000; # Mon Jan 01 00:00:00 2030
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:06;
+}
+lease 192.168.1.152 {
+  ends 4 2020/01/02 22:00:00;
+  binding state free;
+  hardware ethernet aa:bb:cc:dd:ee:07;
+}
+`
+	entries := parseISC(data)
+	assert.Equal(t, 6, len(entries))
+
+	assert.Equal(t, importEntry{line: 4, mac: "aa:bb:cc:dd:ee:01", ip: "192.168.1.10", hostname: "printer", static: true}, entries[0])
+	assert.Equal(t, importEntry{line: 9, mac: "aa:bb:cc:dd:ee:02", ip: "192.168.1.11", hostname: "tv-living", static: true}, entries[1])
+	assert.NotEqual(t, "", entries[2].err)
+
+	// the last lease for the IP address is used
+	assert.Equal(t, "aa:bb:cc:dd:ee:06", entries[3].mac)
+	assert.Equal(t, 25, entries[3].line)
+	assert.Equal(t, int64(1893456000), entries[3].expiry.Unix())
+
+	assert.True(t, entries[4].static)
+	assert.Equal(t, "binding state is free", entries[5].err)
+
+	_, tm := parseISCTime([]string{"4", "2020/01/02", "22:00:00"})
+	assert.Equal(t, time.Date(2020, 1, 2, 22, 0, 0, 0, time.UTC), tm)
+
+	assert.Equal(t, ImportISC, detectImportFormat(data))
+	assert.Equal(t, ImportDnsmasq, detectImportFormat("dhcp-host=aa:bb:cc:dd:ee:01,192.168.1.10"))
+}
+
+func TestImportLeases(t *testing.T) {
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+	assert.Nil(t, s.AddStaticLease(Lease{HWAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}, IP: net.IP{192, 168, 1, 10}}))
+
+	data := `dhcp-host=aa:bb:cc:dd:ee:01,192.168.1.10
+dhcp-host=aa:bb:cc:dd:ee:02,192.168.1.10
+dhcp-host=aa:bb:cc:dd:ee:03,192.168.1.20,printer
+dhcp-host=aa:bb:cc:dd:ee:04,192.168.2.20
+dhcp-host=aa:bb:cc:dd:ee:05,192.168.1.2
+dhcp-host=aa:bb:cc:dd:ee:06,10.0.10.5,tv
+1893456000 aa:bb:cc:dd:ee:07 10.0.10.150 phone *
+1893456000 aa:bb:cc:dd:ee:08 10.0.10.50 * *
+1000000000 aa:bb:cc:dd:ee:09 10.0.10.151 * *
+1893456000 aa:bb:cc:dd:ee:0a 10.0.10.150 * *
+`
+	// dry run: the lease table isn't changed
+	report, err := s.ImportLeases("", data, true)
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, len(report.Added))
+	assert.Equal(t, 7, len(report.Skipped))
+	assert.Equal(t, 1, len(s.Leases(LeasesAll)))
+
+	report, err = s.ImportLeases(ImportDnsmasq, data, false)
+	assert.Nil(t, err)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 3, report.Added[0].Line)
+	assert.Equal(t, 6, report.Added[1].Line)
+	assert.Equal(t, 7, report.Added[2].Line)
+
+	reasons := map[int]string{}
+	for _, r := range report.Skipped {
+		reasons[r.Line] = r.Reason
+	}
+	assert.Equal(t, "a static lease already exists", reasons[1])
+	assert.Equal(t, "the address is used by a static lease for aa:bb:cc:dd:ee:01", reasons[2])
+	assert.Equal(t, "the address isn't within the configured subnets", reasons[4])
+	assert.Equal(t, "the address is used by the DHCP server", reasons[5])
+	assert.Equal(t, "the address isn't within the configured address ranges", reasons[8])
+	assert.Equal(t, "the lease has expired", reasons[9])
+	assert.Equal(t, "the address is leased to aa:bb:cc:dd:ee:07", reasons[10])
+
+	assert.Equal(t, 3, len(s.Leases(LeasesStatic)))
+	assert.Equal(t, 1, len(s.Leases(LeasesDynamic)))
+	assert.Equal(t, net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x07}, s.FindMACbyIP(net.IP{10, 0, 10, 150}))
+	assert.Equal(t, net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x03}, s.findReservedHWaddr(net.IP{192, 168, 1, 20}))
+
+	// the leases are stored in DB
+	s2 := newTestServerScopes(t)
+	s2.dbLoad()
+	assert.Equal(t, 4, len(s2.Leases(LeasesAll)))
+
+	_, err = s.ImportLeases("unknown", data, true)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,139 @@
+// Custom DHCP options
+
+package dhcpd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// DHCPOption - DHCPv4 option which is sent to the clients
+// Value format depends on the type:
+// . ip: IPv4 address
+// . ip-list: comma-separated list of IPv4 addresses
+// . string: text
+// . hex: binary data in hex format, e.g. "0a:00:00" or "0a0000"
+// . u8, u16, u32: unsigned integer number
+type DHCPOption struct {
+	Code  uint8  `json:"code" yaml:"code"`
+	Type  string `json:"type" yaml:"type"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// Options which are set by the server itself and can't be overridden
+var reservedOptions = map[dhcp4.OptionCode]bool{
+	dhcp4.Pad:                          true,
+	dhcp4.End:                          true,
+	dhcp4.OptionRequestedIPAddress:     true,
+	dhcp4.OptionIPAddressLeaseTime:     true,
+	dhcp4.OptionOverload:               true,
+	dhcp4.OptionDHCPMessageType:        true,
+	dhcp4.OptionServerIdentifier:       true,
+	dhcp4.OptionParameterRequestList:   true,
+	dhcp4.OptionMaximumDHCPMessageSize: true,
+	dhcp4.OptionClientIdentifier:       true,
+}
+
+// Get binary data of the option
+func (o DHCPOption) data() ([]byte, error) {
+	val := strings.TrimSpace(o.Value)
+
+	switch o.Type {
+	case "ip":
+		ip, err := parseIPv4(val)
+		if err != nil {
+			return nil, err
+		}
+		return ip, nil
+
+	case "ip-list":
+		data := []byte{}
+		for _, s := range strings.Split(val, ",") {
+			ip, err := parseIPv4(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, ip...)
+		}
+		return data, nil
+
+	case "string":
+		if len(val) == 0 {
+			return nil, fmt.Errorf("empty string")
+		}
+		return []byte(val), nil
+
+	case "hex":
+		val = strings.NewReplacer(":", "", " ", "").Replace(val)
+		data, err := hex.DecodeString(val)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("empty data")
+		}
+		return data, nil
+
+	case "u8", "u16", "u32":
+		bits, _ := strconv.Atoi(o.Type[1:])
+		n, err := strconv.ParseUint(val, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(n))
+		return data[4-bits/8:], nil
+	}
+
+	return nil, fmt.Errorf("unknown type %s", o.Type)
+}
+
+// Convert the list of options to the object which is used by DHCP server
+func parseOptions(list []DHCPOption) (dhcp4.Options, error) {
+	opts := dhcp4.Options{}
+	for _, o := range list {
+		code := dhcp4.OptionCode(o.Code)
+		if reservedOptions[code] {
+			return nil, fmt.Errorf("option %d can't be changed", o.Code)
+		}
+		data, err := o.data()
+		if err != nil {
+			return nil, fmt.Errorf("option %d: %s", o.Code, err)
+		}
+		if len(data) > 255 {
+			return nil, fmt.Errorf("option %d: value is too long", o.Code)
+		}
+		opts[code] = data
+	}
+	return opts, nil
+}
+
+// Get the options for the lease: the scope's options overridden by the lease's gateway, DNS servers and options
+func (s *Server) getLeaseOptions(sc *dhcpScope, lease *Lease) dhcp4.Options {
+	overrides := leaseOverrides(lease)
+	if len(lease.Options) == 0 && len(overrides) == 0 {
+		return sc.leaseOptions
+	}
+
+	leaseOpts, err := parseOptions(lease.Options)
+	if err != nil {
+		// shouldn't happen: the options are checked when the lease is added
+		leaseOpts = dhcp4.Options{}
+	}
+	opts := dhcp4.Options{}
+	for code, data := range sc.leaseOptions {
+		opts[code] = data
+	}
+	for code, data := range overrides {
+		opts[code] = data
+	}
+	for code, data := range leaseOpts {
+		opts[code] = data
+	}
+	return opts
+}
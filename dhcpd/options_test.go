@@ -0,0 +1,82 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOptions(t *testing.T) {
+	opts, err := parseOptions([]DHCPOption{
+		{Code: 42, Type: "ip-list", Value: "1.1.1.1, 2.2.2.2"},
+		{Code: 15, Type: "string", Value: "lan"},
+		{Code: 121, Type: "hex", Value: "18:c0:a8:02:c0:a8:01:01"},
+		{Code: 252, Type: "string", Value: "http://wpad.lan/wpad.dat"},
+		{Code: 23, Type: "u8", Value: "64"},
+		{Code: 26, Type: "u16", Value: "1400"},
+		{Code: 2, Type: "u32", Value: "3600"},
+		{Code: 28, Type: "ip", Value: "192.168.1.255"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 1, 1, 1, 2, 2, 2, 2}, opts[42])
+	assert.Equal(t, []byte("lan"), opts[15])
+	assert.Equal(t, []byte{0x18, 0xc0, 0xa8, 2, 0xc0, 0xa8, 1, 1}, opts[121])
+	assert.Equal(t, []byte{64}, opts[23])
+	assert.Equal(t, []byte{0x05, 0x78}, opts[26])
+	assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, opts[2])
+	assert.Equal(t, []byte{192, 168, 1, 255}, opts[28])
+
+	_, err = parseOptions([]DHCPOption{{Code: 23, Type: "u8", Value: "256"}})
+	assert.NotNil(t, err)
+	_, err = parseOptions([]DHCPOption{{Code: 42, Type: "ip-list", Value: "1.1.1.1,::1"}})
+	assert.NotNil(t, err)
+	_, err = parseOptions([]DHCPOption{{Code: 121, Type: "hex", Value: "zz"}})
+	assert.NotNil(t, err)
+	_, err = parseOptions([]DHCPOption{{Code: 15, Type: "domain", Value: "lan"}})
+	assert.NotNil(t, err)
+	_, err = parseOptions([]DHCPOption{{Code: 54, Type: "ip", Value: "1.1.1.1"}})
+	assert.NotNil(t, err)
+}
+
+func TestStaticLeaseOptions(t *testing.T) {
+	s := Server{}
+	s.conf.DBFilePath = dbFilename
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.reset()
+	s.leaseOptions = dhcp4.Options{
+		dhcp4.OptionRouter:           []byte{1, 1, 1, 1},
+		dhcp4.OptionDomainNameServer: []byte{1, 1, 1, 2},
+	}
+
+	l := Lease{
+		HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		IP:     []byte{1, 1, 1, 10},
+		Options: []DHCPOption{
+			{Code: 6, Type: "ip", Value: "9.9.9.9"},
+			{Code: 67, Type: "string", Value: "pxelinux.0"},
+		},
+	}
+	assert.Nil(t, s.AddStaticLease(l))
+
+	opts := s.getLeaseOptions(&s.dhcpScope, s.leases[0])
+	assert.Equal(t, []byte{1, 1, 1, 1}, opts[dhcp4.OptionRouter])
+	assert.Equal(t, []byte{9, 9, 9, 9}, opts[dhcp4.OptionDomainNameServer])
+	assert.Equal(t, []byte("pxelinux.0"), opts[dhcp4.OptionBootFileName])
+	// the server's options aren't changed
+	assert.Equal(t, []byte{1, 1, 1, 2}, s.leaseOptions[dhcp4.OptionDomainNameServer])
+
+	// the options are stored in DB
+	s.reset()
+	s.dbLoad()
+	ll := s.Leases(LeasesStatic)
+	assert.Equal(t, 1, len(ll))
+	assert.Equal(t, l.Options, ll[0].Options)
+
+	l.HWAddr = net.HardwareAddr{2, 2, 3, 4, 5, 6}
+	l.IP = []byte{1, 1, 1, 11}
+	l.Options = []DHCPOption{{Code: 53, Type: "u8", Value: "1"}}
+	assert.NotNil(t, s.AddStaticLease(l))
+}
@@ -1,8 +1,10 @@
 package dhcpd
 
 import (
+	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"syscall"
 
 	"golang.org/x/net/ipv4"
@@ -43,3 +45,26 @@ func newBroadcastPacketConn(bindAddr net.IP, port int, ifname string) (*ipv4.Pac
 	p := ipv4.NewPacketConn(c)
 	return p, nil
 }
+
+// Get MAC address of the host from ARP cache
+// Return nil if the address isn't found
+func arpLookup(ip net.IP) net.HardwareAddr {
+	data, err := ioutil.ReadFile("/proc/net/arp")
+	if err != nil {
+		return nil
+	}
+
+	// IP address       HW type     Flags       HW address            Mask     Device
+	// 192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 4 || f[0] != ip.String() || f[2] == "0x0" { // 0x0: incomplete entry
+			continue
+		}
+		mac, err := net.ParseMAC(f[3])
+		if err == nil {
+			return mac
+		}
+	}
+	return nil
+}
@@ -42,3 +42,9 @@ func newBroadcastPacketConn(bindAddr net.IP, port int, ifname string) (*ipv4.Pac
 	p := ipv4.NewPacketConn(c)
 	return p, nil
 }
+
+// Get MAC address of the host from ARP cache
+// Not supported
+func arpLookup(ip net.IP) net.HardwareAddr {
+	return nil
+}
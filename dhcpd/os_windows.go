@@ -11,3 +11,9 @@ import (
 func newBroadcastPacketConn(bindAddr net.IP, port int, ifname string) (*ipv4.PacketConn, error) {
 	return nil, errors.New("newBroadcastPacketConn(): not supported on Windows")
 }
+
+// Get MAC address of the host from ARP cache
+// Not supported
+func arpLookup(ip net.IP) net.HardwareAddr {
+	return nil
+}
@@ -0,0 +1,285 @@
+// Rogue DHCP server monitoring: detect other DHCP servers in the network while our server is running
+
+package dhcpd
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/krolaw/dhcp4"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	rogueAlertTTL     = 24 * time.Hour // how long to report a server which isn't seen anymore
+	rogueARPAttempts  = 3              // how many times to look up the server's MAC address in ARP cache
+	rogueARPWait      = time.Second    // the time to wait between the attempts
+	rogueDiscardPort  = 9              // the packets to this port make OS resolve the server's MAC address
+	rogueMaxPacketLen = 1500
+)
+
+// rogueServer - a DHCP server which isn't us
+type rogueServer struct {
+	ip        net.IP
+	mac       net.HardwareAddr // nil if unknown
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// rogueServerJSON - a rogue server for "/control/dhcp/status"
+type rogueServerJSON struct {
+	IP        string `json:"ip"`
+	MAC       string `json:"mac"` // empty if unknown
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+}
+
+// rogueMonitor - the state of the rogue DHCP server monitor
+type rogueMonitor struct {
+	lock     sync.Mutex
+	interval time.Duration // how often to send Discover;  0: the monitor is disabled
+	servers  map[string]*rogueServer
+
+	conn *ipv4.PacketConn // listens on port 68 of the main interface
+	quit chan bool
+	wg   sync.WaitGroup
+
+	resolveMAC func(ip net.IP) net.HardwareAddr
+}
+
+// Set the interval between active checks
+// minutes: 0 - the monitor is disabled
+func (m *rogueMonitor) setInterval(minutes uint32) {
+	m.lock.Lock()
+	m.interval = time.Duration(minutes) * time.Minute
+	m.lock.Unlock()
+}
+
+// Start sending Discover packets and listening for the replies from other DHCP servers
+func (s *Server) startRogueMonitor() {
+	m := &s.rogue
+	m.lock.Lock()
+	interval := m.interval
+	m.lock.Unlock()
+	if interval == 0 || s.iface == nil {
+		return
+	}
+
+	conn, err := newBroadcastPacketConn(net.IPv4(0, 0, 0, 0), 68, s.iface.Name)
+	if err != nil {
+		log.Error("DHCP: rogue server monitor: couldn't listen on :68: %s", err)
+		return
+	}
+	log.Debug("DHCP: rogue server monitor: listening on %s:68", s.iface.Name)
+
+	m.conn = conn
+	m.quit = make(chan bool)
+	if m.resolveMAC == nil {
+		m.resolveMAC = resolveMAC
+	}
+	m.wg.Add(2)
+	go s.rogueListen(conn)
+	go s.rogueProbe(conn, interval)
+}
+
+// Stop the monitor
+func (s *Server) stopRogueMonitor() {
+	m := &s.rogue
+	if m.quit == nil {
+		return
+	}
+	close(m.quit)
+	_ = m.conn.Close()
+	m.wg.Wait()
+	m.quit = nil
+	m.conn = nil
+}
+
+// Send Discover packets periodically: the replies are received by rogueListen()
+func (s *Server) rogueProbe(conn *ipv4.PacketConn, interval time.Duration) {
+	m := &s.rogue
+	defer m.wg.Done()
+	dst := &net.UDPAddr{IP: net.IPv4bcast, Port: 67}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		packet, _, err := newDiscoverPacket(s.iface)
+		if err == nil {
+			_, err = conn.WriteTo(packet, nil, dst)
+			if err != nil {
+				log.Debug("DHCP: rogue server monitor: %s", err)
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// Receive the replies from DHCP servers:
+// to our Discover packets and broadcast replies to the other clients
+func (s *Server) rogueListen(conn *ipv4.PacketConn) {
+	m := &s.rogue
+	defer m.wg.Done()
+	b := make([]byte, rogueMaxPacketLen)
+
+	for {
+		n, _, from, err := conn.ReadFrom(b)
+		if err != nil {
+			select {
+			case <-m.quit:
+				return
+			default:
+			}
+			log.Debug("DHCP: rogue server monitor: %s", err)
+			time.Sleep(time.Second) // don't loop too fast on a permanent error
+			continue
+		}
+
+		var fromIP net.IP
+		udpAddr, ok := from.(*net.UDPAddr)
+		if ok {
+			fromIP = udpAddr.IP
+		}
+		ip := s.rogueServerIP(dhcp4.Packet(b[:n]), fromIP)
+		if ip != nil {
+			s.rogueDetected(ip)
+		}
+	}
+}
+
+// Get the address of the DHCP server which has sent the reply
+// Return nil if the packet isn't a DHCP reply or it's sent by us
+func (s *Server) rogueServerIP(p dhcp4.Packet, from net.IP) net.IP {
+	if len(p) < 240 || p.OpCode() != dhcp4.BootReply {
+		return nil
+	}
+	options := p.ParseOptions()
+	t := options[dhcp4.OptionDHCPMessageType]
+	if len(t) != 1 {
+		return nil
+	}
+	switch dhcp4.MessageType(t[0]) {
+	case dhcp4.Offer, dhcp4.ACK, dhcp4.NAK:
+	default:
+		return nil
+	}
+
+	ip := net.IP(options[dhcp4.OptionServerIdentifier]).To4()
+	if ip == nil {
+		ip = from.To4()
+	}
+	if ip == nil {
+		return nil
+	}
+
+	for _, sc := range s.allScopes() {
+		if sc.ipnet != nil && (sc.ipnet.IP.Equal(ip) || sc.ipnet.IP.Equal(from)) {
+			return nil
+		}
+	}
+	return ip
+}
+
+// Record the rogue server;  a new server is reported to the log and hooks
+func (s *Server) rogueDetected(ip net.IP) {
+	m := &s.rogue
+	now := time.Now()
+	m.lock.Lock()
+	if m.servers == nil {
+		m.servers = map[string]*rogueServer{}
+	}
+	srv, ok := m.servers[ip.String()]
+	if ok && now.Sub(srv.lastSeen) < rogueAlertTTL {
+		srv.lastSeen = now
+		m.lock.Unlock()
+		return
+	}
+	srv = &rogueServer{
+		ip:        ip,
+		firstSeen: now,
+		lastSeen:  now,
+	}
+	m.servers[ip.String()] = srv
+	m.lock.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		mac := m.resolveMAC(ip)
+		m.lock.Lock()
+		srv.mac = mac
+		m.lock.Unlock()
+
+		log.Error("DHCP: detected another DHCP server in the network: IP: %s  MAC: %s", ip, mac)
+		s.hookEvent(hookEventRogue, &Lease{HWAddr: mac, IP: ip})
+	}()
+}
+
+// Get MAC address of the host in the local network
+func resolveMAC(ip net.IP) net.HardwareAddr {
+	for i := 0; i < rogueARPAttempts; i++ {
+		mac := arpLookup(ip)
+		if mac != nil {
+			return mac
+		}
+
+		// make OS resolve the address
+		c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: rogueDiscardPort})
+		if err == nil {
+			_, _ = c.Write([]byte{0})
+			_ = c.Close()
+		}
+		time.Sleep(rogueARPWait)
+	}
+	return nil
+}
+
+// Get the rogue servers which have been seen recently
+func (s *Server) rogueServers() []rogueServerJSON {
+	m := &s.rogue
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	list := []*rogueServer{}
+	now := time.Now()
+	for k, srv := range m.servers {
+		if now.Sub(srv.lastSeen) >= rogueAlertTTL {
+			delete(m.servers, k)
+			continue
+		}
+		list = append(list, srv)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].firstSeen.Before(list[j].firstSeen)
+	})
+
+	result := []rogueServerJSON{}
+	for _, srv := range list {
+		j := rogueServerJSON{
+			IP:        srv.ip.String(),
+			FirstSeen: srv.firstSeen.Format(time.RFC3339),
+			LastSeen:  srv.lastSeen.Format(time.RFC3339),
+		}
+		if srv.mac != nil {
+			j.MAC = srv.mac.String()
+		}
+		result = append(result, j)
+	}
+	return result
+}
+
+// Return TRUE if the packet is sent by us from the network interface
+// Our server must not reply to the Discover packets of the monitor
+func (s *Server) isOwnPacket(p dhcp4.Packet) bool {
+	return s.iface != nil && len(s.iface.HardwareAddr) != 0 &&
+		bytes.Equal(p.CHAddr(), s.iface.HardwareAddr)
+}
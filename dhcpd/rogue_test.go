@@ -0,0 +1,80 @@
+package dhcpd
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRogueServerIP(t *testing.T) {
+	s := newTestServerScopes(t)
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	req := newTestPacket(hw, net.IPv4zero)
+	from := net.IP{192, 168, 1, 1}
+
+	// a request isn't a reply
+	assert.Nil(t, s.rogueServerIP(req, from))
+
+	// another server
+	offer := dhcp4.ReplyPacket(req, dhcp4.Offer, net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 50}, time.Hour, nil)
+	assert.Equal(t, net.IP{192, 168, 1, 1}, s.rogueServerIP(offer, from))
+	ack := dhcp4.ReplyPacket(req, dhcp4.ACK, net.IP{10, 0, 10, 254}, net.IP{10, 0, 10, 50}, time.Hour, nil)
+	assert.Equal(t, net.IP{10, 0, 10, 254}, s.rogueServerIP(ack, net.IP{10, 0, 10, 254}))
+
+	// our server
+	offer = dhcp4.ReplyPacket(req, dhcp4.Offer, net.IP{192, 168, 1, 2}, net.IP{192, 168, 1, 100}, time.Hour, nil)
+	assert.Nil(t, s.rogueServerIP(offer, net.IP{192, 168, 1, 2}))
+
+	// our own Discover packets are ignored by our server
+	s.iface = &net.Interface{Index: 2, Name: "eth0", HardwareAddr: hw}
+	assert.True(t, s.isOwnPacket(req))
+	assert.Nil(t, s.serveDHCP(&s.dhcpScope, req, dhcp4.Discover, dhcp4.Options{}))
+}
+
+func TestRogueDetected(t *testing.T) {
+	events := make(chan hookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := hookEvent{}
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+
+	s := newTestServerScopes(t)
+	var err error
+	s.hooks.list, err = parseHooks([]HookConfig{{URL: srv.URL, Events: []string{"rogue"}}})
+	assert.Nil(t, err)
+	s.startHooks()
+	defer s.stopHooks()
+	s.rogue.resolveMAC = func(ip net.IP) net.HardwareAddr {
+		return net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	}
+
+	s.rogueDetected(net.IP{192, 168, 1, 1})
+	s.rogueDetected(net.IP{192, 168, 1, 1})
+	s.rogue.wg.Wait()
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "rogue", e.Event)
+		assert.Equal(t, "192.168.1.1", e.IP)
+		assert.Equal(t, "aa:bb:cc:dd:ee:ff", e.MAC)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	list := s.rogueServers()
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "192.168.1.1", list[0].IP)
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", list[0].MAC)
+
+	// the server isn't seen for a long time
+	s.rogue.servers["192.168.1.1"].lastSeen = time.Now().Add(-rogueAlertTTL)
+	assert.Equal(t, 0, len(s.rogueServers()))
+}
@@ -0,0 +1,199 @@
+// ICMPv6 Router Advertisement sender (RFC 4861, RFC 8106)
+
+package dhcpd
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	raInterval       = 60 * time.Second // time period to send unsolicited Router Advertisement messages
+	raMinInterval    = 3 * time.Second  // min time period between 2 messages
+	raRDNSSLifetime  = 3 * 60           // lifetime of the DNS server address (in seconds)
+	raPrefixValid    = 24 * 60 * 60     // valid lifetime of the prefix (in seconds)
+	raPrefixPrefered = 4 * 60 * 60      // preferred lifetime of the prefix (in seconds)
+)
+
+// raSender - sends Router Advertisement messages with the address of our DNS server:
+// . unsolicited messages are sent periodically
+// . a message is sent in response to Router Solicitation
+// We don't advertise ourselves as a default router (Router Lifetime = 0),
+// so the clients don't change their routing settings.
+type raSender struct {
+	iface   *net.Interface
+	managed bool       // addresses are available via DHCPv6 (M and O flags)
+	slaac   bool       // the clients may configure their addresses themselves (A flag)
+	prefix  *net.IPNet // network prefix (optional)
+	dnsIP   net.IP     // our DNS server address
+
+	conn     *icmp.PacketConn
+	lock     sync.Mutex
+	lastSent time.Time
+	quit     chan bool
+	wg       sync.WaitGroup
+}
+
+// Create ICMPv6 Router Advertisement message
+func (ra *raSender) createPacket() []byte {
+	b := make([]byte, 16)
+	b[0] = byte(ipv6.ICMPTypeRouterAdvertisement)
+	// b[1]: code 0, b[2:4]: checksum is set by OS
+	b[4] = 64 // Cur Hop Limit
+	if ra.managed {
+		b[5] = 0x80 | 0x40 // M, O
+	}
+	// b[6:8]: Router Lifetime 0, b[8:16]: Reachable Time and Retrans Timer are unspecified
+
+	// Source Link-Layer Address
+	if len(ra.iface.HardwareAddr) == 6 {
+		opt := []byte{1, 1}
+		opt = append(opt, ra.iface.HardwareAddr...)
+		b = append(b, opt...)
+	}
+
+	// MTU
+	if ra.iface.MTU != 0 {
+		opt := make([]byte, 8)
+		opt[0] = 5
+		opt[1] = 1
+		binary.BigEndian.PutUint32(opt[4:], uint32(ra.iface.MTU))
+		b = append(b, opt...)
+	}
+
+	// Prefix Information
+	if ra.prefix != nil {
+		opt := make([]byte, 32)
+		opt[0] = 3
+		opt[1] = 4
+		ones, _ := ra.prefix.Mask.Size()
+		opt[2] = byte(ones)
+		opt[3] = 0x80 // L: the prefix is on-link
+		if ra.slaac {
+			opt[3] |= 0x40 // A: autonomous address-configuration
+		}
+		binary.BigEndian.PutUint32(opt[4:], raPrefixValid)
+		binary.BigEndian.PutUint32(opt[8:], raPrefixPrefered)
+		copy(opt[16:], ra.prefix.IP.Mask(ra.prefix.Mask).To16())
+		b = append(b, opt...)
+	}
+
+	// Recursive DNS Server
+	if ra.dnsIP != nil {
+		opt := make([]byte, 24)
+		opt[0] = 25
+		opt[1] = 3
+		binary.BigEndian.PutUint32(opt[4:], raRDNSSLifetime)
+		copy(opt[8:], ra.dnsIP.To16())
+		b = append(b, opt...)
+	}
+
+	return b
+}
+
+// Start sending Router Advertisement messages
+func (ra *raSender) start() error {
+	linkLocal, _ := getIfaceIPv6(ra.iface)
+	addr := "::"
+	if linkLocal != nil {
+		addr = linkLocal.String() + "%" + ra.iface.Name
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", addr)
+	if err != nil {
+		return err
+	}
+
+	p := conn.IPv6PacketConn()
+	f := ipv6.ICMPFilter{}
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterSolicitation)
+	err = p.SetICMPFilter(&f)
+	if err == nil {
+		err = p.SetMulticastHopLimit(255)
+	}
+	if err == nil {
+		err = p.SetHopLimit(255)
+	}
+	if err == nil {
+		err = p.SetMulticastInterface(ra.iface)
+	}
+	if err == nil {
+		err = p.SetControlMessage(ipv6.FlagInterface, true)
+	}
+	if err == nil {
+		// All Routers
+		err = p.JoinGroup(ra.iface, &net.IPAddr{IP: net.ParseIP("ff02::2")})
+	}
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	ra.conn = conn
+	ra.quit = make(chan bool)
+	ra.wg.Add(2)
+	go ra.sendPeriodically()
+	go ra.handleSolicitations()
+	log.Info("DHCPv6: sending Router Advertisement messages on %s", ra.iface.Name)
+	return nil
+}
+
+// Stop sending Router Advertisement messages
+func (ra *raSender) stop() {
+	close(ra.quit)
+	_ = ra.conn.Close()
+	ra.wg.Wait()
+}
+
+// Send Router Advertisement message to all nodes
+func (ra *raSender) send() {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+	if time.Since(ra.lastSent) < raMinInterval {
+		return
+	}
+	ra.lastSent = time.Now()
+
+	cm := ipv6.ControlMessage{IfIndex: ra.iface.Index, HopLimit: 255}
+	dst := &net.IPAddr{IP: net.ParseIP("ff02::1"), Zone: ra.iface.Name}
+	_, err := ra.conn.IPv6PacketConn().WriteTo(ra.createPacket(), &cm, dst)
+	if err != nil {
+		log.Debug("DHCPv6: couldn't send Router Advertisement: %s", err)
+	}
+}
+
+func (ra *raSender) sendPeriodically() {
+	defer ra.wg.Done()
+	t := time.NewTicker(raInterval)
+	defer t.Stop()
+	for {
+		ra.send()
+		select {
+		case <-t.C:
+		case <-ra.quit:
+			return
+		}
+	}
+}
+
+func (ra *raSender) handleSolicitations() {
+	defer ra.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		n, cm, _, err := ra.conn.IPv6PacketConn().ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n == 0 || buf[0] != byte(ipv6.ICMPTypeRouterSolicitation) ||
+			(cm != nil && cm.IfIndex != ra.iface.Index) {
+			continue
+		}
+		ra.send()
+	}
+}
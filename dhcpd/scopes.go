@@ -0,0 +1,314 @@
+// DHCPv4 scopes: several subnets served by one instance
+
+package dhcpd
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/krolaw/dhcp4"
+)
+
+// ScopeConfig - the settings for an additional subnet
+type ScopeConfig struct {
+	// Network interface which is connected to the subnet (eth1, eth0.10 and so on)
+	// If empty, the subnet is behind a DHCP relay and the requests are matched by the relay agent address (giaddr)
+	InterfaceName string `json:"interface_name" yaml:"interface_name"`
+
+	// Addresses of the DHCP relay agents (giaddr) which forward the requests for a relayed subnet
+	// The relayed requests from other addresses are ignored.
+	RelayAgents []string `json:"relay_agents" yaml:"relay_agents"`
+
+	GatewayIP     string `json:"gateway_ip" yaml:"gateway_ip"`
+	SubnetMask    string `json:"subnet_mask" yaml:"subnet_mask"`
+	RangeStart    string `json:"range_start" yaml:"range_start"`
+	RangeEnd      string `json:"range_end" yaml:"range_end"`
+	LeaseDuration uint32 `json:"lease_duration" yaml:"lease_duration"` // in seconds.  0: the same as for the main subnet
+
+	// Additional DHCP options for this subnet
+	Options []DHCPOption `json:"options" yaml:"options"`
+}
+
+// dhcpScope - the parsed settings for a subnet
+type dhcpScope struct {
+	iface       *net.Interface // network interface;  nil for a relayed subnet
+	relayAgents []net.IP       // the allowed relay agent addresses for a relayed subnet
+
+	ipnet  *net.IPNet // our address which is sent to clients as the server identifier and DNS server
+	subnet *net.IPNet // the subnet which contains the address range
+
+	leaseStart   net.IP        // parsed from config RangeStart
+	leaseStop    net.IP        // parsed from config RangeEnd
+	leaseTime    time.Duration // parsed from config LeaseDuration
+	leaseOptions dhcp4.Options // parsed from config GatewayIP, SubnetMask and Options
+}
+
+// Parse the scope settings
+// ipnet: our address in this subnet
+func parseScope(conf ScopeConfig, ipnet *net.IPNet) (*dhcpScope, error) {
+	var err error
+	sc := dhcpScope{ipnet: ipnet}
+
+	if conf.LeaseDuration == 0 {
+		sc.leaseTime = time.Hour * 2
+	} else {
+		sc.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
+	}
+
+	sc.leaseStart, err = parseIPv4(conf.RangeStart)
+	if err != nil {
+		return nil, wrapErrPrint(err, "Failed to parse range start address %s", conf.RangeStart)
+	}
+
+	sc.leaseStop, err = parseIPv4(conf.RangeEnd)
+	if err != nil {
+		return nil, wrapErrPrint(err, "Failed to parse range end address %s", conf.RangeEnd)
+	}
+	if dhcp4.IPRange(sc.leaseStart, sc.leaseStop) <= 0 {
+		return nil, wrapErrPrint(err, "DHCP: Incorrect range_start/range_end values")
+	}
+
+	subnet, err := parseIPv4(conf.SubnetMask)
+	if err != nil || !isValidSubnetMask(subnet) {
+		return nil, wrapErrPrint(err, "Failed to parse subnet mask %s", conf.SubnetMask)
+	}
+	sc.subnet = &net.IPNet{
+		IP:   sc.leaseStart.Mask(net.IPMask(subnet)),
+		Mask: net.IPMask(subnet),
+	}
+
+	router, err := parseIPv4(conf.GatewayIP)
+	if err != nil {
+		return nil, wrapErrPrint(err, "Failed to parse gateway IP %s", conf.GatewayIP)
+	}
+
+	sc.leaseOptions = dhcp4.Options{
+		dhcp4.OptionSubnetMask:       subnet,
+		dhcp4.OptionRouter:           router,
+		dhcp4.OptionDomainNameServer: sc.ipnet.IP,
+	}
+
+	opts, err := parseOptions(conf.Options)
+	if err != nil {
+		return nil, wrapErrPrint(err, "Invalid DHCP options")
+	}
+	for code, data := range opts {
+		sc.leaseOptions[code] = data
+	}
+
+	return &sc, nil
+}
+
+// Parse the settings for additional subnets
+// main: the main scope
+func parseScopes(list []ScopeConfig, main *dhcpScope) ([]*dhcpScope, error) {
+	scopes := []*dhcpScope{}
+	for i, conf := range list {
+		var ipnet *net.IPNet
+		var iface *net.Interface
+		if len(conf.InterfaceName) != 0 {
+			var err error
+			iface, err = net.InterfaceByName(conf.InterfaceName)
+			if err != nil {
+				return nil, wrapErrPrint(err, "Scope #%d: couldn't find interface by name %s", i+1, conf.InterfaceName)
+			}
+			ipnet = getIfaceIPv4(iface)
+			if ipnet == nil {
+				return nil, wrapErrPrint(nil, "Scope #%d: couldn't find IPv4 address of interface %s", i+1, conf.InterfaceName)
+			}
+		} else {
+			// the relay agent forwards the requests to our main address
+			ipnet = &net.IPNet{IP: main.ipnet.IP}
+		}
+
+		sc, err := parseScope(conf, ipnet)
+		if err != nil {
+			return nil, wrapErrPrint(err, "Scope #%d", i+1)
+		}
+		sc.iface = iface
+		if iface == nil {
+			if len(conf.RelayAgents) == 0 {
+				return nil, wrapErrPrint(nil, "Scope #%d: relay_agents must be set for a relayed subnet", i+1)
+			}
+			for _, a := range conf.RelayAgents {
+				ip, err := parseIPv4(a)
+				if err != nil {
+					return nil, wrapErrPrint(err, "Scope #%d: invalid relay agent address %s", i+1, a)
+				}
+				sc.relayAgents = append(sc.relayAgents, ip)
+			}
+		}
+		if conf.LeaseDuration == 0 {
+			sc.leaseTime = main.leaseTime
+		}
+
+		if !sc.subnet.Contains(sc.leaseStop) {
+			return nil, wrapErrPrint(nil, "Scope #%d: range_end %s is not within the subnet %s",
+				i+1, conf.RangeEnd, sc.subnet)
+		}
+
+		for j, other := range append([]*dhcpScope{main}, scopes...) {
+			name := "the main scope"
+			if j != 0 {
+				name = "scope #" + strconv.Itoa(j)
+			}
+			if sc.iface != nil && other.iface != nil && sc.iface.Index == other.iface.Index {
+				return nil, wrapErrPrint(nil, "Scope #%d: interface %s is already used by %s",
+					i+1, conf.InterfaceName, name)
+			}
+			if sc.subnet.Contains(other.subnet.IP) || other.subnet.Contains(sc.subnet.IP) {
+				return nil, wrapErrPrint(nil, "Scope #%d: subnet %s overlaps with %s",
+					i+1, sc.subnet, name)
+			}
+		}
+
+		scopes = append(scopes, sc)
+	}
+	return scopes, nil
+}
+
+// Get all scopes: the main scope is the first
+func (s *Server) allScopes() []*dhcpScope {
+	return append([]*dhcpScope{&s.dhcpScope}, s.scopes...)
+}
+
+// Find the scope for the request
+// ifIndex: index of the network interface which has received the request;  0 if unknown
+// giaddr: relay agent address
+// ciaddr: client address
+// A relayed request is accepted only from the configured relay agents and only via the interfaces we serve:
+// the requests from other networks (e.g. WAN) can't make us send the replies to an arbitrary address.
+// A renewing client sends the request directly to us (without the relay agent),
+// so a request with the client address is matched by the subnet of this address.
+func (s *Server) findScope(ifIndex int, giaddr, ciaddr net.IP) *dhcpScope {
+	if giaddr != nil && !giaddr.Equal(net.IPv4zero) {
+		if ifIndex != 0 && !s.ifaceServed(ifIndex) {
+			return nil
+		}
+		for _, sc := range s.scopes {
+			for _, a := range sc.relayAgents {
+				if a.Equal(giaddr) {
+					return sc
+				}
+			}
+		}
+		return nil
+	}
+
+	if ciaddr != nil && !ciaddr.Equal(net.IPv4zero) {
+		if ifIndex != 0 && !s.ifaceServed(ifIndex) {
+			return nil
+		}
+		for _, sc := range s.allScopes() {
+			if sc.subnet != nil && sc.subnet.Contains(ciaddr) {
+				return sc
+			}
+		}
+	}
+
+	if ifIndex == 0 {
+		return &s.dhcpScope
+	}
+	for _, sc := range s.allScopes() {
+		if sc.iface != nil && sc.iface.Index == ifIndex {
+			return sc
+		}
+	}
+	return nil
+}
+
+// Return TRUE if the network interface is used by the main scope or by a directly connected scope
+func (s *Server) ifaceServed(ifIndex int) bool {
+	for _, sc := range s.allScopes() {
+		if sc.iface != nil && sc.iface.Index == ifIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the scope whose address range contains the IP address
+func (s *Server) findScopeByIP(ip net.IP) *dhcpScope {
+	for _, sc := range s.allScopes() {
+		if ipInRange(sc.leaseStart, sc.leaseStop, ip) {
+			return sc
+		}
+	}
+	return nil
+}
+
+// Return TRUE if the lease may be used in this scope:
+// a dynamic lease must be within the address range, a static lease - within the subnet
+func (sc *dhcpScope) leaseAllowed(lease *Lease) bool {
+	if lease.Expiry.Unix() == leaseExpireStatic {
+		return sc.subnet == nil || sc.subnet.Contains(lease.IP)
+	}
+	return ipInRange(sc.leaseStart, sc.leaseStop, lease.IP)
+}
+
+// Read DHCPv4 requests and send the replies
+// This is dhcp4.Serve() which selects the scope for each request:
+// . a relayed request (giaddr is set) - by the relay agent address, the reply is sent to the relay agent;
+// only the configured relay agents are allowed
+// . a request from a directly connected client - by the network interface
+func (s *Server) serve4(c *filterConn) error {
+	buffer := make([]byte, 1500)
+	for {
+		n, ifIndex, addr, err := c.readFrom(buffer)
+		if err != nil {
+			return err
+		}
+		if n < 240 { // Packet too small to be DHCP
+			continue
+		}
+		req := dhcp4.Packet(buffer[:n])
+		if req.HLen() > 16 { // Invalid size
+			continue
+		}
+		options := req.ParseOptions()
+		t := options[dhcp4.OptionDHCPMessageType]
+		if len(t) != 1 {
+			continue
+		}
+		reqType := dhcp4.MessageType(t[0])
+		if reqType < dhcp4.Discover || reqType > dhcp4.Inform {
+			continue
+		}
+
+		giaddr := req.GIAddr()
+		relayed := !giaddr.Equal(net.IPv4zero)
+		sc := s.findScope(ifIndex, giaddr, req.CIAddr())
+		if sc == nil {
+			log.Tracef("DHCP: no scope for the request from %s (interface #%d, relay agent %s, client address %s)",
+				req.CHAddr(), ifIndex, giaddr, req.CIAddr())
+			continue
+		}
+
+		res := s.serveDHCP(sc, req, reqType, options)
+		if res == nil {
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		dst := &net.UDPAddr{IP: udpAddr.IP, Port: udpAddr.Port}
+		if relayed {
+			dst = &net.UDPAddr{IP: giaddr, Port: 67}
+			ifIndex = 0 // the relay agent may be reachable via any interface
+		} else if sc.iface == nil {
+			ifIndex = 0 // a renewing client from a relayed subnet is reachable via the router
+		} else if udpAddr.IP.Equal(net.IPv4zero) || req.Broadcast() {
+			dst.IP = net.IPv4bcast
+		}
+
+		_, err = c.writeTo(res, ifIndex, dst)
+		if err != nil {
+			// don't stop the server: the socket error will be returned by readFrom()
+			log.Debug("DHCP: couldn't send the reply to %s: %s", dst, err)
+		}
+	}
+}
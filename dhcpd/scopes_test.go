@@ -0,0 +1,160 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServerScopes(t *testing.T) *Server {
+	s := &Server{}
+	s.conf.DBFilePath = dbFilename
+	s.reset()
+	main, err := parseScope(ScopeConfig{
+		GatewayIP:  "192.168.1.1",
+		SubnetMask: "255.255.255.0",
+		RangeStart: "192.168.1.100",
+		RangeEnd:   "192.168.1.101",
+	}, &net.IPNet{IP: net.IP{192, 168, 1, 2}, Mask: net.CIDRMask(24, 32)})
+	assert.Nil(t, err)
+	main.iface = &net.Interface{Index: 2, Name: "eth0"}
+	s.dhcpScope = *main
+
+	s.scopes, err = parseScopes([]ScopeConfig{{
+		GatewayIP:     "10.0.10.1",
+		SubnetMask:    "255.255.255.0",
+		RangeStart:    "10.0.10.100",
+		RangeEnd:      "10.0.10.200",
+		LeaseDuration: 60,
+		Options:       []DHCPOption{{Code: 6, Type: "ip", Value: "10.0.10.53"}},
+		RelayAgents:   []string{"10.0.10.1"},
+	}}, main)
+	assert.Nil(t, err)
+	return s
+}
+
+func newTestPacket(hw net.HardwareAddr, giaddr net.IP) dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootRequest)
+	p.SetCHAddr(hw)
+	p.SetGIAddr(giaddr)
+	return p
+}
+
+func TestScopesConfig(t *testing.T) {
+	s := newTestServerScopes(t)
+	main := &s.dhcpScope
+	assert.Equal(t, 1, len(s.scopes))
+	assert.Equal(t, time.Minute, s.scopes[0].leaseTime)
+	assert.Equal(t, "10.0.10.0/24", s.scopes[0].subnet.String())
+
+	// lease time of the main scope is used by default
+	scopes, err := parseScopes([]ScopeConfig{{
+		GatewayIP: "10.0.20.1", SubnetMask: "255.255.255.0", RangeStart: "10.0.20.10", RangeEnd: "10.0.20.20",
+		RelayAgents: []string{"10.0.20.1"},
+	}}, main)
+	assert.Nil(t, err)
+	assert.Equal(t, main.leaseTime, scopes[0].leaseTime)
+
+	// the relay agents are required for a relayed subnet
+	_, err = parseScopes([]ScopeConfig{{
+		GatewayIP: "10.0.20.1", SubnetMask: "255.255.255.0", RangeStart: "10.0.20.10", RangeEnd: "10.0.20.20",
+	}}, main)
+	assert.NotNil(t, err)
+	_, err = parseScopes([]ScopeConfig{{
+		GatewayIP: "10.0.20.1", SubnetMask: "255.255.255.0", RangeStart: "10.0.20.10", RangeEnd: "10.0.20.20",
+		RelayAgents: []string{"relay"},
+	}}, main)
+	assert.NotNil(t, err)
+
+	// the range is out of the subnet
+	_, err = parseScopes([]ScopeConfig{{
+		GatewayIP: "10.0.20.1", SubnetMask: "255.255.255.0", RangeStart: "10.0.20.10", RangeEnd: "10.0.21.20",
+	}}, main)
+	assert.NotNil(t, err)
+
+	// the subnets overlap
+	_, err = parseScopes([]ScopeConfig{{
+		GatewayIP: "192.168.1.1", SubnetMask: "255.255.0.0", RangeStart: "192.168.2.10", RangeEnd: "192.168.2.20",
+	}}, main)
+	assert.NotNil(t, err)
+}
+
+func TestFindScope(t *testing.T) {
+	s := newTestServerScopes(t)
+
+	assert.Equal(t, &s.dhcpScope, s.findScope(2, net.IPv4zero, net.IPv4zero))
+	assert.Equal(t, &s.dhcpScope, s.findScope(0, net.IPv4zero, net.IPv4zero))
+	assert.Nil(t, s.findScope(3, net.IPv4zero, net.IPv4zero))
+
+	// relayed requests: only from the configured relay agents via the interfaces we serve
+	assert.Equal(t, s.scopes[0], s.findScope(2, net.IP{10, 0, 10, 1}, net.IPv4zero))
+	assert.Equal(t, s.scopes[0], s.findScope(0, net.IP{10, 0, 10, 1}, net.IPv4zero))
+	assert.Nil(t, s.findScope(3, net.IP{10, 0, 10, 1}, net.IPv4zero))
+	assert.Nil(t, s.findScope(2, net.IP{10, 0, 10, 2}, net.IPv4zero))
+	assert.Nil(t, s.findScope(2, net.IP{192, 168, 1, 1}, net.IPv4zero))
+	assert.Nil(t, s.findScope(2, net.IP{10, 0, 11, 1}, net.IPv4zero))
+
+	// unicast requests from the clients with an address (renewal): by the client's subnet
+	assert.Equal(t, s.scopes[0], s.findScope(2, net.IPv4zero, net.IP{10, 0, 10, 150}))
+	assert.Equal(t, s.scopes[0], s.findScope(0, net.IPv4zero, net.IP{10, 0, 10, 150}))
+	assert.Equal(t, &s.dhcpScope, s.findScope(2, net.IPv4zero, net.IP{192, 168, 1, 100}))
+	assert.Equal(t, &s.dhcpScope, s.findScope(2, net.IPv4zero, net.IP{172, 16, 0, 1}))
+	assert.Nil(t, s.findScope(3, net.IPv4zero, net.IP{10, 0, 10, 150}))
+
+	assert.Equal(t, s.scopes[0], s.findScopeByIP(net.IP{10, 0, 10, 150}))
+	assert.Nil(t, s.findScopeByIP(net.IP{10, 0, 10, 50}))
+}
+
+func TestScopesLeases(t *testing.T) {
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	relayed := s.scopes[0]
+
+	// Discover via the relay agent
+	p := newTestPacket(hw, net.IP{10, 0, 10, 1})
+	opt := dhcp4.Options{}
+	resp := s.handleDiscover(relayed, p, opt)
+	assert.Equal(t, net.IP{10, 0, 10, 100}, resp.YIAddr())
+	assert.Equal(t, net.IP{10, 0, 10, 1}, resp.GIAddr())
+	ropt := resp.ParseOptions()
+	assert.Equal(t, []byte{192, 168, 1, 2}, ropt[dhcp4.OptionServerIdentifier])
+	assert.Equal(t, []byte{10, 0, 10, 1}, ropt[dhcp4.OptionRouter])
+	assert.Equal(t, []byte{10, 0, 10, 53}, ropt[dhcp4.OptionDomainNameServer])
+	assert.Equal(t, dhcp4.OptionsLeaseTime(time.Minute), ropt[dhcp4.OptionIPAddressLeaseTime])
+
+	opt[dhcp4.OptionRequestedIPAddress] = []byte{10, 0, 10, 100}
+	resp = s.handleDHCP4Request(relayed, p, opt)
+	ropt = resp.ParseOptions()
+	assert.Equal(t, []byte{byte(dhcp4.ACK)}, ropt[dhcp4.OptionDHCPMessageType])
+
+	// the client renews the lease: the request is sent directly to us, without the relay agent
+	renew := newTestPacket(hw, net.IPv4zero)
+	renew.SetCIAddr(net.IP{10, 0, 10, 100})
+	sc := s.findScope(2, renew.GIAddr(), renew.CIAddr())
+	assert.Equal(t, relayed, sc)
+	resp = s.handleDHCP4Request(sc, renew, dhcp4.Options{})
+	ropt = resp.ParseOptions()
+	assert.Equal(t, []byte{byte(dhcp4.ACK)}, ropt[dhcp4.OptionDHCPMessageType])
+
+	// the client has moved to the main subnet: the old lease can't be used
+	p = newTestPacket(hw, net.IPv4zero)
+	resp = s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	ropt = resp.ParseOptions()
+	assert.Equal(t, []byte{byte(dhcp4.NAK)}, ropt[dhcp4.OptionDHCPMessageType])
+
+	resp = s.handleDiscover(&s.dhcpScope, p, dhcp4.Options{})
+	assert.Equal(t, net.IP{192, 168, 1, 100}, resp.YIAddr())
+	assert.Equal(t, 1, len(s.leases))
+
+	// a static lease from another subnet is ignored
+	hw2 := net.HardwareAddr{2, 2, 3, 4, 5, 6}
+	assert.Nil(t, s.AddStaticLease(Lease{HWAddr: hw2, IP: net.IP{10, 0, 10, 5}}))
+	assert.Nil(t, s.handleDiscover(&s.dhcpScope, newTestPacket(hw2, net.IPv4zero), dhcp4.Options{}))
+	resp = s.handleDiscover(relayed, newTestPacket(hw2, net.IP{10, 0, 10, 1}), dhcp4.Options{})
+	assert.Equal(t, net.IP{10, 0, 10, 5}, resp.YIAddr())
+}
@@ -0,0 +1,196 @@
+// Static leases which match the clients by client identifier or host name, per-lease overrides
+
+package dhcpd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/krolaw/dhcp4"
+)
+
+// Return TRUE if the static lease matches the client by client identifier or host name
+// While a lease matched by host name is bound to a client (see bindStaticLease()),
+// it matches only this client: one IP address can't be given to all clients with the matching names.
+func (l *Lease) matchClient(clientID []byte, hostname string, hwaddr net.HardwareAddr) bool {
+	if len(l.ClientID) != 0 && bytes.Equal(l.ClientID, clientID) {
+		return true
+	}
+	if len(l.HostnamePattern) == 0 || len(l.HWAddr) != 0 {
+		return false
+	}
+	if l.bound() {
+		return bytes.Equal(l.BoundHWAddr, hwaddr)
+	}
+	if len(hostname) != 0 {
+		ok, _ := path.Match(strings.ToLower(l.HostnamePattern), strings.ToLower(hostname))
+		return ok
+	}
+	return false
+}
+
+// Return TRUE if the static lease is bound to a client
+func (l *Lease) bound() bool {
+	return len(l.BoundHWAddr) != 0 && l.BoundExpiry.After(time.Now())
+}
+
+// Find a static lease for the client which doesn't have a static lease with its MAC address
+// The leases matched by client identifier have a priority over the leases matched by host name.
+func (s *Server) findStaticLeaseByClient(p dhcp4.Packet) *Lease {
+	options := p.ParseOptions()
+	clientID := options[dhcp4.OptionClientIdentifier]
+	hostname := string(options[dhcp4.OptionHostName])
+
+	var byHost *Lease
+	for _, l := range s.leases {
+		if l.Expiry.Unix() != leaseExpireStatic || !l.matchClient(clientID, hostname, p.CHAddr()) {
+			continue
+		}
+		if len(l.ClientID) != 0 && bytes.Equal(l.ClientID, clientID) {
+			return l
+		}
+		if byHost == nil {
+			byHost = l
+		}
+	}
+	return byHost
+}
+
+// Bind the static lease matched by host name pattern to the client's MAC address for the lease time
+// The other clients with the matching names don't get this lease while the client renews it.
+// If the client doesn't renew the lease in time, the lease may be bound to another matching client.
+// leaseTime: the lease time sent to the client
+func (s *Server) bindStaticLease(lease *Lease, p dhcp4.Packet, leaseTime time.Duration) {
+	clientID := p.ParseOptions()[dhcp4.OptionClientIdentifier]
+	if len(lease.HWAddr) != 0 || len(lease.HostnamePattern) == 0 ||
+		(len(lease.ClientID) != 0 && bytes.Equal(lease.ClientID, clientID)) {
+		return
+	}
+
+	hwaddr := make(net.HardwareAddr, len(p.CHAddr()))
+	copy(hwaddr, p.CHAddr())
+
+	s.leasesLock.Lock()
+	changed := !bytes.Equal(lease.BoundHWAddr, hwaddr)
+	if changed {
+		err := s.rmDynamicLeaseWithMAC(hwaddr)
+		if err != nil {
+			s.leasesLock.Unlock()
+			log.Debug("DHCP: can't bind static lease %s to %s: %s", lease.IP, hwaddr, err)
+			return
+		}
+	}
+	lease.BoundHWAddr = hwaddr
+	lease.BoundExpiry = time.Now().Add(leaseTime)
+	s.dbStore()
+	s.leasesLock.Unlock()
+
+	if changed {
+		log.Info("DHCP: static lease %s for %q is bound to %s", lease.IP, lease.HostnamePattern, hwaddr)
+		s.notify(LeaseChangedAddedStatic)
+	}
+}
+
+// Add the event for the static lease confirmed by ACK to the history,
+// so the device has the first-seen and last-seen times too
+// hwaddr: the client's MAC address (the lease matched by client ID or host name may not have it)
+func (s *Server) staticLeaseEvent(lease *Lease, hwaddr net.HardwareAddr) {
+	s.leasesLock.RLock()
+	l := *lease
+	s.leasesLock.RUnlock()
+	l.HWAddr = hwaddr
+	s.leaseEvent(commitEvent(&l), &l)
+}
+
+// Check the fields of a new static lease
+func (s *Server) checkStaticLease(l Lease) error {
+	if len(l.IP) != 4 {
+		return fmt.Errorf("invalid IP")
+	}
+	if len(l.HWAddr) == 0 && len(l.ClientID) == 0 && len(l.HostnamePattern) == 0 {
+		return fmt.Errorf("MAC, client ID or hostname pattern is required")
+	}
+	if len(l.HWAddr) != 0 && len(l.HWAddr) != 6 {
+		return fmt.Errorf("invalid MAC")
+	}
+	if len(l.ClientID) > 255 {
+		return fmt.Errorf("client ID is too long")
+	}
+	if len(l.HostnamePattern) != 0 {
+		_, err := path.Match(l.HostnamePattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid hostname pattern: %s", err)
+		}
+	}
+	if l.Gateway != nil && l.Gateway.To4() == nil {
+		return fmt.Errorf("invalid gateway")
+	}
+	for _, ip := range l.DNS {
+		if ip.To4() == nil {
+			return fmt.Errorf("invalid DNS server %s", ip)
+		}
+	}
+	_, err := parseOptions(l.Options)
+	if err != nil {
+		return err
+	}
+
+	for _, lease := range s.leases {
+		if lease.Expiry.Unix() != leaseExpireStatic {
+			continue
+		}
+		if len(l.ClientID) != 0 && bytes.Equal(lease.ClientID, l.ClientID) {
+			return fmt.Errorf("static lease with the same client ID already exists")
+		}
+		if len(l.HostnamePattern) != 0 && lease.HostnamePattern == l.HostnamePattern {
+			return fmt.Errorf("static lease with the same hostname pattern already exists")
+		}
+	}
+	return nil
+}
+
+// Get the lease time for the lease: the scope's lease time or the lease's own duration
+func (sc *dhcpScope) leaseDuration(lease *Lease) time.Duration {
+	if lease.LeaseDuration != 0 {
+		return time.Duration(lease.LeaseDuration) * time.Second
+	}
+	return sc.leaseTime
+}
+
+// Get the options for the gateway and DNS servers overrides
+func leaseOverrides(lease *Lease) dhcp4.Options {
+	opts := dhcp4.Options{}
+	if lease.Gateway != nil {
+		opts[dhcp4.OptionRouter] = lease.Gateway.To4()
+	}
+	if len(lease.DNS) != 0 {
+		data := []byte{}
+		for _, ip := range lease.DNS {
+			data = append(data, ip.To4()...)
+		}
+		opts[dhcp4.OptionDomainNameServer] = data
+	}
+	return opts
+}
+
+// Parse client identifier in hex format, e.g. "01:aa:bb:cc:dd:ee:ff" or "01aabbccddeeff"
+func parseClientID(s string) ([]byte, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	data, err := DHCPOption{Type: "hex", Value: s}.data()
+	if err != nil {
+		return nil, fmt.Errorf("invalid client ID: %s", err)
+	}
+	return data, nil
+}
+
+// Format client identifier as colon-separated hex bytes
+func formatClientID(id []byte) string {
+	return net.HardwareAddr(id).String()
+}
@@ -0,0 +1,173 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticLeaseMatch(t *testing.T) {
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+
+	// the MAC address, client ID or hostname pattern is required
+	assert.NotNil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 10}}))
+	assert.NotNil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 10}, HostnamePattern: "iot-["}))
+
+	clientID := []byte{1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	assert.Nil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 10}, ClientID: clientID}))
+	assert.Nil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 11}, HostnamePattern: "iot-*"}))
+	assert.Nil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 12}, HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}}))
+	assert.NotNil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 13}, ClientID: clientID}))
+	assert.NotNil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 11}, HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 7}}))
+
+	// match by client ID: the client has a random MAC address
+	p := newTestPacket(net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x01}, net.IPv4zero)
+	p.AddOption(dhcp4.OptionClientIdentifier, clientID)
+	p.AddOption(dhcp4.OptionHostName, []byte("iot-sensor"))
+	lease := s.findLease(p)
+	assert.NotNil(t, lease)
+	assert.Equal(t, "192.168.1.10", lease.IP.String())
+
+	// match by hostname pattern
+	p = newTestPacket(net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x02}, net.IPv4zero)
+	p.AddOption(dhcp4.OptionHostName, []byte("IoT-Lamp"))
+	lease = s.findLease(p)
+	assert.NotNil(t, lease)
+	assert.Equal(t, "192.168.1.11", lease.IP.String())
+
+	// the lease is bound to the client which has got it for the lease time
+	opt := dhcp4.Options{dhcp4.OptionRequestedIPAddress: []byte{192, 168, 1, 11}}
+	reply := s.handleDHCP4Request(&s.dhcpScope, p, opt)
+	assert.Equal(t, dhcp4.ACK, dhcp4.MessageType(reply.ParseOptions()[dhcp4.OptionDHCPMessageType][0]))
+	assert.Nil(t, lease.HWAddr)
+	assert.Equal(t, net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x02}, lease.BoundHWAddr)
+	assert.True(t, lease.BoundExpiry.After(time.Now().Add(s.dhcpScope.leaseTime-time.Minute)))
+	assert.Equal(t, lease, s.findLease(p))
+
+	// the bound client renews the lease without the host name
+	renew := newTestPacket(net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x02}, net.IPv4zero)
+	assert.Equal(t, lease, s.findLease(renew))
+
+	// another client with the matching name doesn't get the same address
+	p2 := newTestPacket(net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x04}, net.IPv4zero)
+	p2.AddOption(dhcp4.OptionHostName, []byte("iot-sensor2"))
+	assert.Nil(t, s.findLease(p2))
+	reply = s.handleDHCP4Request(&s.dhcpScope, p2, opt)
+	assert.Equal(t, dhcp4.NAK, dhcp4.MessageType(reply.ParseOptions()[dhcp4.OptionDHCPMessageType][0]))
+
+	// the client hasn't renewed the lease in time: the lease is bound to another client
+	lease.BoundExpiry = time.Now().Add(-time.Second)
+	assert.Nil(t, s.findLease(renew))
+	assert.Equal(t, lease, s.findLease(p2))
+	reply = s.handleDHCP4Request(&s.dhcpScope, p2, opt)
+	assert.Equal(t, dhcp4.ACK, dhcp4.MessageType(reply.ParseOptions()[dhcp4.OptionDHCPMessageType][0]))
+	assert.Equal(t, net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x04}, lease.BoundHWAddr)
+	assert.Nil(t, s.findLease(p))
+
+	// the static lease with the client's MAC address has a priority
+	p = newTestPacket(net.HardwareAddr{1, 2, 3, 4, 5, 6}, net.IPv4zero)
+	p.AddOption(dhcp4.OptionClientIdentifier, clientID)
+	lease = s.findLease(p)
+	assert.Equal(t, "192.168.1.12", lease.IP.String())
+
+	p = newTestPacket(net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x03}, net.IPv4zero)
+	p.AddOption(dhcp4.OptionHostName, []byte("laptop"))
+	assert.Nil(t, s.findLease(p))
+
+	// the leases are stored in DB;  a lease without MAC address may be removed
+	s2 := newTestServerScopes(t)
+	s2.dbLoad()
+	assert.Equal(t, 3, len(s2.Leases(LeasesStatic)))
+	assert.Equal(t, net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x90, 0x04}, s2.findLease(p2).BoundHWAddr)
+	assert.True(t, s2.ipReserved(net.IP{192, 168, 1, 11}))
+	assert.True(t, s2.ipReserved(net.IP{192, 168, 1, 10}))
+	assert.Nil(t, s2.RemoveStaticLease(Lease{IP: net.IP{192, 168, 1, 10}, ClientID: clientID}))
+	assert.Equal(t, 2, len(s2.Leases(LeasesStatic)))
+}
+
+func TestStaticLeaseWithoutMAC(t *testing.T) {
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+
+	// the address of a static lease without MAC address isn't given to another client
+	assert.Nil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 100}, ClientID: []byte{1, 2, 3}}))
+	assert.True(t, s.ipReserved(net.IP{192, 168, 1, 100}))
+	ip, err := s.findFreeIP(&s.dhcpScope, net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.1.101", ip.String())
+	_, err = s.findFreeIP(&s.dhcpScope, net.HardwareAddr{1, 2, 3, 4, 5, 7})
+	assert.NotNil(t, err)
+
+	// the same after loading from DB
+	s2 := newTestServerScopes(t)
+	s2.dbLoad()
+	ip, err = s2.findFreeIP(&s2.dhcpScope, net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.1.101", ip.String())
+}
+
+func TestStaticLeaseOverrides(t *testing.T) {
+	s := newTestServerScopes(t)
+	defer func() { _ = os.Remove(dbFilename) }()
+
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	assert.NotNil(t, s.AddStaticLease(Lease{IP: net.IP{192, 168, 1, 10}, HWAddr: hw, DNS: []net.IP{net.ParseIP("::1")}}))
+	assert.Nil(t, s.AddStaticLease(Lease{
+		IP:            net.IP{192, 168, 1, 10},
+		HWAddr:        hw,
+		LeaseDuration: 3600,
+		Gateway:       net.IP{192, 168, 1, 254},
+		DNS:           []net.IP{{192, 168, 1, 53}, {192, 168, 1, 54}},
+	}))
+
+	p := newTestPacket(hw, net.IPv4zero)
+	reply := s.handleDiscover(&s.dhcpScope, p, dhcp4.Options{})
+	assert.NotNil(t, reply)
+	assert.Equal(t, "192.168.1.10", reply.YIAddr().String())
+	opts := reply.ParseOptions()
+	assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, opts[dhcp4.OptionIPAddressLeaseTime])
+	assert.Equal(t, []byte{192, 168, 1, 254}, opts[dhcp4.OptionRouter])
+	assert.Equal(t, []byte{192, 168, 1, 53, 192, 168, 1, 54}, opts[dhcp4.OptionDomainNameServer])
+
+	sc := &s.dhcpScope
+	assert.Equal(t, time.Hour, sc.leaseDuration(&Lease{LeaseDuration: 3600}))
+	assert.Equal(t, sc.leaseTime, sc.leaseDuration(&Lease{}))
+
+	// the lease's options have a priority over the gateway and DNS servers
+	lease := &Lease{
+		DNS:     []net.IP{{192, 168, 1, 53}},
+		Options: []DHCPOption{{Code: 6, Type: "ip", Value: "192.168.1.55"}},
+	}
+	assert.Equal(t, []byte{192, 168, 1, 55}, s.getLeaseOptions(sc, lease)[dhcp4.OptionDomainNameServer])
+}
+
+func TestStaticLeaseJSON(t *testing.T) {
+	lj := staticLeaseJSON{
+		IP:              "192.168.1.10",
+		ClientID:        "01:aa:bb:cc:dd:ee:ff",
+		HostnamePattern: "iot-*",
+		LeaseDuration:   3600,
+		Gateway:         "192.168.1.254",
+		DNS:             []string{"192.168.1.53"},
+	}
+	lease, err := lj.toLease()
+	assert.Nil(t, err)
+	assert.Nil(t, lease.HWAddr)
+	assert.Equal(t, []byte{1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, lease.ClientID)
+	assert.Equal(t, "01:aa:bb:cc:dd:ee:ff", formatClientID(lease.ClientID))
+	assert.Equal(t, "192.168.1.254", lease.Gateway.String())
+	assert.Equal(t, 1, len(lease.DNS))
+
+	lj.ClientID = "01:zz"
+	_, err = lj.toLease()
+	assert.NotNil(t, err)
+	lj.ClientID = ""
+	lj.DNS = []string{"::1"}
+	_, err = lj.toLease()
+	assert.NotNil(t, err)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/joomcode/errorx"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnstap"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 )
@@ -30,6 +31,10 @@ type FilteringConfig struct {
 	// based on the client IP address. Returns nil if there are no custom upstreams for the client
 	GetCustomUpstreamByClient func(clientAddr string) *proxy.UpstreamConfig `yaml:"-"`
 
+	// GetClientLogSettings - a callback function that returns whether the client's requests
+	// must be excluded from the query log and from statistics
+	GetClientLogSettings func(clientAddr string) (ignoreQueryLog, ignoreStats bool) `yaml:"-"`
+
 	// Protection configuration
 	// --
 
@@ -81,6 +86,12 @@ type FilteringConfig struct {
 	AAAADisabled           bool     `yaml:"aaaa_disabled"`      // Respond with an empty answer to all AAAA requests
 	EnableDNSSEC           bool     `yaml:"enable_dnssec"`      // Set DNSSEC flag in outcoming DNS request
 	EnableEDNSClientSubnet bool     `yaml:"edns_client_subnet"` // Enable EDNS Client Subnet option
+
+	// Domain name for the host names of DHCP clients, e.g. "lan" -> "myhost.lan"
+	// If empty, the DHCP clients are not resolved by their host names
+	LocalDomainName string `yaml:"local_domain_name"`
+
+	Dnstap dnstap.Config `yaml:"dnstap"` // dnstap output settings
 }
 
 // TLSConfig is the TLS configuration for HTTPS, DNS-over-HTTPS, and DNS-over-TLS
@@ -213,6 +224,9 @@ func (s *Server) prepareUpstreamSettings() error {
 		return fmt.Errorf("DNS: proxy.ParseUpstreamsConfig: %s", err)
 	}
 	s.conf.UpstreamConfig = &upstreamConfig
+	if s.upstreamWrapper != nil {
+		s.conf.UpstreamConfig = s.upstreamWrapper.wrap(s.conf.UpstreamConfig)
+	}
 	return nil
 }
 
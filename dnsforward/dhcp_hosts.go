@@ -0,0 +1,210 @@
+// Resolve the host names of DHCP clients
+
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// hostRecord - the address leased to a DHCP client
+type hostRecord struct {
+	ip     net.IP
+	hwaddr string
+	expiry time.Time // zero for static leases
+}
+
+func (r hostRecord) isStatic() bool {
+	return r.expiry.IsZero()
+}
+
+// Convert the host name of a DHCP client to a valid DNS label:
+// "My_Phone.home" -> "my-phone"
+// Returns "" if the host name can't be used
+func hostnameToLabel(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	i := strings.IndexByte(host, '.')
+	if i >= 0 {
+		host = host[:i] // the client has sent FQDN
+	}
+
+	b := []byte(host)
+	for i, c := range b {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-') {
+			b[i] = '-'
+		}
+	}
+	label := strings.Trim(string(b), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// CheckLocalDomainName - normalize and check the local domain name, e.g. "Home.Arpa." -> "home.arpa"
+// Empty name is valid: the feature is disabled.
+func CheckLocalDomainName(name string) (string, error) {
+	name = strings.Trim(strings.ToLower(name), ".")
+	if len(name) == 0 {
+		return "", nil
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("domain name is too long")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || hostnameToLabel(label) != label {
+			return "", fmt.Errorf("invalid domain name label: %q", label)
+		}
+	}
+	return name, nil
+}
+
+// Get the host name from the request for "<host>.<local domain name>"
+// Returns "" if the request is for another domain
+func localHostLabel(qname, domain string) string {
+	domain = strings.Trim(strings.ToLower(domain), ".")
+	if len(domain) == 0 {
+		return ""
+	}
+	qname = strings.TrimSuffix(strings.ToLower(qname), ".")
+	if !strings.HasSuffix(qname, "."+domain) {
+		return ""
+	}
+	host := strings.TrimSuffix(qname, "."+domain)
+	if strings.IndexByte(host, '.') >= 0 {
+		return ""
+	}
+	return host
+}
+
+// Fill "hostname -> addresses" table from DHCP leases
+func (s *Server) updateHostsTable() {
+	m := make(map[string][]hostRecord)
+	add := func(l dhcpd.Lease, expiry time.Time) {
+		host := hostnameToLabel(l.Hostname)
+		if len(host) == 0 {
+			return
+		}
+		r := hostRecord{ip: l.IP, hwaddr: l.HWAddr.String(), expiry: expiry}
+		m[host] = append(m[host], r)
+	}
+	for _, l := range s.dhcpServer.Leases(dhcpd.LeasesStatic) {
+		add(l, time.Time{})
+	}
+	for _, l := range s.dhcpServer.Leases(dhcpd.LeasesDynamic) {
+		add(l, l.Expiry)
+	}
+
+	for host, records := range m {
+		for _, r := range records[1:] {
+			if r.hwaddr != records[0].hwaddr {
+				log.Debug("DNS: host name %s is used by several DHCP clients", host)
+				break
+			}
+		}
+	}
+
+	log.Debug("DNS: added %d host names from DHCP", len(m))
+	s.tableHostsLock.Lock()
+	s.tableHosts = m
+	s.tableHostsLock.Unlock()
+}
+
+// Get the active records of the client who owns the host name.
+// If several clients have the same host name, the client with a static lease wins;
+// otherwise, the client whose lease expires last (i.e. renewed most recently) wins.
+// Expired leases are skipped.
+func resolveHostRecords(records []hostRecord, now time.Time) []hostRecord {
+	active := []hostRecord{}
+	owner := -1
+	for _, r := range records {
+		if !r.isStatic() && !r.expiry.After(now) {
+			continue
+		}
+		active = append(active, r)
+
+		i := len(active) - 1
+		if owner == -1 {
+			owner = i
+			continue
+		}
+		o := active[owner]
+		if o.isStatic() {
+			continue
+		}
+		if r.isStatic() || r.expiry.After(o.expiry) {
+			owner = i
+		}
+	}
+	if owner == -1 {
+		return nil
+	}
+
+	result := []hostRecord{}
+	for _, r := range active {
+		if r.hwaddr == active[owner].hwaddr {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// Respond to A/AAAA requests for "<host>.<local domain name>" if the host name belongs to a DHCP client
+// If the client has no address of the requested type, the response is empty
+func processInternalHosts(ctx *dnsContext) int {
+	s := ctx.srv
+	req := ctx.proxyCtx.Req
+	q := req.Question[0]
+	if ctx.proxyCtx.Res != nil ||
+		(q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return resultDone
+	}
+
+	host := localHostLabel(q.Name, s.conf.LocalDomainName)
+	if len(host) == 0 {
+		return resultDone
+	}
+
+	s.tableHostsLock.Lock()
+	records := s.tableHosts[host]
+	s.tableHostsLock.Unlock()
+	now := time.Now()
+	records = resolveHostRecords(records, now)
+	if len(records) == 0 {
+		return resultDone
+	}
+
+	log.Debug("DNS: lookup of DHCP client: %s -> %d addresses", host, len(records))
+
+	resp := s.makeResponse(req)
+	for _, r := range records {
+		ttl := s.conf.BlockedResponseTTL
+		if !r.isStatic() {
+			left := uint32(r.expiry.Sub(now) / time.Second)
+			if left < ttl {
+				ttl = left
+			}
+		}
+		hdr := dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: q.Qtype,
+			Ttl:    ttl,
+			Class:  dns.ClassINET,
+		}
+
+		ip4 := r.ip.To4()
+		if q.Qtype == dns.TypeA && ip4 != nil {
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: ip4})
+		} else if q.Qtype == dns.TypeAAAA && ip4 == nil {
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: r.ip})
+		}
+	}
+	ctx.proxyCtx.Res = resp
+	return resultDone
+}
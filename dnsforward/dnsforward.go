@@ -10,6 +10,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnstap"
 	"github.com/AdguardTeam/AdGuardHome/querylog"
 	"github.com/AdguardTeam/AdGuardHome/stats"
 	"github.com/AdguardTeam/dnsproxy/proxy"
@@ -51,9 +52,15 @@ type Server struct {
 	stats      stats.Stats
 	access     *accessCtx
 
+	upstreamWrapper *upstreamWrapper // counts requests to upstream servers in statistics (optional)
+	dnstap          *dnstap.Sink     // dnstap output (optional)
+
 	tablePTR     map[string]string // "IP -> hostname" table for reverse lookup
 	tablePTRLock sync.Mutex
 
+	tableHosts     map[string][]hostRecord // "hostname -> addresses" table for the DHCP clients
+	tableHostsLock sync.Mutex
+
 	// DNS proxy instance for internal usage
 	// We don't Start() it and so no listen port is required.
 	internalProxy *proxy.Proxy
@@ -81,6 +88,10 @@ func NewServer(p DNSCreateParams) *Server {
 	s.queryLog = p.QueryLog
 	s.dhcpServer = p.DHCPServer
 
+	if s.stats != nil {
+		s.upstreamWrapper = &upstreamWrapper{stats: s.stats}
+	}
+
 	if s.dhcpServer != nil {
 		s.dhcpServer.SetOnLeaseChanged(s.onDHCPLeaseChanged)
 		s.onDHCPLeaseChanged(dhcpd.LeaseChangedAdded)
@@ -100,6 +111,10 @@ func (s *Server) Close() {
 	s.stats = nil
 	s.queryLog = nil
 	s.dnsProxy = nil
+	if s.dnstap != nil {
+		s.dnstap.Close()
+		s.dnstap = nil
+	}
 	s.Unlock()
 }
 
@@ -209,14 +224,21 @@ func (s *Server) Prepare(config *ServerConfig) error {
 		return err
 	}
 
-	// 6. Register web handlers if necessary
+	// 6. Initialize dnstap output
+	// --
+	err = s.initDnstap()
+	if err != nil {
+		return err
+	}
+
+	// 7. Register web handlers if necessary
 	// --
 	if !webRegistered && s.conf.HTTPRegister != nil {
 		webRegistered = true
 		s.registerHandlers()
 	}
 
-	// 7. Create the main DNS proxy instance
+	// 8. Create the main DNS proxy instance
 	// --
 	s.dnsProxy = &proxy.Proxy{Config: proxyConfig}
 	return nil
@@ -34,6 +34,7 @@ type dnsConfigJSON struct {
 	DNSSECEnabled     bool   `json:"dnssec_enabled"`
 	DisableIPv6       bool   `json:"disable_ipv6"`
 	UpstreamMode      string `json:"upstream_mode"`
+	LocalDomainName   string `json:"local_domain_name"`
 }
 
 func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
@@ -50,6 +51,7 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	resp.EDNSCSEnabled = s.conf.EnableEDNSClientSubnet
 	resp.DNSSECEnabled = s.conf.EnableDNSSEC
 	resp.DisableIPv6 = s.conf.AAAADisabled
+	resp.LocalDomainName = s.conf.LocalDomainName
 	if s.conf.FastestAddr {
 		resp.UpstreamMode = "fastest_addr"
 	} else if s.conf.AllServers {
@@ -126,6 +128,14 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if js.Exists("local_domain_name") {
+		req.LocalDomainName, err = CheckLocalDomainName(req.LocalDomainName)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "local_domain_name: %s", err)
+			return
+		}
+	}
+
 	restart := false
 	s.Lock()
 
@@ -177,6 +187,10 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		s.conf.AAAADisabled = req.DisableIPv6
 	}
 
+	if js.Exists("local_domain_name") {
+		s.conf.LocalDomainName = req.LocalDomainName
+	}
+
 	if js.Exists("upstream_mode") {
 		s.conf.FastestAddr = false
 		s.conf.AllServers = false
@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"fmt"
 	"math/big"
 	"net"
 	"sort"
@@ -17,8 +18,12 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/dnstap"
+	"github.com/AdguardTeam/AdGuardHome/querylog"
+	"github.com/AdguardTeam/AdGuardHome/stats"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/joomcode/errorx"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
@@ -1049,3 +1054,261 @@ func TestPTRResponse(t *testing.T) {
 
 	s.Close()
 }
+
+func TestLocalHosts(t *testing.T) {
+	dhcp := &dhcpd.Server{}
+	dhcp.IPpool = make(map[[4]byte]net.HardwareAddr)
+
+	c := dnsfilter.Config{}
+	f := dnsfilter.New(&c, nil)
+	s := NewServer(DNSCreateParams{DNSFilter: f, DHCPServer: dhcp})
+	s.conf.UDPListenAddr = &net.UDPAddr{Port: 0}
+	s.conf.TCPListenAddr = &net.TCPAddr{Port: 0}
+	s.conf.UpstreamDNS = []string{"127.0.0.1:53"}
+	s.conf.FilteringConfig.ProtectionEnabled = true
+	s.conf.LocalDomainName = "lan"
+	err := s.Prepare(nil)
+	assert.True(t, err == nil)
+	assert.Nil(t, s.Start())
+
+	l := dhcpd.Lease{}
+	l.IP = net.ParseIP("127.0.0.1").To4()
+	l.HWAddr, _ = net.ParseMAC("aa:aa:aa:aa:aa:aa")
+	l.Hostname = "My_Host"
+	_ = dhcp.AddStaticLease(l)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+	req := createTestMessageWithType("my-host.lan.", dns.TypeA)
+	resp, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Answer))
+	a, ok := resp.Answer[0].(*dns.A)
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1", a.A.String())
+
+	// no IPv6 address: empty response
+	req = createTestMessageWithType("MY-HOST.lan.", dns.TypeAAAA)
+	resp, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Equal(t, 0, len(resp.Answer))
+
+	// PTR response contains the local domain name
+	req = createTestMessageWithType("1.0.0.127.in-addr.arpa.", dns.TypePTR)
+	resp, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Answer))
+	assert.Equal(t, "my-host.lan.", resp.Answer[0].(*dns.PTR).Ptr)
+
+	s.Close()
+}
+
+func TestResolveHostRecords(t *testing.T) {
+	now := time.Now()
+	ip1 := net.ParseIP("192.168.0.1")
+	ip2 := net.ParseIP("192.168.0.2")
+	ip6 := net.ParseIP("2001::2")
+
+	// the most recently renewed lease wins
+	records := []hostRecord{
+		{ip: ip1, hwaddr: "1", expiry: now.Add(time.Hour)},
+		{ip: ip2, hwaddr: "2", expiry: now.Add(2 * time.Hour)},
+		{ip: ip6, hwaddr: "2", expiry: now.Add(time.Hour)},
+	}
+	r := resolveHostRecords(records, now)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, ip2, r[0].ip)
+	assert.Equal(t, ip6, r[1].ip)
+
+	// the static lease wins
+	records[0].expiry = time.Time{}
+	r = resolveHostRecords(records, now)
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, ip1, r[0].ip)
+
+	// expired leases are skipped
+	records = []hostRecord{
+		{ip: ip1, hwaddr: "1", expiry: now.Add(time.Hour)},
+		{ip: ip2, hwaddr: "2", expiry: now.Add(-time.Second)},
+	}
+	r = resolveHostRecords(records, now)
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, ip1, r[0].ip)
+	assert.Nil(t, resolveHostRecords(records[1:], now))
+}
+
+func TestLocalHostNames(t *testing.T) {
+	assert.Equal(t, "my-phone", hostnameToLabel(" My_Phone.home "))
+	assert.Equal(t, "", hostnameToLabel("__"))
+	assert.Equal(t, "host", localHostLabel("Host.LAN.", "lan"))
+	assert.Equal(t, "", localHostLabel("a.host.lan.", "lan"))
+	assert.Equal(t, "", localHostLabel("host.lan.", ""))
+	name, err := CheckLocalDomainName("Home.Arpa.")
+	assert.Nil(t, err)
+	assert.Equal(t, "home.arpa", name)
+	name, err = CheckLocalDomainName("")
+	assert.Nil(t, err)
+	assert.Equal(t, "", name)
+	_, err = CheckLocalDomainName("home..arpa")
+	assert.NotNil(t, err)
+	_, err = CheckLocalDomainName("-home")
+	assert.NotNil(t, err)
+}
+
+func TestDnstapUpstreamAddr(t *testing.T) {
+	ip, port, proto := dnstapUpstreamAddr("8.8.8.8:53")
+	assert.Equal(t, "8.8.8.8", ip.String())
+	assert.Equal(t, uint16(53), port)
+	assert.Equal(t, dnstap.ProtocolUDP, proto)
+
+	ip, port, proto = dnstapUpstreamAddr("tls://1.1.1.1")
+	assert.Equal(t, "1.1.1.1", ip.String())
+	assert.Equal(t, uint16(853), port)
+	assert.Equal(t, dnstap.ProtocolDOT, proto)
+
+	ip, port, proto = dnstapUpstreamAddr("tcp://[2001:db8::1]:5353")
+	assert.Equal(t, "2001:db8::1", ip.String())
+	assert.Equal(t, uint16(5353), port)
+	assert.Equal(t, dnstap.ProtocolTCP, proto)
+
+	ip, port, proto = dnstapUpstreamAddr("https://dns.google/dns-query")
+	assert.Nil(t, ip)
+	assert.Equal(t, uint16(443), port)
+	assert.Equal(t, dnstap.ProtocolDOH, proto)
+}
+
+// testStats is a mock of statistics module that remembers the entries
+type testStats struct {
+	sync.Mutex
+	entries   []stats.Entry
+	upstreams []stats.UpstreamEntry
+}
+
+func (s *testStats) Start()                               {}
+func (s *testStats) Close()                               {}
+func (s *testStats) GetTopClientsIP(limit uint) []string  { return nil }
+func (s *testStats) WriteDiskConfig(dc *stats.DiskConfig) {}
+
+func (s *testStats) Update(e stats.Entry) {
+	s.Lock()
+	s.entries = append(s.entries, e)
+	s.Unlock()
+}
+
+func (s *testStats) UpdateUpstream(e stats.UpstreamEntry) {
+	s.Lock()
+	s.upstreams = append(s.upstreams, e)
+	s.Unlock()
+}
+
+// testErrUpstream is an upstream that always fails
+type testErrUpstream struct {
+	err error
+}
+
+func (u *testErrUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) { return nil, u.err }
+func (u *testErrUpstream) Address() string                       { return "err" }
+
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "i/o timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+func TestStatsUpstream(t *testing.T) {
+	st := &testStats{}
+	w := upstreamWrapper{stats: st}
+
+	conf := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{&testUpstream{}},
+		DomainReservedUpstreams: map[string][]upstream.Upstream{
+			"example.org": {&testErrUpstream{err: errorx.Decorate(testTimeoutError{}, "exchange")}},
+			"example.com": {&testErrUpstream{err: fmt.Errorf("refused")}},
+			"example.net": nil, // [/example.net/]#
+		},
+	}
+	wrapped := w.wrap(conf)
+
+	// the excluded domain still uses the default upstream servers
+	list, ok := wrapped.DomainReservedUpstreams["example.net"]
+	assert.True(t, ok)
+	assert.True(t, list == nil)
+	parsed, err := proxy.ParseUpstreamsConfig([]string{"1.1.1.1", "[/example.net/]#"}, nil, DefaultTimeout)
+	assert.Nil(t, err)
+	list, ok = w.wrap(&parsed).DomainReservedUpstreams["example.net."]
+	assert.True(t, ok)
+	assert.True(t, list == nil)
+
+	assert.Equal(t, wrapped, w.wrap(wrapped))
+	assert.True(t, w.wrapCustom(conf) == w.wrapCustom(conf))
+
+	req := createTestMessage("host.")
+	_, err = wrapped.Upstreams[0].Exchange(req)
+	assert.Nil(t, err)
+	_, err = wrapped.DomainReservedUpstreams["example.org"][0].Exchange(req)
+	assert.NotNil(t, err)
+	_, err = wrapped.DomainReservedUpstreams["example.com"][0].Exchange(req)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 3, len(st.upstreams))
+	assert.False(t, st.upstreams[0].Error)
+	assert.True(t, st.upstreams[1].Error)
+	assert.True(t, st.upstreams[1].Timeout)
+	assert.Equal(t, "err", st.upstreams[1].Upstream)
+	assert.True(t, st.upstreams[2].Error)
+	assert.False(t, st.upstreams[2].Timeout)
+}
+
+// testQueryLog is a mock of query log module that remembers the entries
+type testQueryLog struct {
+	entries []querylog.AddParams
+}
+
+func (l *testQueryLog) Start()                             {}
+func (l *testQueryLog) Close()                             {}
+func (l *testQueryLog) WriteDiskConfig(c *querylog.Config) {}
+
+func (l *testQueryLog) Add(params querylog.AddParams) {
+	l.entries = append(l.entries, params)
+}
+
+func TestClientLogSettings(t *testing.T) {
+	ql := &testQueryLog{}
+	st := &testStats{}
+	s := &Server{queryLog: ql, stats: st}
+	s.conf.GetClientLogSettings = func(ip string) (bool, bool) {
+		switch ip {
+		case "1.1.1.1":
+			return true, false
+		case "2.2.2.2":
+			return false, true
+		}
+		return false, false
+	}
+
+	process := func(ip net.IP) {
+		req := &dns.Msg{}
+		req.SetQuestion("example.org.", dns.TypeA)
+		ctx := &dnsContext{
+			srv:       s,
+			proxyCtx:  &proxy.DNSContext{Req: req, Addr: &net.UDPAddr{IP: ip, Port: 53}},
+			result:    &dnsfilter.Result{},
+			startTime: time.Now(),
+		}
+		assert.Equal(t, resultDone, processQueryLogsAndStats(ctx))
+	}
+
+	// the query log is ignored
+	process(net.IP{1, 1, 1, 1})
+	assert.Equal(t, 0, len(ql.entries))
+	assert.Equal(t, 1, len(st.entries))
+
+	// statistics is ignored
+	process(net.IP{2, 2, 2, 2})
+	assert.Equal(t, 1, len(ql.entries))
+	assert.Equal(t, 1, len(st.entries))
+
+	process(net.IP{3, 3, 3, 3})
+	assert.Equal(t, 2, len(ql.entries))
+	assert.Equal(t, 2, len(st.entries))
+}
@@ -0,0 +1,164 @@
+package dnsforward
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnstap"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Create dnstap sink if it's enabled (closes the previous one)
+func (s *Server) initDnstap() error {
+	if s.dnstap != nil {
+		s.dnstap.Close()
+		s.dnstap = nil
+	}
+
+	if !s.conf.Dnstap.Enabled {
+		return nil
+	}
+
+	var err error
+	s.dnstap, err = dnstap.New(s.conf.Dnstap)
+	return err
+}
+
+// Get client's protocol in dnstap format
+func dnstapClientProto(proto string) dnstap.SocketProtocol {
+	switch proto {
+	case "tcp":
+		return dnstap.ProtocolTCP
+	case "tls":
+		return dnstap.ProtocolDOT
+	case "https":
+		return dnstap.ProtocolDOH
+	}
+	return dnstap.ProtocolUDP
+}
+
+// Parse upstream address: "tls://1.1.1.1" -> 1.1.1.1, 853, DOT
+// IP is nil if the upstream is specified by host name
+func dnstapUpstreamAddr(addr string) (net.IP, uint16, dnstap.SocketProtocol) {
+	proto := dnstap.ProtocolUDP
+	port := uint16(53)
+	host := addr
+
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, 0, proto
+		}
+		switch u.Scheme {
+		case "tcp":
+			proto = dnstap.ProtocolTCP
+		case "tls":
+			proto = dnstap.ProtocolDOT
+			port = 853
+		case "https":
+			proto = dnstap.ProtocolDOH
+			port = 443
+		}
+		host = u.Host
+	}
+
+	h, p, err := net.SplitHostPort(host)
+	if err == nil {
+		host = h
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err == nil {
+			port = uint16(n)
+		}
+	}
+	return net.ParseIP(host), port, proto
+}
+
+// pack DNS message, return nil on error
+func dnstapPack(m *dns.Msg) []byte {
+	if m == nil {
+		return nil
+	}
+	b, err := m.Pack()
+	if err != nil {
+		log.Debug("dnstap: msg.Pack(): %s", err)
+		return nil
+	}
+	return b
+}
+
+// Send information about the processed request to the dnstap collector:
+// CLIENT_QUERY, CLIENT_RESPONSE and, if the request was forwarded, FORWARDER_QUERY, FORWARDER_RESPONSE
+func (s *Server) sendDnstap(ctx *dnsContext, upstream string) {
+	d := ctx.proxyCtx
+	now := time.Now()
+	clientIP := getIP(d.Addr)
+	clientPort := uint16(0)
+	switch addr := d.Addr.(type) {
+	case *net.UDPAddr:
+		clientPort = uint16(addr.Port)
+	case *net.TCPAddr:
+		clientPort = uint16(addr.Port)
+	}
+
+	req := ctx.origReq
+	if req == nil {
+		req = dnstapPack(d.Req)
+	}
+	resp := dnstapPack(d.Res)
+	msgs := []*dnstap.Message{
+		{
+			Type:           dnstap.MessageClientQuery,
+			SocketProtocol: dnstapClientProto(d.Proto),
+			QueryAddress:   clientIP,
+			QueryPort:      clientPort,
+			QueryTime:      ctx.startTime,
+			QueryMessage:   req,
+		},
+		{
+			Type:            dnstap.MessageClientResponse,
+			SocketProtocol:  dnstapClientProto(d.Proto),
+			QueryAddress:    clientIP,
+			QueryPort:       clientPort,
+			QueryTime:       ctx.startTime,
+			QueryMessage:    req,
+			ResponseTime:    now,
+			ResponseMessage: resp,
+		},
+	}
+
+	if len(upstream) != 0 {
+		upstreamResp := resp
+		if ctx.origResp != nil {
+			// the response was modified by filtering
+			upstreamResp = dnstapPack(ctx.origResp)
+		}
+		// the request sent to the upstream server
+		upstreamReq := dnstapPack(d.Req)
+		ip, port, proto := dnstapUpstreamAddr(upstream)
+		msgs = append(msgs,
+			&dnstap.Message{
+				Type:            dnstap.MessageForwarderQuery,
+				SocketProtocol:  proto,
+				ResponseAddress: ip,
+				ResponsePort:    port,
+				QueryTime:       ctx.startTime,
+				QueryMessage:    upstreamReq,
+			},
+			&dnstap.Message{
+				Type:            dnstap.MessageForwarderResponse,
+				SocketProtocol:  proto,
+				ResponseAddress: ip,
+				ResponsePort:    port,
+				QueryTime:       ctx.startTime,
+				QueryMessage:    upstreamReq,
+				ResponseTime:    now,
+				ResponseMessage: upstreamResp,
+			})
+	}
+
+	s.dnstap.Send(msgs...)
+}
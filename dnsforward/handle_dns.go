@@ -21,6 +21,7 @@ type dnsContext struct {
 	result               *dnsfilter.Result
 	origResp             *dns.Msg     // response received from upstream servers.  Set when response is modified by filtering
 	origQuestion         dns.Question // question received from client.  Set when Rewrites are used.
+	origReq              []byte       // request packet received from client.  Set when dnstap output is enabled.
 	err                  error        // error returned from the module
 	protectionEnabled    bool         // filtering is enabled, dnsfilter object is ready
 	responseFromUpstream bool         // response is received from upstream servers
@@ -39,10 +40,18 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, d *proxy.DNSContext) error {
 	ctx.result = &dnsfilter.Result{}
 	ctx.startTime = time.Now()
 
+	s.RLock()
+	if s.dnstap != nil {
+		// the request may be modified by the modules below (e.g. DNSSEC flag is set)
+		ctx.origReq = dnstapPack(d.Req)
+	}
+	s.RUnlock()
+
 	type modProcessFunc func(ctx *dnsContext) int
 	mods := []modProcessFunc{
 		processInitial,
 		processInternalIPAddrs,
+		processInternalHosts,
 		processFilteringBeforeRequest,
 		processUpstream,
 		processDNSSECAfterResponse,
@@ -114,6 +123,8 @@ func (s *Server) onDHCPLeaseChanged(flags int) {
 	s.tablePTRLock.Lock()
 	s.tablePTR = m
 	s.tablePTRLock.Unlock()
+
+	s.updateHostsTable()
 }
 
 // Respond to PTR requests if the target IP address is leased by our DHCP server
@@ -154,6 +165,11 @@ func processInternalIPAddrs(ctx *dnsContext) int {
 		Class:  dns.ClassINET,
 	}
 	ptr.Ptr = host + "."
+	label := hostnameToLabel(host)
+	domain := strings.Trim(s.conf.LocalDomainName, ".")
+	if len(domain) != 0 && len(label) != 0 {
+		ptr.Ptr = label + "." + domain + "."
+	}
 	resp.Answer = append(resp.Answer, ptr)
 	ctx.proxyCtx.Res = resp
 	return resultDone
@@ -204,6 +220,9 @@ func processUpstream(ctx *dnsContext) int {
 		upstreamsConf := s.conf.GetCustomUpstreamByClient(clientIP)
 		if upstreamsConf != nil {
 			log.Debug("Using custom upstreams for %s", clientIP)
+			if s.upstreamWrapper != nil {
+				upstreamsConf = s.upstreamWrapper.wrapCustom(upstreamsConf)
+			}
 			d.CustomUpstreamConfig = upstreamsConf
 		}
 	}
@@ -224,6 +243,7 @@ func processUpstream(ctx *dnsContext) int {
 	err := s.dnsProxy.Resolve(d)
 	if err != nil {
 		ctx.err = err
+		s.updateStatsOnError(ctx)
 		return resultError
 	}
 
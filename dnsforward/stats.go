@@ -27,6 +27,19 @@ func processQueryLogsAndStats(ctx *dnsContext) int {
 		shouldLog = false
 	}
 
+	// the client may have opted out of the query log and statistics
+	shouldCount := true
+	if d.Addr != nil && s.conf.GetClientLogSettings != nil {
+		ignoreQueryLog, ignoreStats := s.conf.GetClientLogSettings(ipFromAddr(d.Addr))
+		shouldLog = shouldLog && !ignoreQueryLog
+		shouldCount = !ignoreStats
+	}
+
+	upstream := ""
+	if d.Upstream != nil {
+		upstream = d.Upstream.Address()
+	}
+
 	s.RLock()
 	// Synchronize access to s.queryLog and s.stats so they won't be suddenly uninitialized while in use.
 	// This can happen after proxy server has been stopped, but its workers haven't yet exited.
@@ -38,6 +51,7 @@ func processQueryLogsAndStats(ctx *dnsContext) int {
 			Result:     ctx.result,
 			Elapsed:    elapsed,
 			ClientIP:   getIP(d.Addr),
+			Upstream:   upstream,
 		}
 
 		if d.Proto == "https" {
@@ -46,22 +60,53 @@ func processQueryLogsAndStats(ctx *dnsContext) int {
 			p.ClientProto = "dot"
 		}
 
-		if d.Upstream != nil {
-			p.Upstream = d.Upstream.Address()
-		}
 		s.queryLog.Add(p)
 	}
 
-	s.updateStats(d, elapsed, *ctx.result)
+	if shouldLog && s.dnstap != nil {
+		s.sendDnstap(ctx, upstream)
+	}
+
+	if shouldCount {
+		s.updateStats(ctx, elapsed, *ctx.result)
+	}
 	s.RUnlock()
 
 	return resultDone
 }
 
-func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dnsfilter.Result) {
+// Count the request which upstream servers failed to resolve:
+// the client receives SERVFAIL response
+func (s *Server) updateStatsOnError(ctx *dnsContext) {
+	d := ctx.proxyCtx
+	if d.Addr != nil && s.conf.GetClientLogSettings != nil {
+		_, ignoreStats := s.conf.GetClientLogSettings(ipFromAddr(d.Addr))
+		if ignoreStats {
+			return
+		}
+	}
+
+	s.RLock()
+	s.updateStats(ctx, time.Since(ctx.startTime), *ctx.result)
+	s.RUnlock()
+}
+
+// Get client protocol name for statistics
+func statsClientProto(d *proxy.DNSContext) string {
+	switch d.Proto {
+	case proxy.ProtoHTTPS:
+		return "doh"
+	case proxy.ProtoTLS:
+		return "dot"
+	}
+	return "plain"
+}
+
+func (s *Server) updateStats(ctx *dnsContext, elapsed time.Duration, res dnsfilter.Result) {
 	if s.stats == nil {
 		return
 	}
+	d := ctx.proxyCtx
 
 	e := stats.Entry{}
 	e.Domain = strings.ToLower(d.Req.Question[0].Name)
@@ -75,6 +120,14 @@ func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dns
 	e.Time = uint32(elapsed / 1000)
 	e.Result = stats.RNotFiltered
 
+	e.QType = dns.Type(d.Req.Question[0].Qtype).String()
+	if d.Res != nil {
+		e.RCode = dns.RcodeToString[d.Res.Rcode]
+	}
+	e.Proto = statsClientProto(d)
+	// dnsproxy doesn't set the upstream if the response is taken from the cache
+	e.Cached = ctx.responseFromUpstream && d.Upstream == nil
+
 	switch res.Reason {
 
 	case dnsfilter.FilteredSafeBrowsing:
@@ -0,0 +1,117 @@
+package dnsforward
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/stats"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/joomcode/errorx"
+	"github.com/miekg/dns"
+)
+
+// max number of per-client upstream configurations that we keep wrapped
+const maxWrappedUpstreamConfigs = 1000
+
+// statsUpstream - upstream server wrapper that counts every request in statistics
+// dnsproxy may send a request to several upstream servers (e.g. in parallel mode),
+// so we can't get this data from the DNS context after the request is processed.
+type statsUpstream struct {
+	upstream.Upstream
+	stats stats.Stats
+}
+
+// Exchange - send the request to the upstream server and count it
+func (u *statsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	start := time.Now()
+	resp, err := u.Upstream.Exchange(m)
+
+	e := stats.UpstreamEntry{
+		Upstream: u.Address(),
+		Time:     uint32(time.Since(start) / 1000),
+	}
+	if err != nil {
+		e.Error = true
+		e.Timeout = isTimeout(err)
+	}
+	u.stats.UpdateUpstream(e)
+
+	return resp, err
+}
+
+// isTimeout - return TRUE if the error is caused by timeout
+func isTimeout(err error) bool {
+	for err != nil {
+		if ne, ok := err.(net.Error); ok {
+			return ne.Timeout()
+		}
+		if xe, ok := err.(*errorx.Error); ok {
+			err = xe.Cause()
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
+
+// upstreamWrapper - wraps upstream servers so that the requests to them are counted in statistics
+type upstreamWrapper struct {
+	stats stats.Stats
+
+	// per-client configurations: original -> wrapped
+	// The clients' configurations are parsed once, so we don't have to wrap them for every request.
+	custom     map[*proxy.UpstreamConfig]*proxy.UpstreamConfig
+	customLock sync.Mutex
+}
+
+// wrapList - wrap the upstream servers
+// nil list is kept as is: for a domain it means "use the default upstream servers" ("[/domain/]#")
+func (w *upstreamWrapper) wrapList(list []upstream.Upstream) []upstream.Upstream {
+	if list == nil {
+		return nil
+	}
+	wrapped := []upstream.Upstream{}
+	for _, u := range list {
+		if _, ok := u.(*statsUpstream); !ok {
+			u = &statsUpstream{Upstream: u, stats: w.stats}
+		}
+		wrapped = append(wrapped, u)
+	}
+	return wrapped
+}
+
+// wrap - get a copy of the configuration with the wrapped upstream servers
+func (w *upstreamWrapper) wrap(conf *proxy.UpstreamConfig) *proxy.UpstreamConfig {
+	wrapped := &proxy.UpstreamConfig{
+		Upstreams: w.wrapList(conf.Upstreams),
+	}
+	if conf.DomainReservedUpstreams != nil {
+		wrapped.DomainReservedUpstreams = map[string][]upstream.Upstream{}
+		for domain, list := range conf.DomainReservedUpstreams {
+			wrapped.DomainReservedUpstreams[domain] = w.wrapList(list)
+		}
+	}
+	return wrapped
+}
+
+// wrapCustom - the same as wrap(), but for per-client configurations
+func (w *upstreamWrapper) wrapCustom(conf *proxy.UpstreamConfig) *proxy.UpstreamConfig {
+	w.customLock.Lock()
+	defer w.customLock.Unlock()
+
+	wrapped, ok := w.custom[conf]
+	if ok {
+		return wrapped
+	}
+
+	if w.custom == nil || len(w.custom) >= maxWrappedUpstreamConfigs {
+		// the old configurations are not used anymore after the clients were changed
+		w.custom = map[*proxy.UpstreamConfig]*proxy.UpstreamConfig{}
+	}
+	wrapped = w.wrap(conf)
+	w.custom[conf] = wrapped
+	return wrapped
+}
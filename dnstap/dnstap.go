@@ -0,0 +1,242 @@
+// Package dnstap writes information about DNS messages in dnstap format
+// (http://dnstap.info) using Frame Streams protocol.
+package dnstap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	queueSize         = 4096             // max number of messages waiting to be written
+	reconnectInterval = 5 * time.Second  // time to wait before reconnecting to the collector
+	connectTimeout    = 10 * time.Second // timeout for establishing a connection
+	flushInterval     = time.Second      // max time the messages may stay in the write buffer
+	defaultVersion    = "AdGuard Home"
+)
+
+// Config - module configuration
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Address of the dnstap collector:
+	//  unix:///var/run/dnstap.sock
+	//  tcp://127.0.0.1:6000
+	//  file:///var/log/adguardhome.dnstap
+	Address string `yaml:"address"`
+
+	// Identity of the server (dnstap.Dnstap.identity)
+	Identity string `yaml:"identity"`
+}
+
+// Sink sends dnstap messages to the collector
+// Messages are queued and written asynchronously.
+// If the collector isn't available or is too slow, new messages are dropped.
+type Sink struct {
+	network string // "unix", "tcp" or "file"
+	addr    string
+
+	identity []byte
+	version  []byte
+
+	queue   chan *Message
+	done    chan struct{}
+	wg      sync.WaitGroup
+	dropped uint64 // number of dropped messages (atomic)
+}
+
+// parseAddress - "unix:///path" -> "unix", "/path"
+func parseAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "unix", "file":
+		if len(u.Path) == 0 {
+			return "", "", fmt.Errorf("dnstap: no path in address %s", address)
+		}
+		return u.Scheme, u.Path, nil
+	case "tcp":
+		if len(u.Host) == 0 {
+			return "", "", fmt.Errorf("dnstap: no host in address %s", address)
+		}
+		return u.Scheme, u.Host, nil
+	}
+	return "", "", fmt.Errorf("dnstap: unsupported address %s", address)
+}
+
+// New - create the object and start the worker goroutine
+func New(conf Config) (*Sink, error) {
+	network, addr, err := parseAddress(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sink{
+		network:  network,
+		addr:     addr,
+		identity: []byte(conf.Identity),
+		version:  []byte(defaultVersion),
+		queue:    make(chan *Message, queueSize),
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	log.Debug("dnstap: writing to %s", conf.Address)
+	return s, nil
+}
+
+// Close - flush the queued messages and close the connection
+func (s *Sink) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Send - queue the messages for writing
+// Doesn't block: if the queue is full, the messages are dropped
+func (s *Sink) Send(msgs ...*Message) {
+	for _, m := range msgs {
+		select {
+		case s.queue <- m:
+		default:
+			n := atomic.AddUint64(&s.dropped, 1)
+			if n == 1 || n%10000 == 0 {
+				log.Debug("dnstap: the queue is full, dropped %d messages", n)
+			}
+		}
+	}
+}
+
+// connection to the collector
+type connection struct {
+	c        io.Closer
+	nc       net.Conn // nil for files
+	fw       *frameWriter
+	buffered bool // there's data not yet flushed
+}
+
+// Connect to the collector (or create the file) and open the stream
+// The file is truncated: Frame Streams readers expect only one stream (START ... STOP) in a file.
+func (s *Sink) connect() (*connection, error) {
+	conn := &connection{}
+	if s.network == "file" {
+		f, err := os.OpenFile(s.addr, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		conn.c = f
+		conn.fw = newFrameWriter(bufio.NewWriter(f), nil, false)
+	} else {
+		c, err := net.DialTimeout(s.network, s.addr, connectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		conn.c = c
+		conn.nc = c
+		conn.fw = newFrameWriter(bufio.NewWriter(c), c, true)
+		_ = c.SetDeadline(time.Now().Add(connectTimeout))
+		defer func() { _ = c.SetDeadline(time.Time{}) }()
+	}
+
+	err := conn.fw.open()
+	if err != nil {
+		_ = conn.c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Close the stream and the connection
+func (conn *connection) close() {
+	if conn.nc != nil {
+		// don't wait for the collector's response forever
+		_ = conn.nc.SetDeadline(time.Now().Add(connectTimeout))
+	}
+	err := conn.fw.close()
+	if err != nil {
+		log.Debug("dnstap: close: %s", err)
+	}
+	_ = conn.c.Close()
+}
+
+// Worker: connect to the collector and write queued messages
+func (s *Sink) run() {
+	defer s.wg.Done()
+
+	var conn *connection
+	var lastConnect time.Time
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-s.queue:
+			if conn == nil {
+				if time.Since(lastConnect) < reconnectInterval {
+					// the collector isn't available: drop the message and try again later
+					continue
+				}
+				lastConnect = time.Now()
+				var err error
+				conn, err = s.connect()
+				if err != nil {
+					log.Debug("dnstap: connect: %s", err)
+					continue
+				}
+			}
+
+			err := conn.fw.writeFrame(encodeDnstap(s.identity, s.version, m))
+			if err != nil {
+				log.Debug("dnstap: write: %s", err)
+				_ = conn.c.Close()
+				conn = nil
+				continue
+			}
+			conn.buffered = true
+
+		case <-ticker.C:
+			if conn != nil && conn.buffered {
+				conn.buffered = false
+				err := conn.fw.flush()
+				if err != nil {
+					log.Debug("dnstap: write: %s", err)
+					_ = conn.c.Close()
+					conn = nil
+				}
+			}
+
+		case <-s.done:
+			if conn != nil {
+				s.drain(conn)
+				conn.close()
+			}
+			return
+		}
+	}
+}
+
+// Write all the queued messages
+func (s *Sink) drain(conn *connection) {
+	for {
+		select {
+		case m := <-s.queue:
+			err := conn.fw.writeFrame(encodeDnstap(s.identity, s.version, m))
+			if err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
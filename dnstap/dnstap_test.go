@@ -0,0 +1,175 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Decode protobuf message: field number -> value ([]byte or uint64)
+func decodeFields(t *testing.T, b []byte) map[int]interface{} {
+	m := map[int]interface{}{}
+	readVarint := func() uint64 {
+		v, n := binary.Uvarint(b)
+		assert.True(t, n > 0)
+		b = b[n:]
+		return v
+	}
+	for len(b) != 0 {
+		tag := readVarint()
+		switch tag & 7 {
+		case wireVarint:
+			m[int(tag>>3)] = readVarint()
+		case wireBytes:
+			n := readVarint()
+			m[int(tag>>3)] = b[:n]
+			b = b[n:]
+		case wireFixed32:
+			m[int(tag>>3)] = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			t.Fatalf("unknown wire type %d", tag&7)
+		}
+	}
+	return m
+}
+
+// Read a frame: return control frame type (0 for data frames) and payload
+func readFrame(t *testing.T, r io.Reader) (uint32, []byte) {
+	var hdr [4]byte
+	_, err := io.ReadFull(r, hdr[:])
+	assert.Nil(t, err)
+	n := binary.BigEndian.Uint32(hdr[:])
+	ctrl := n == 0
+	if ctrl {
+		_, err = io.ReadFull(r, hdr[:])
+		assert.Nil(t, err)
+		n = binary.BigEndian.Uint32(hdr[:])
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	assert.Nil(t, err)
+	if ctrl {
+		return binary.BigEndian.Uint32(data), data[4:]
+	}
+	return 0, data
+}
+
+func testMessage() *Message {
+	return &Message{
+		Type:           MessageClientQuery,
+		SocketProtocol: ProtocolUDP,
+		QueryAddress:   net.ParseIP("192.168.1.2"),
+		QueryPort:      12345,
+		QueryTime:      time.Unix(1000, 500),
+		QueryMessage:   []byte{1, 2, 3},
+	}
+}
+
+func checkDnstapFrame(t *testing.T, data []byte) {
+	dt := decodeFields(t, data)
+	assert.Equal(t, []byte("server1"), dt[fieldDnstapIdentity])
+	assert.Equal(t, uint64(dnstapTypeMessage), dt[fieldDnstapType])
+
+	msg := decodeFields(t, dt[fieldDnstapMessage].([]byte))
+	assert.Equal(t, uint64(MessageClientQuery), msg[fieldMsgType])
+	assert.Equal(t, uint64(familyINET), msg[fieldMsgSocketFamily])
+	assert.Equal(t, uint64(ProtocolUDP), msg[fieldMsgSocketProtocol])
+	assert.Equal(t, []byte{192, 168, 1, 2}, msg[fieldMsgQueryAddress])
+	assert.Equal(t, uint64(12345), msg[fieldMsgQueryPort])
+	assert.Equal(t, uint64(1000), msg[fieldMsgQueryTimeSec])
+	assert.Equal(t, uint64(500), msg[fieldMsgQueryTimeNsec])
+	assert.Equal(t, []byte{1, 2, 3}, msg[fieldMsgQueryMessage])
+}
+
+func TestDnstapUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnstap")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	sockPath := filepath.Join(dir, "dnstap.sock")
+	ln, err := net.Listen("unix", sockPath)
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	s, err := New(Config{Enabled: true, Address: "unix://" + sockPath, Identity: "server1"})
+	assert.Nil(t, err)
+	s.Send(testMessage())
+
+	c, err := ln.Accept()
+	assert.Nil(t, err)
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	ctype, data := readFrame(t, c)
+	assert.Equal(t, uint32(controlReady), ctype)
+	assert.Contains(t, string(data), contentType)
+	_, _ = c.Write(encodeControlFrame(controlAccept, true))
+
+	ctype, _ = readFrame(t, c)
+	assert.Equal(t, uint32(controlStart), ctype)
+
+	ctype, data = readFrame(t, c)
+	assert.Equal(t, uint32(0), ctype)
+	checkDnstapFrame(t, data)
+
+	go func() {
+		ctype, _ := readFrame(t, c)
+		assert.Equal(t, uint32(controlStop), ctype)
+		_, _ = c.Write(encodeControlFrame(controlFinish, false))
+	}()
+	s.Close()
+}
+
+func TestDnstapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnstap")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	fn := filepath.Join(dir, "out.dnstap")
+
+	// the file is recreated by a new instance: it contains only one stream
+	for n := 0; n != 2; n++ {
+		s, err := New(Config{Enabled: true, Address: "file://" + fn, Identity: "server1"})
+		assert.Nil(t, err)
+		s.Send(testMessage())
+		s.Send(testMessage())
+
+		// wait until the worker takes the messages from the queue
+		for i := 0; i != 100 && len(s.queue) != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.Close()
+	}
+
+	f, err := os.Open(fn)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	ctype, data := readFrame(t, f)
+	assert.Equal(t, uint32(controlStart), ctype)
+	assert.Contains(t, string(data), contentType)
+	for i := 0; i != 2; i++ {
+		ctype, data = readFrame(t, f)
+		assert.Equal(t, uint32(0), ctype)
+		checkDnstapFrame(t, data)
+	}
+	ctype, _ = readFrame(t, f)
+	assert.Equal(t, uint32(controlStop), ctype)
+	_, err = f.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDnstapAddress(t *testing.T) {
+	_, err := New(Config{Address: "udp://127.0.0.1:6000"})
+	assert.NotNil(t, err)
+	_, err = New(Config{Address: "tcp://"})
+	assert.NotNil(t, err)
+}
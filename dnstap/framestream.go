@@ -0,0 +1,159 @@
+package dnstap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Frame Streams protocol:
+// https://farsightsec.github.io/fstrm/
+//
+// Data frame:    [length (4 bytes)] [payload]
+// Control frame: [0 (4 bytes)] [length (4 bytes)] [control type (4 bytes)] [fields]
+// Control field: [field type (4 bytes)] [length (4 bytes)] [value]
+//
+// Bi-directional mode (sockets):
+// . writer: READY (content types) -> reader: ACCEPT (content types)
+// . writer: START (content type)
+// . writer: data frames
+// . writer: STOP -> reader: FINISH
+//
+// Uni-directional mode (files): START, data frames, STOP
+
+const contentType = "protobuf:dnstap.Dnstap"
+
+// control frame types
+const (
+	controlAccept = 1
+	controlStart  = 2
+	controlStop   = 3
+	controlReady  = 4
+	controlFinish = 5
+)
+
+const fieldContentType = 1
+
+// max length of a control frame we accept from the reader
+const maxControlFrameLength = 512
+
+// bufferedWriter - io.Writer with a buffer, e.g. bufio.Writer
+type bufferedWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// frameWriter writes data using Frame Streams protocol
+type frameWriter struct {
+	w             bufferedWriter
+	r             io.Reader // nil in uni-directional mode
+	bidirectional bool
+}
+
+// Create a writer
+// r is used to read control frames from the other side in bi-directional mode
+func newFrameWriter(w bufferedWriter, r io.Reader, bidirectional bool) *frameWriter {
+	return &frameWriter{w: w, r: r, bidirectional: bidirectional}
+}
+
+// Encode a control frame
+func encodeControlFrame(ctype uint32, withContentType bool) []byte {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, ctype)
+	if withContentType {
+		var field [8]byte
+		binary.BigEndian.PutUint32(field[0:], fieldContentType)
+		binary.BigEndian.PutUint32(field[4:], uint32(len(contentType)))
+		body = append(body, field[:]...)
+		body = append(body, contentType...)
+	}
+
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b[4:], uint32(len(body)))
+	return append(b, body...)
+}
+
+// Read a control frame and check its type
+func (f *frameWriter) readControlFrame(expected uint32) error {
+	var hdr [8]byte
+	_, err := io.ReadFull(f.r, hdr[:])
+	if err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(hdr[0:]) != 0 {
+		return fmt.Errorf("expected control frame")
+	}
+	n := binary.BigEndian.Uint32(hdr[4:])
+	if n < 4 || n > maxControlFrameLength {
+		return fmt.Errorf("invalid control frame length %d", n)
+	}
+
+	body := make([]byte, n)
+	_, err = io.ReadFull(f.r, body)
+	if err != nil {
+		return err
+	}
+	ctype := binary.BigEndian.Uint32(body)
+	if ctype != expected {
+		return fmt.Errorf("unexpected control frame type %d", ctype)
+	}
+
+	if expected == controlAccept && !bytes.Contains(body[4:], []byte(contentType)) {
+		return fmt.Errorf("content type isn't accepted")
+	}
+	return nil
+}
+
+// Open the stream: perform a handshake (in bi-directional mode) and send START frame
+func (f *frameWriter) open() error {
+	if f.bidirectional {
+		_, err := f.w.Write(encodeControlFrame(controlReady, true))
+		if err == nil {
+			err = f.w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		err = f.readControlFrame(controlAccept)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := f.w.Write(encodeControlFrame(controlStart, true))
+	if err != nil {
+		return err
+	}
+	return f.w.Flush()
+}
+
+// Write a data frame
+// The data may stay in the buffer until flush() is called
+func (f *frameWriter) writeFrame(data []byte) error {
+	b := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	_, err := f.w.Write(b)
+	return err
+}
+
+// Write the buffered data
+func (f *frameWriter) flush() error {
+	return f.w.Flush()
+}
+
+// Close the stream: send STOP frame and wait for FINISH (in bi-directional mode)
+func (f *frameWriter) close() error {
+	_, err := f.w.Write(encodeControlFrame(controlStop, false))
+	if err == nil {
+		err = f.w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if f.bidirectional {
+		return f.readControlFrame(controlFinish)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// MessageType - type of dnstap message (Message.Type in dnstap.proto)
+type MessageType int
+
+// Message types
+const (
+	MessageAuthQuery         MessageType = 1
+	MessageAuthResponse      MessageType = 2
+	MessageResolverQuery     MessageType = 3
+	MessageResolverResponse  MessageType = 4
+	MessageClientQuery       MessageType = 5
+	MessageClientResponse    MessageType = 6
+	MessageForwarderQuery    MessageType = 7
+	MessageForwarderResponse MessageType = 8
+)
+
+// SocketProtocol - transport protocol (SocketProtocol in dnstap.proto)
+type SocketProtocol int
+
+// Socket protocols
+const (
+	ProtocolUDP SocketProtocol = 1
+	ProtocolTCP SocketProtocol = 2
+	ProtocolDOT SocketProtocol = 3
+	ProtocolDOH SocketProtocol = 4
+)
+
+// socket families (SocketFamily in dnstap.proto)
+const (
+	familyINET  = 1
+	familyINET6 = 2
+)
+
+// Message - DNS message with its metadata
+type Message struct {
+	Type           MessageType
+	SocketProtocol SocketProtocol
+
+	QueryAddress    net.IP
+	QueryPort       uint16
+	ResponseAddress net.IP
+	ResponsePort    uint16
+
+	QueryTime       time.Time
+	QueryMessage    []byte // wire-format DNS message
+	ResponseTime    time.Time
+	ResponseMessage []byte // wire-format DNS message
+}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// dnstap.proto field numbers
+const (
+	fieldDnstapIdentity = 1
+	fieldDnstapVersion  = 2
+	fieldDnstapMessage  = 14
+	fieldDnstapType     = 15
+
+	fieldMsgType             = 1
+	fieldMsgSocketFamily     = 2
+	fieldMsgSocketProtocol   = 3
+	fieldMsgQueryAddress     = 4
+	fieldMsgResponseAddress  = 5
+	fieldMsgQueryPort        = 6
+	fieldMsgResponsePort     = 7
+	fieldMsgQueryTimeSec     = 8
+	fieldMsgQueryTimeNsec    = 9
+	fieldMsgQueryMessage     = 10
+	fieldMsgResponseTimeSec  = 12
+	fieldMsgResponseTimeNsec = 13
+	fieldMsgResponseMessage  = 14
+
+	dnstapTypeMessage = 1
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendFixed32Field(b []byte, field int, v uint32) []byte {
+	b = appendTag(b, field, wireFixed32)
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// Get IP address bytes in the form required by dnstap: 4 bytes for IPv4, 16 bytes for IPv6
+func ipBytes(ip net.IP) ([]byte, int) {
+	ip4 := ip.To4()
+	if ip4 != nil {
+		return ip4, familyINET
+	}
+	return ip.To16(), familyINET6
+}
+
+// Encode the message in protobuf format (dnstap.Message)
+func (m *Message) encode() []byte {
+	b := appendVarintField(nil, fieldMsgType, uint64(m.Type))
+
+	family := 0
+	var qaddr, raddr []byte
+	if m.QueryAddress != nil {
+		qaddr, family = ipBytes(m.QueryAddress)
+	}
+	if m.ResponseAddress != nil {
+		raddr, family = ipBytes(m.ResponseAddress)
+	}
+	if family != 0 {
+		b = appendVarintField(b, fieldMsgSocketFamily, uint64(family))
+	}
+	if m.SocketProtocol != 0 {
+		b = appendVarintField(b, fieldMsgSocketProtocol, uint64(m.SocketProtocol))
+	}
+
+	if qaddr != nil {
+		b = appendBytesField(b, fieldMsgQueryAddress, qaddr)
+		b = appendVarintField(b, fieldMsgQueryPort, uint64(m.QueryPort))
+	}
+	if raddr != nil {
+		b = appendBytesField(b, fieldMsgResponseAddress, raddr)
+		b = appendVarintField(b, fieldMsgResponsePort, uint64(m.ResponsePort))
+	}
+
+	if !m.QueryTime.IsZero() {
+		b = appendVarintField(b, fieldMsgQueryTimeSec, uint64(m.QueryTime.Unix()))
+		b = appendFixed32Field(b, fieldMsgQueryTimeNsec, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		b = appendBytesField(b, fieldMsgQueryMessage, m.QueryMessage)
+	}
+
+	if !m.ResponseTime.IsZero() {
+		b = appendVarintField(b, fieldMsgResponseTimeSec, uint64(m.ResponseTime.Unix()))
+		b = appendFixed32Field(b, fieldMsgResponseTimeNsec, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		b = appendBytesField(b, fieldMsgResponseMessage, m.ResponseMessage)
+	}
+
+	return b
+}
+
+// Encode the message wrapped in dnstap.Dnstap object
+func encodeDnstap(identity, version []byte, m *Message) []byte {
+	var b []byte
+	if len(identity) != 0 {
+		b = appendBytesField(b, fieldDnstapIdentity, identity)
+	}
+	if len(version) != 0 {
+		b = appendBytesField(b, fieldDnstapVersion, version)
+	}
+	b = appendBytesField(b, fieldDnstapMessage, m.encode())
+	b = appendVarintField(b, fieldDnstapType, dnstapTypeMessage)
+	return b
+}
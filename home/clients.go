@@ -43,6 +43,9 @@ type Client struct {
 
 	Upstreams []string // list of upstream servers to be used for the client's requests
 
+	IgnoreQueryLog   bool // don't write the client's requests to the query log
+	IgnoreStatistics bool // don't count the client's requests in statistics
+
 	// Custom upstream config for this client
 	// nil: not yet initialized
 	// not nil, but empty: initialized, no good upstreams
@@ -67,6 +70,9 @@ type ClientHost struct {
 	Host      string
 	Source    clientSource
 	WhoisInfo [][]string // [[key,value], ...]
+
+	// Tags guessed from the client's DHCP fingerprint (device type, OS)
+	Tags []string
 }
 
 type clientsContainer struct {
@@ -142,6 +148,9 @@ type clientObject struct {
 	BlockedServices          []string `yaml:"blocked_services"`
 
 	Upstreams []string `yaml:"upstreams"`
+
+	IgnoreQueryLog   bool `yaml:"ignore_querylog"`
+	IgnoreStatistics bool `yaml:"ignore_statistics"`
 }
 
 func (clients *clientsContainer) tagKnown(tag string) bool {
@@ -163,6 +172,9 @@ func (clients *clientsContainer) addFromConfig(objects []clientObject) {
 			UseOwnBlockedServices: !cy.UseGlobalBlockedServices,
 
 			Upstreams: cy.Upstreams,
+
+			IgnoreQueryLog:   cy.IgnoreQueryLog,
+			IgnoreStatistics: cy.IgnoreStatistics,
 		}
 
 		for _, s := range cy.BlockedServices {
@@ -201,6 +213,8 @@ func (clients *clientsContainer) WriteDiskConfig(objects *[]clientObject) {
 			SafeSearchEnabled:        cli.SafeSearchEnabled,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			IgnoreQueryLog:           cli.IgnoreQueryLog,
+			IgnoreStatistics:         cli.IgnoreStatistics,
 		}
 
 		cy.Tags = stringArrayDup(cli.Tags)
@@ -301,6 +315,19 @@ func (clients *clientsContainer) FindUpstreams(ip string) *proxy.UpstreamConfig
 	return c.upstreamConfig
 }
 
+// FindLogSettings checks whether the client's requests must not be written
+// to the query log or counted in statistics
+func (clients *clientsContainer) FindLogSettings(ip string) (ignoreQueryLog, ignoreStats bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findByIP(ip)
+	if !ok {
+		return false, false
+	}
+	return c.IgnoreQueryLog, c.IgnoreStatistics
+}
+
 // Find searches for a client by IP (and does not lock anything)
 func (clients *clientsContainer) findByIP(ip string) (Client, bool) {
 	ipAddr := net.ParseIP(ip)
@@ -359,7 +386,9 @@ func (clients *clientsContainer) FindAutoClient(ip string) (ClientHost, bool) {
 
 	ch, ok := clients.ipHost[ip]
 	if ok {
-		return *ch, true
+		c := *ch
+		c.Tags = stringArrayDup(ch.Tags)
+		return c, true
 	}
 	return ClientHost{}, false
 }
@@ -675,6 +704,7 @@ func (clients *clientsContainer) addFromSystemARP() {
 }
 
 // Add clients from DHCP that have non-empty Hostname property
+// The tags guessed from the DHCP fingerprint are set for all clients with a lease.
 func (clients *clientsContainer) addFromDHCP() {
 	if clients.dhcpServer == nil {
 		return
@@ -685,15 +715,29 @@ func (clients *clientsContainer) addFromDHCP() {
 
 	_ = clients.rmHosts(ClientSourceDHCP)
 
+	// the tags of the entries owned by other sources are from the previous leases
+	for _, ch := range clients.ipHost {
+		ch.Tags = nil
+	}
+
 	leases := clients.dhcpServer.Leases(dhcpd.LeasesAll)
 	n := 0
 	for _, l := range leases {
-		if len(l.Hostname) == 0 {
-			continue
+		// the fingerprint doesn't need a host name
+		tags := guessClientTags(l.Hostname, l.VendorClass, l.ParamReqList)
+		ip := l.IP.String()
+		if len(l.Hostname) != 0 {
+			ok, _ := clients.addHost(ip, l.Hostname, ClientSourceDHCP)
+			if ok {
+				n++
+			}
 		}
-		ok, _ := clients.addHost(l.IP.String(), l.Hostname, ClientSourceDHCP)
+
+		// the entry may be owned by a source with a higher priority,
+		//  but the fingerprint is still valid for this IP
+		ch, ok := clients.ipHost[ip]
 		if ok {
-			n++
+			ch.Tags = tags
 		}
 	}
 	log.Debug("Clients: added %d client aliases from DHCP", n)
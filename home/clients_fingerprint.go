@@ -0,0 +1,139 @@
+package home
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Guess the device type and OS of a DHCP client.
+// Sources (in the order of priority):
+// . the list of options the client requests (DHCP option 55)
+// . vendor class identifier (DHCP option 60)
+// . host name
+// The first rule that sets a device_* tag wins, the same applies to os_* tags.
+
+type fingerprintRule struct {
+	match string   // the value (or its part) to search for
+	tags  []string // tags assigned to the client if the rule matches
+}
+
+// Parameter request lists: "option1,option2,..." -> tags
+// Note: the list must match exactly
+var fingerprintPRL = []fingerprintRule{
+	{"1,3,6,15,31,33,43,44,46,47,119,121,249,252", []string{"device_pc", "os_windows"}},
+	{"1,15,3,6,44,46,47,31,33,121,249,43", []string{"device_pc", "os_windows"}},
+	{"1,15,3,6,44,46,47,31,33,121,249,43,252", []string{"device_pc", "os_windows"}},
+	{"1,121,3,6,15,119,252,95,44,46", []string{"os_macos"}},
+	{"1,121,3,6,15,108,114,119,252,95,44,46", []string{"os_macos"}},
+	{"1,121,3,6,15,119,252", []string{"os_ios"}},
+	{"1,121,3,6,15,108,114,119,252", []string{"os_ios"}},
+	{"1,3,6,15,26,28,51,58,59", []string{"os_android"}},
+	{"1,3,6,15,26,28,51,58,59,43", []string{"os_android"}},
+	{"1,3,6,15,26,28,51,58,59,43,114", []string{"os_android"}},
+	{"1,28,2,3,15,6,119,12,44,47,26,121,42", []string{"os_linux"}},
+	{"1,28,2,3,15,6,12", []string{"os_linux"}},
+	{"1,3,6,12,15,28,42", []string{"os_linux"}},
+}
+
+// Vendor class identifiers: prefix -> tags
+var fingerprintVendor = []fingerprintRule{
+	{"msft", []string{"os_windows"}},
+	{"android-dhcp-", []string{"device_phone", "os_android"}},
+	{"dhcpcd-", []string{"os_linux"}},
+	{"udhcp", []string{"os_linux"}},
+}
+
+// Host names: substring -> tags
+var fingerprintHostname = []fingerprintRule{
+	{"iphone", []string{"device_phone", "os_ios"}},
+	{"ipad", []string{"device_tablet", "os_ios"}},
+	{"macbook", []string{"device_laptop", "os_macos"}},
+	{"imac", []string{"device_pc", "os_macos"}},
+	{"mac-mini", []string{"device_pc", "os_macos"}},
+	{"appletv", []string{"device_tv"}},
+	{"apple-tv", []string{"device_tv"}},
+	{"android", []string{"device_phone", "os_android"}},
+	{"galaxy", []string{"device_phone", "os_android"}},
+	{"desktop-", []string{"device_pc", "os_windows"}},
+	{"laptop-", []string{"device_laptop", "os_windows"}},
+	{"chromecast", []string{"device_tv"}},
+	{"roku", []string{"device_tv"}},
+	{"playstation", []string{"device_gameconsole"}},
+	{"xbox", []string{"device_gameconsole"}},
+	{"nintendo", []string{"device_gameconsole"}},
+	{"printer", []string{"device_printer"}},
+	{"epson", []string{"device_printer"}},
+	{"brother", []string{"device_printer"}},
+	{"synology", []string{"device_nas"}},
+	{"diskstation", []string{"device_nas"}},
+	{"qnap", []string{"device_nas"}},
+	{"camera", []string{"device_camera"}},
+	{"ipcam", []string{"device_camera"}},
+	{"sonos", []string{"device_audio"}},
+	{"raspberrypi", []string{"device_other", "os_linux"}},
+}
+
+// Convert the parameter request list to its text representation: "1,3,6"
+func prlString(prl []byte) string {
+	var sb strings.Builder
+	for i, code := range prl {
+		if i != 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.Itoa(int(code)))
+	}
+	return sb.String()
+}
+
+// Add tags from the matching rule unless tags of the same kind are already set
+func applyFingerprintRule(rule fingerprintRule, device, osName *string) {
+	for _, t := range rule.tags {
+		if strings.HasPrefix(t, "device_") && len(*device) == 0 {
+			*device = t
+		} else if strings.HasPrefix(t, "os_") && len(*osName) == 0 {
+			*osName = t
+		}
+	}
+}
+
+// Get the list of tags for a client by its DHCP fingerprint
+// Return nil if nothing is known about the client
+func guessClientTags(hostname, vendorClass string, prl []byte) []string {
+	device := ""
+	osName := ""
+
+	if len(prl) != 0 {
+		s := prlString(prl)
+		for _, r := range fingerprintPRL {
+			if r.match == s {
+				applyFingerprintRule(r, &device, &osName)
+				break
+			}
+		}
+	}
+
+	vendorClass = strings.ToLower(vendorClass)
+	for _, r := range fingerprintVendor {
+		if strings.HasPrefix(vendorClass, r.match) {
+			applyFingerprintRule(r, &device, &osName)
+			break
+		}
+	}
+
+	hostname = strings.ToLower(hostname)
+	for _, r := range fingerprintHostname {
+		if strings.Contains(hostname, r.match) {
+			applyFingerprintRule(r, &device, &osName)
+			break
+		}
+	}
+
+	var tags []string
+	if len(device) != 0 {
+		tags = append(tags, device)
+	}
+	if len(osName) != 0 {
+		tags = append(tags, osName)
+	}
+	return tags
+}
@@ -21,12 +21,16 @@ type clientJSON struct {
 	BlockedServices          []string `json:"blocked_services"`
 
 	Upstreams []string `json:"upstreams"`
+
+	IgnoreQueryLog   bool `json:"ignore_querylog"`
+	IgnoreStatistics bool `json:"ignore_statistics"`
 }
 
 type clientHostJSON struct {
-	IP     string `json:"ip"`
-	Name   string `json:"name"`
-	Source string `json:"source"`
+	IP     string   `json:"ip"`
+	Name   string   `json:"name"`
+	Source string   `json:"source"`
+	Tags   []string `json:"tags"` // guessed by DHCP fingerprint
 
 	WhoisInfo map[string]interface{} `json:"whois_info"`
 }
@@ -50,6 +54,7 @@ func (clients *clientsContainer) handleGetClients(w http.ResponseWriter, r *http
 		cj := clientHostJSON{
 			IP:   ip,
 			Name: ch.Host,
+			Tags: ch.Tags,
 		}
 
 		cj.Source = "etc/hosts"
@@ -99,6 +104,9 @@ func jsonToClient(cj clientJSON) (*Client, error) {
 		BlockedServices:       cj.BlockedServices,
 
 		Upstreams: cj.Upstreams,
+
+		IgnoreQueryLog:   cj.IgnoreQueryLog,
+		IgnoreStatistics: cj.IgnoreStatistics,
 	}
 	return &c, nil
 }
@@ -119,6 +127,9 @@ func clientToJSON(c *Client) clientJSON {
 		BlockedServices:          c.BlockedServices,
 
 		Upstreams: c.Upstreams,
+
+		IgnoreQueryLog:   c.IgnoreQueryLog,
+		IgnoreStatistics: c.IgnoreStatistics,
 	}
 	return cj
 }
@@ -126,6 +137,7 @@ func clientToJSON(c *Client) clientJSON {
 type clientHostJSONWithID struct {
 	IDs       []string               `json:"ids"`
 	Name      string                 `json:"name"`
+	Tags      []string               `json:"tags"`
 	WhoisInfo map[string]interface{} `json:"whois_info"`
 }
 
@@ -134,6 +146,7 @@ func clientHostToJSON(ip string, ch ClientHost) clientHostJSONWithID {
 	cj := clientHostJSONWithID{
 		Name: ch.Host,
 		IDs:  []string{ip},
+		Tags: ch.Tags,
 	}
 
 	cj.WhoisInfo = make(map[string]interface{})
@@ -264,3 +264,86 @@ func TestClientsCustomUpstream(t *testing.T) {
 	assert.Equal(t, 1, len(config.Upstreams))
 	assert.Equal(t, 1, len(config.DomainReservedUpstreams))
 }
+
+func TestClientsLogSettings(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+
+	clients.Init(nil, nil, nil)
+
+	ok, err := clients.Add(Client{IDs: []string{"1.1.1.1"}, Name: "client1", IgnoreQueryLog: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = clients.Add(Client{IDs: []string{"2.2.2.2"}, Name: "client2", IgnoreStatistics: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ignoreQueryLog, ignoreStats := clients.FindLogSettings("1.1.1.1")
+	assert.True(t, ignoreQueryLog)
+	assert.False(t, ignoreStats)
+
+	ignoreQueryLog, ignoreStats = clients.FindLogSettings("2.2.2.2")
+	assert.False(t, ignoreQueryLog)
+	assert.True(t, ignoreStats)
+
+	// unknown client
+	ignoreQueryLog, ignoreStats = clients.FindLogSettings("3.3.3.3")
+	assert.False(t, ignoreQueryLog)
+	assert.False(t, ignoreStats)
+}
+
+func TestClientsFingerprint(t *testing.T) {
+	// option 55 has the highest priority, host name is used for the device type
+	prl := []byte{1, 121, 3, 6, 15, 119, 252}
+	tags := guessClientTags("Johns-iPhone", "", prl)
+	assert.Equal(t, []string{"device_phone", "os_ios"}, tags)
+
+	// vendor class
+	tags = guessClientTags("", "android-dhcp-10", nil)
+	assert.Equal(t, []string{"device_phone", "os_android"}, tags)
+
+	tags = guessClientTags("DESKTOP-1234", "MSFT 5.0", nil)
+	assert.Equal(t, []string{"device_pc", "os_windows"}, tags)
+
+	// nothing is known
+	tags = guessClientTags("host", "", []byte{1, 2, 3})
+	assert.Nil(t, tags)
+}
+
+func TestClientsDHCPTags(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	config := dhcpd.ServerConfig{
+		DBFilePath: "leases.db",
+	}
+	defer func() { _ = os.Remove("leases.db") }()
+	clients.dhcpServer = dhcpd.Create(config)
+
+	// the client without a host name is known from /etc/hosts
+	ok, err := clients.AddHost("1.2.3.4", "hostsfile", ClientSourceHostsFile)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	mac, _ := net.ParseMAC("aa:aa:aa:aa:aa:aa")
+	l := dhcpd.Lease{
+		HWAddr:      mac,
+		IP:          net.ParseIP("1.2.3.4").To4(),
+		VendorClass: "android-dhcp-10",
+	}
+	assert.Nil(t, clients.dhcpServer.AddStaticLease(l))
+
+	clients.addFromDHCP()
+	ch, ok := clients.FindAutoClient("1.2.3.4")
+	assert.True(t, ok)
+	assert.Equal(t, "hostsfile", ch.Host)
+	assert.Equal(t, []string{"device_phone", "os_android"}, ch.Tags)
+
+	// the lease is removed: the tags are cleared
+	assert.Nil(t, clients.dhcpServer.RemoveStaticLease(l))
+	clients.addFromDHCP()
+	ch, ok = clients.FindAutoClient("1.2.3.4")
+	assert.True(t, ok)
+	assert.Empty(t, ch.Tags)
+}
@@ -83,12 +83,33 @@ type dnsConfig struct {
 	// time interval for statistics (in days)
 	StatsInterval uint32 `yaml:"statistics_interval"`
 
+	// collect statistics for each client separately
+	StatsPerClient bool `yaml:"statistics_per_client"`
+
+	// number of minutes for which minute-resolution statistics data is kept
+	StatsMinutes uint32 `yaml:"statistics_minutes"`
+
+	// domain names and wildcard patterns which requests aren't counted in statistics
+	StatsIgnoredDomains []string `yaml:"statistics_ignored_domains"`
+
+	// IP addresses and subnets of the clients which requests aren't counted in statistics
+	StatsIgnoredClients []string `yaml:"statistics_ignored_clients"`
+
 	QueryLogEnabled     bool   `yaml:"querylog_enabled"`      // if true, query log is enabled
 	QueryLogFileEnabled bool   `yaml:"querylog_file_enabled"` // if true, query log will be written to a file
 	QueryLogInterval    uint32 `yaml:"querylog_interval"`     // time interval for query log (in days)
 	QueryLogMemSize     uint32 `yaml:"querylog_size_memory"`  // number of entries kept in memory before they are flushed to disk
+	QueryLogIndex       bool   `yaml:"querylog_index"`        // if true, query log is stored in a database with indexes
+	QueryLogMaxSize     uint32 `yaml:"querylog_max_size"`     // max size of all query log files (in MB), 0: unlimited
+	QueryLogCompress    bool   `yaml:"querylog_compress"`     // if true, rotated query log files are compressed
 	AnonymizeClientIP   bool   `yaml:"anonymize_client_ip"`   // anonymize clients' IP addresses in logs and stats
 
+	QueryLogIgnoredDomains []string `yaml:"querylog_ignored_domains"` // domain names and wildcard patterns which requests aren't logged
+	QueryLogIgnoredClients []string `yaml:"querylog_ignored_clients"` // IP addresses and subnets of the clients which requests aren't logged
+
+	// remote destinations for query log entries
+	QueryLogSinks []querylog.SinkConfig `yaml:"querylog_sinks"`
+
 	dnsforward.FilteringConfig `yaml:",inline"`
 
 	FilteringEnabled           bool             `yaml:"filtering_enabled"`       // whether or not use filter lists
@@ -135,6 +156,9 @@ var config = configuration{
 	DHCP: dhcpd.ServerConfig{
 		LeaseDuration: 86400,
 		ICMPTimeout:   1000,
+		HistoryDays:   90,
+
+		RogueCheckInterval: 10,
 	},
 	logSettings: logSettings{
 		LogCompress:   false,
@@ -212,6 +236,12 @@ func parseConfig() error {
 		config.DNS.FiltersUpdateIntervalHours = 24
 	}
 
+	config.DNS.LocalDomainName, err = dnsforward.CheckLocalDomainName(config.DNS.LocalDomainName)
+	if err != nil {
+		log.Error("Invalid local_domain_name: %s", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -250,6 +280,10 @@ func (c *configuration) write() error {
 		sdc := stats.DiskConfig{}
 		Context.stats.WriteDiskConfig(&sdc)
 		config.DNS.StatsInterval = sdc.Interval
+		config.DNS.StatsPerClient = sdc.PerClient
+		config.DNS.StatsMinutes = sdc.MinuteUnits
+		config.DNS.StatsIgnoredDomains = sdc.IgnoredDomains
+		config.DNS.StatsIgnoredClients = sdc.IgnoredClients
 	}
 
 	if Context.queryLog != nil {
@@ -259,6 +293,12 @@ func (c *configuration) write() error {
 		config.DNS.QueryLogFileEnabled = dc.FileEnabled
 		config.DNS.QueryLogInterval = dc.Interval
 		config.DNS.QueryLogMemSize = dc.MemSize
+		config.DNS.QueryLogIndex = dc.IndexEnabled
+		config.DNS.QueryLogMaxSize = dc.MaxSize
+		config.DNS.QueryLogCompress = dc.Compress
+		config.DNS.QueryLogSinks = dc.Sinks
+		config.DNS.QueryLogIgnoredDomains = dc.IgnoredDomains
+		config.DNS.QueryLogIgnoredClients = dc.IgnoredClients
 		config.DNS.AnonymizeClientIP = dc.AnonymizeClientIP
 	}
 
@@ -1,6 +1,17 @@
 package home
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
 	"github.com/joomcode/errorx"
 )
 
@@ -34,3 +45,87 @@ func stopDHCPServer() error {
 
 	return nil
 }
+
+// Import DHCP leases from the file (--dhcp-import) and print the report
+// Return the process exit code
+func importDHCPLeases(args options) int {
+	if !args.dhcpImportDryRun {
+		// the running instance would overwrite the leases DB with its own leases
+		err := checkRunningInstance(args)
+		if err != nil {
+			log.Error("DHCP import: %s", err)
+			log.Error("DHCP import: stop AdGuard Home or use /control/dhcp/import API")
+			return 1
+		}
+	}
+
+	data, err := ioutil.ReadFile(args.dhcpImport)
+	if err != nil {
+		log.Error("DHCP import: %s", err)
+		return 1
+	}
+
+	report, err := Context.dhcpServer.ImportLeases(args.dhcpImportFormat, string(data), args.dhcpImportDryRun)
+	if err != nil {
+		log.Error("DHCP import: %s", err)
+		return 1
+	}
+
+	for _, r := range report.Added {
+		fmt.Printf("added: line %d: %s %s %s\n", r.Line, r.MAC, r.IP, r.Hostname)
+	}
+	for _, r := range report.Skipped {
+		fmt.Printf("skipped: line %d: %s %s %s: %s\n", r.Line, r.MAC, r.IP, r.Hostname, r.Reason)
+	}
+	if report.DryRun {
+		fmt.Printf("%d leases would be added, %d skipped (dry run)\n", len(report.Added), len(report.Skipped))
+	} else {
+		fmt.Printf("%d leases added, %d skipped\n", len(report.Added), len(report.Skipped))
+	}
+	return 0
+}
+
+// Check if another instance of AdGuard Home is running:
+// its PID file points to a live process or its web interface port is in use
+func checkRunningInstance(args options) error {
+	pidFiles := []string{fmt.Sprintf("/var/run/%s.pid", serviceName)}
+	if len(args.pidFile) != 0 {
+		pidFiles = append(pidFiles, args.pidFile)
+	}
+	for _, fn := range pidFiles {
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		// signal 0 only checks that the process exists
+		if util.SendProcessSignal(pid, syscall.Signal(0)) == nil {
+			return fmt.Errorf("AdGuard Home is running: PID %d from %s", pid, fn)
+		}
+	}
+
+	if Context.firstRun {
+		return nil
+	}
+	host := config.BindHost
+	if args.bindHost != "" {
+		host = args.bindHost
+	}
+	port := config.BindPort
+	if args.bindPort != 0 {
+		port = args.bindPort
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("AdGuard Home may be running: web interface port %d is in use", port)
+	}
+	return nil
+}
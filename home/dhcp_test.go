@@ -0,0 +1,52 @@
+package home
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRunningInstance(t *testing.T) {
+	firstRun := Context.firstRun
+	bindHost := config.BindHost
+	bindPort := config.BindPort
+	defer func() {
+		Context.firstRun = firstRun
+		config.BindHost = bindHost
+		config.BindPort = bindPort
+	}()
+
+	f, err := ioutil.TempFile("", "pid")
+	assert.Nil(t, err)
+	defer func() { _ = os.Remove(f.Name()) }()
+	_ = f.Close()
+	args := options{pidFile: f.Name()}
+
+	// our own PID is ignored
+	Context.firstRun = true
+	assert.Nil(t, ioutil.WriteFile(f.Name(), []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644))
+	assert.Nil(t, checkRunningInstance(args))
+
+	// the process is running
+	if runtime.GOOS != "windows" {
+		assert.Nil(t, ioutil.WriteFile(f.Name(), []byte(fmt.Sprintf("%d\n", os.Getppid())), 0644))
+		assert.NotNil(t, checkRunningInstance(args))
+	}
+
+	// the web interface port is in use
+	assert.Nil(t, os.Remove(f.Name()))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	Context.firstRun = false
+	config.BindHost = "0.0.0.0"
+	config.BindPort = ln.Addr().(*net.TCPAddr).Port
+	assert.NotNil(t, checkRunningInstance(args))
+
+	_ = ln.Close()
+	assert.Nil(t, checkRunningInstance(args))
+}
@@ -31,6 +31,10 @@ func initDNSServer() error {
 		Filename:          filepath.Join(baseDir, "stats.db"),
 		LimitDays:         config.DNS.StatsInterval,
 		AnonymizeClientIP: config.DNS.AnonymizeClientIP,
+		PerClient:         config.DNS.StatsPerClient,
+		MinuteUnits:       config.DNS.StatsMinutes,
+		IgnoredDomains:    config.DNS.StatsIgnoredDomains,
+		IgnoredClients:    config.DNS.StatsIgnoredClients,
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 	}
@@ -44,7 +48,13 @@ func initDNSServer() error {
 		BaseDir:           baseDir,
 		Interval:          config.DNS.QueryLogInterval,
 		MemSize:           config.DNS.QueryLogMemSize,
+		IndexEnabled:      config.DNS.QueryLogIndex,
+		MaxSize:           config.DNS.QueryLogMaxSize,
+		Compress:          config.DNS.QueryLogCompress,
 		AnonymizeClientIP: config.DNS.AnonymizeClientIP,
+		Sinks:             config.DNS.QueryLogSinks,
+		IgnoredDomains:    config.DNS.QueryLogIgnoredDomains,
+		IgnoredClients:    config.DNS.QueryLogIgnoredClients,
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 	}
@@ -183,6 +193,7 @@ func generateServerConfig() dnsforward.ServerConfig {
 
 	newconfig.FilterHandler = applyAdditionalFiltering
 	newconfig.GetCustomUpstreamByClient = Context.clients.FindUpstreams
+	newconfig.GetClientLogSettings = Context.clients.FindLogSettings
 	return newconfig
 }
 
@@ -239,6 +250,11 @@ func applyAdditionalFiltering(clientAddr string, setts *dnsfilter.RequestFilteri
 
 	c, ok := Context.clients.Find(clientAddr)
 	if !ok {
+		// use the tags guessed by DHCP fingerprint for auto-clients
+		ch, ok := Context.clients.FindAutoClient(clientAddr)
+		if ok {
+			setts.ClientTags = ch.Tags
+		}
 		return
 	}
 
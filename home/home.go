@@ -219,6 +219,9 @@ func run(args options) {
 		log.Error("Failed to initialize DHCP server, exiting")
 		os.Exit(1)
 	}
+	if len(args.dhcpImport) != 0 {
+		os.Exit(importDHCPLeases(args))
+	}
 	Context.autoHosts.Init("")
 	Context.clients.Init(config.Clients, Context.dhcpServer, &Context.autoHosts)
 	config.Clients = nil
@@ -520,6 +523,10 @@ type options struct {
 	checkConfig    bool   // Check configuration and exit
 	disableUpdate  bool   // If set, don't check for updates
 
+	dhcpImport       string // Import DHCP leases from this file and exit
+	dhcpImportFormat string // Format of the file to import: "dnsmasq", "isc" or "" (detect by the data)
+	dhcpImportDryRun bool   // Don't change the lease table, just print the report
+
 	// service control action (see service.ControlAction array + "status" command)
 	serviceControlAction string
 
@@ -558,6 +565,13 @@ func loadOptions() options {
 		{"pidfile", "", "Path to a file where PID is stored", func(value string) { o.pidFile = value }, nil},
 		{"check-config", "", "Check configuration and exit", nil, func() { o.checkConfig = true }},
 		{"no-check-update", "", "Don't check for updates", nil, func() { o.disableUpdate = true }},
+		{"dhcp-import", "", "Import DHCP leases from dnsmasq or ISC dhcpd configuration or lease file and exit (AdGuard Home must not be running)", func(value string) {
+			o.dhcpImport = value
+		}, nil},
+		{"dhcp-import-format", "", "Format of the file to import: dnsmasq, isc (default: detect by the data)", func(value string) {
+			o.dhcpImportFormat = value
+		}, nil},
+		{"dhcp-import-dry-run", "", "Don't import DHCP leases, just print the report", nil, func() { o.dhcpImportDryRun = true }},
 		{"verbose", "v", "Enable verbose output", nil, func() { o.verbose = true }},
 		{"version", "", "Show the version and exit", nil, func() {
 			fmt.Printf("AdGuardHome %s\n", versionString)
@@ -0,0 +1,734 @@
+package querylog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	queryLogIndexFileName = "querylog.db"
+
+	// how often the old entries are removed
+	indexCleanupInterval = time.Hour
+
+	// max number of entries processed in 1 read transaction
+	// long-running transactions would block the writers
+	indexChunkSize = 1000
+
+	// max number of keys collected from the domain and client indexes
+	// if there are more keys, the search is performed by scanning all entries
+	maxIndexKeys = 1000000
+
+	// max number of entries written in 1 transaction during import
+	indexImportBatch = 10000
+)
+
+var (
+	bucketEntries = []byte("entries")
+	bucketDomains = []byte("domains")
+	bucketClients = []byte("clients")
+)
+
+// qlogIndex - query log storage with indexes by domain name and client IP
+// Buckets:
+//  "entries": key -> log entry (JSON, the same format as in file)
+//  "domains": domain name -> bucket with the keys of entries
+//  "clients": client IP -> bucket with the keys of entries
+// Key: timestamp (8 bytes, big-endian nanoseconds) + sequence number (4 bytes),
+//  so the entries are sorted by time.
+type qlogIndex struct {
+	db   *bolt.DB
+	lock sync.RWMutex // protects "db": it's replaced when the file is compacted
+	seq  uint32       // atomic
+
+	compactedSize int64 // the file size after the last compaction
+}
+
+// Open (or create) the database
+// Return true if the database has just been created
+func openIndex(filename string) (*qlogIndex, bool, error) {
+	db, err := bolt.Open(filename, 0644, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	created := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		created = tx.Bucket(bucketEntries) == nil
+		for _, name := range [][]byte{bucketEntries, bucketDomains, bucketClients} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, false, err
+	}
+
+	return &qlogIndex{db: db}, created, nil
+}
+
+func (x *qlogIndex) close() {
+	x.lock.Lock()
+	_ = x.db.Close()
+	x.lock.Unlock()
+}
+
+func (x *qlogIndex) view(fn func(tx *bolt.Tx) error) error {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	return x.db.View(fn)
+}
+
+func (x *qlogIndex) update(fn func(tx *bolt.Tx) error) error {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	return x.db.Update(fn)
+}
+
+func (x *qlogIndex) newKey(t time.Time) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(key[8:], atomic.AddUint32(&x.seq, 1))
+	return key
+}
+
+func keyTime(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key))
+}
+
+// Add a reference to the entry into a nested bucket of the index
+func addIndexRef(index *bolt.Bucket, name string, key []byte) error {
+	if len(name) == 0 {
+		return nil
+	}
+	b, err := index.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	return b.Put(key, nil)
+}
+
+// Remove a reference to the entry from the index, remove the nested bucket if it becomes empty
+func deleteIndexRef(index *bolt.Bucket, name string, key []byte) error {
+	if len(name) == 0 {
+		return nil
+	}
+	b := index.Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	err := b.Delete(key)
+	if err != nil {
+		return err
+	}
+	k, _ := b.Cursor().First()
+	if k == nil {
+		return index.DeleteBucket([]byte(name))
+	}
+	return nil
+}
+
+// Store the entries
+func (x *qlogIndex) add(entries []*logEntry) error {
+	return x.update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket(bucketEntries)
+		db := tx.Bucket(bucketDomains)
+		cb := tx.Bucket(bucketClients)
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			key := x.newKey(entry.Time)
+			err = eb.Put(key, data)
+			if err == nil {
+				err = addIndexRef(db, entry.QHost, key)
+			}
+			if err == nil {
+				err = addIndexRef(cb, entry.IP, key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Remove the entries older than the specified time
+func (x *qlogIndex) deleteOlder(t time.Time) (int, error) {
+	return x.deleteEntries(t.UnixNano(), 0)
+}
+
+// Remove the oldest entries: all entries older than minNano, but at least "count" entries
+func (x *qlogIndex) deleteEntries(minNano int64, count int) (int, error) {
+	n := 0
+	for {
+		// remove in chunks so that the transactions are short
+		nChunk := 0
+		err := x.update(func(tx *bolt.Tx) error {
+			eb := tx.Bucket(bucketEntries)
+			db := tx.Bucket(bucketDomains)
+			cb := tx.Bucket(bucketClients)
+			c := eb.Cursor()
+			for k, v := c.First(); k != nil && (keyTime(k) < minNano || n+nChunk < count) && nChunk != indexChunkSize; k, v = c.First() {
+				line := string(v)
+				err := deleteIndexRef(db, readJSONValue(line, "QH"), k)
+				if err == nil {
+					err = deleteIndexRef(cb, readJSONValue(line, "IP"), k)
+				}
+				if err == nil {
+					err = c.Delete()
+				}
+				if err != nil {
+					return err
+				}
+				nChunk++
+			}
+			return nil
+		})
+		n += nChunk
+		if err != nil {
+			return n, err
+		}
+		if nChunk != indexChunkSize {
+			return n, nil
+		}
+	}
+}
+
+// Get the size of the stored data (in bytes) and the number of entries
+func (x *qlogIndex) size() (int64, int, error) {
+	var size int64
+	count := 0
+	err := x.view(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketEntries, bucketDomains, bucketClients} {
+			st := tx.Bucket(name).Stats()
+			size += int64(st.BranchInuse + st.LeafInuse)
+			if string(name) == string(bucketEntries) {
+				count = st.KeyN
+			}
+		}
+		return nil
+	})
+	return size, count, err
+}
+
+// Keep the size of the database file within the limit:
+// remove the oldest entries while the size of the stored data exceeds the limit,
+// then compact the file if it's still larger than the limit.
+// The file never shrinks by itself: the pages of the removed entries are only reused for new entries.
+// Return the number of removed entries.
+func (x *qlogIndex) limitSize(maxSize int64) (int, error) {
+	n, err := x.limitDataSize(maxSize)
+	if err != nil {
+		return n, err
+	}
+
+	fsize, err := x.fileSize()
+	// don't compact the file again if it hasn't grown since the last compaction:
+	// bbolt allocates the file in steps, so the compacted file may be still larger than the limit
+	if err != nil || fsize <= maxSize || fsize <= x.compactedSize {
+		return n, err
+	}
+	err = x.compact()
+	if err != nil {
+		return n, err
+	}
+	x.compactedSize, err = x.fileSize()
+	log.Debug("querylog: index: compacted the file: %d -> %d bytes", fsize, x.compactedSize)
+	return n, err
+}
+
+// Remove the oldest entries while the size of the stored data exceeds the limit
+func (x *qlogIndex) limitDataSize(maxSize int64) (int, error) {
+	n := 0
+	for {
+		size, count, err := x.size()
+		if err != nil || size <= maxSize || count == 0 {
+			return n, err
+		}
+
+		// the number of entries to remove: estimated by the average size of an entry
+		excess := (size - maxSize) * int64(count) / size
+		nDel, err := x.deleteEntries(0, int(excess)+1)
+		n += nDel
+		if err != nil || nDel == 0 {
+			return n, err
+		}
+	}
+}
+
+// Get the size of the database file
+func (x *qlogIndex) fileSize() (int64, error) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	st, err := os.Stat(x.db.Path())
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+// Copy all data to a new file and replace the database with it
+func (x *qlogIndex) compact() error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	fn := x.db.Path()
+	tmp := fn + ".tmp"
+	_ = os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0644, nil)
+	if err != nil {
+		return err
+	}
+	c := indexCopier{dst: dst}
+	err = x.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return c.copyBucket(b, [][]byte{name})
+		})
+	})
+	if err == nil {
+		err = c.commit()
+	} else if c.tx != nil {
+		_ = c.tx.Rollback()
+	}
+	cerr := dst.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	_ = x.db.Close()
+	err = os.Rename(tmp, fn)
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	// reopen the database even if it hasn't been replaced
+	db, oerr := bolt.Open(fn, 0644, nil)
+	if oerr != nil {
+		return oerr
+	}
+	x.db = db
+	return err
+}
+
+// indexCopier - copies the buckets to another database in chunks
+type indexCopier struct {
+	dst *bolt.DB
+	tx  *bolt.Tx // the current write transaction
+	n   int      // the number of keys written in the current transaction
+}
+
+// Copy the keys and the nested buckets of the bucket
+// path: the names of the bucket and its parents
+func (c *indexCopier) copyBucket(b *bolt.Bucket, path [][]byte) error {
+	return b.ForEach(func(k, v []byte) error {
+		nested := b.Bucket(k)
+		if nested == nil {
+			return c.put(path, k, v)
+		}
+		p := append(append([][]byte{}, path...), k)
+		_, err := c.bucket(p)
+		if err != nil {
+			return err
+		}
+		return c.copyBucket(nested, p)
+	})
+}
+
+// Get (or create) the bucket in the current transaction
+func (c *indexCopier) bucket(path [][]byte) (*bolt.Bucket, error) {
+	if c.tx == nil {
+		var err error
+		c.tx, err = c.dst.Begin(true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			break
+		}
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the keys are added in order: fill the pages entirely
+	b.FillPercent = 1.0
+	return b, nil
+}
+
+func (c *indexCopier) put(path [][]byte, k, v []byte) error {
+	b, err := c.bucket(path)
+	if err != nil {
+		return err
+	}
+	err = b.Put(k, v)
+	if err != nil {
+		return err
+	}
+	c.n++
+	if c.n == indexImportBatch {
+		return c.commit()
+	}
+	return nil
+}
+
+func (c *indexCopier) commit() error {
+	if c.tx == nil {
+		return nil
+	}
+	err := c.tx.Commit()
+	c.tx = nil
+	c.n = 0
+	return err
+}
+
+// Remove all entries
+func (x *qlogIndex) clear() error {
+	return x.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketEntries, bucketDomains, bucketClients} {
+			err := tx.DeleteBucket(name)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get time range for the search: [min, max)
+func indexTimeRange(params *searchParams) (int64, int64) {
+	minNano := int64(0)
+	maxNano := int64(^uint64(0) >> 1)
+	if !params.olderThan.IsZero() {
+		maxNano = params.olderThan.UnixNano()
+	}
+	if !params.timeTo.IsZero() && params.timeTo.UnixNano()+1 < maxNano {
+		maxNano = params.timeTo.UnixNano() + 1
+	}
+	if !params.timeFrom.IsZero() {
+		minNano = params.timeFrom.UnixNano()
+	}
+	return minNano, maxNano
+}
+
+// Collect the keys of the entries from the nested buckets of the index matching the criteria
+// Return false if there are too many keys
+func collectIndexKeys(index *bolt.Bucket, c searchCriteria, minNano, maxNano int64, keys map[string]bool) bool {
+	collect := func(b *bolt.Bucket) bool {
+		cur := b.Cursor()
+		k, _ := cur.Seek(timeKey(minNano))
+		for ; k != nil && keyTime(k) < maxNano; k, _ = cur.Next() {
+			keys[string(k)] = true
+			if len(keys) > maxIndexKeys {
+				return false
+			}
+		}
+		return true
+	}
+
+	if c.strict {
+		b := index.Bucket([]byte(c.value))
+		if b == nil {
+			return true
+		}
+		return collect(b)
+	}
+
+	cur := index.Cursor()
+	for name, v := cur.First(); name != nil; name, v = cur.Next() {
+		if v != nil || !strings.Contains(string(name), c.value) {
+			continue
+		}
+		if !collect(index.Bucket(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the first key with this timestamp
+func timeKey(nano int64) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, uint64(nano))
+	return key
+}
+
+// Decode the entry and check if it matches the search criteria
+func indexMatch(params *searchParams, data []byte) *logEntry {
+	line := string(data)
+	if !params.quickMatch(line) {
+		return nil
+	}
+	entry := logEntry{}
+	decodeLogEntry(&entry, line)
+	if !params.match(&entry) {
+		return nil
+	}
+	return &entry
+}
+
+// search - goes through the entries (from newer to older) that match the search parameters
+// the callback returns false to stop the search
+func (x *qlogIndex) search(params *searchParams, cb func(entry *logEntry) bool) error {
+	minNano, maxNano := indexTimeRange(params)
+	if minNano >= maxNano {
+		return nil
+	}
+
+	// use the domain and client indexes if possible
+	for _, c := range params.searchCriteria {
+		if c.criteriaType != ctDomainOrClient {
+			continue
+		}
+
+		keys := map[string]bool{}
+		ok := true
+		err := x.view(func(tx *bolt.Tx) error {
+			ok = collectIndexKeys(tx.Bucket(bucketDomains), c, minNano, maxNano, keys) &&
+				collectIndexKeys(tx.Bucket(bucketClients), c, minNano, maxNano, keys)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Debug("querylog: index: too many entries match %s, scanning all entries", c.value)
+			break
+		}
+		return x.searchKeys(params, keys, cb)
+	}
+
+	return x.scan(params, minNano, maxNano, cb)
+}
+
+// Get the entries by their keys
+func (x *qlogIndex) searchKeys(params *searchParams, keySet map[string]bool, cb func(entry *logEntry) bool) error {
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	// newer entries first
+	sort.Slice(keys, func(i, j int) bool { return keys[i] > keys[j] })
+
+	for len(keys) != 0 {
+		n := len(keys)
+		if n > indexChunkSize {
+			n = indexChunkSize
+		}
+		var entries []*logEntry
+		err := x.view(func(tx *bolt.Tx) error {
+			eb := tx.Bucket(bucketEntries)
+			for _, k := range keys[:n] {
+				v := eb.Get([]byte(k))
+				if v == nil {
+					continue
+				}
+				entry := indexMatch(params, v)
+				if entry != nil {
+					entries = append(entries, entry)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+
+		for _, entry := range entries {
+			if !cb(entry) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Scan all entries in the time range
+func (x *qlogIndex) scan(params *searchParams, minNano, maxNano int64, cb func(entry *logEntry) bool) error {
+	next := timeKey(maxNano) // the first key that isn't processed yet
+	for {
+		var entries []*logEntry
+		done := true
+		err := x.view(func(tx *bolt.Tx) error {
+			cur := tx.Bucket(bucketEntries).Cursor()
+			k, v := cur.Seek(next)
+			if k == nil {
+				k, v = cur.Last()
+			} else {
+				k, v = cur.Prev()
+			}
+
+			for n := 0; k != nil && keyTime(k) >= minNano; k, v = cur.Prev() {
+				if n == indexChunkSize {
+					next = append([]byte{}, k...)
+					// continue from this key in the next transaction
+					next = append(next, 0)
+					done = false
+					break
+				}
+				n++
+				entry := indexMatch(params, v)
+				if entry != nil {
+					entries = append(entries, entry)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if !cb(entry) {
+				return nil
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// Import log entries from the query log files
+func (x *qlogIndex) importFiles(r *QLogReader) (int, error) {
+	var entries []*logEntry
+	n := 0
+	for {
+		line, err := r.ReadNext()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+
+		entry := logEntry{}
+		decodeLogEntry(&entry, line)
+		if entry.Time.IsZero() || len(entry.QHost) == 0 {
+			continue
+		}
+		entries = append(entries, &entry)
+		if len(entries) == indexImportBatch {
+			err = x.add(entries)
+			if err != nil {
+				return n, err
+			}
+			n += len(entries)
+			entries = nil
+		}
+	}
+
+	err := x.add(entries)
+	if err != nil {
+		return n, err
+	}
+	return n + len(entries), nil
+}
+
+// Open the database; import the entries from the query log files if the database has just been created
+func (l *queryLog) initIndex() {
+	fn := filepath.Join(l.conf.BaseDir, queryLogIndexFileName)
+	index, created, err := openIndex(fn)
+	if err != nil {
+		log.Error("querylog: index: open %s: %s", fn, err)
+		return
+	}
+	l.index = index
+
+	if !created {
+		return
+	}
+	r, err := l.openReader()
+	if err != nil {
+		log.Error("querylog: index: %s", err)
+		return
+	}
+	defer r.Close()
+	if r.SeekStart() != nil {
+		return // there are no files
+	}
+	n, err := index.importFiles(r)
+	if err != nil {
+		log.Error("querylog: index: import: %s", err)
+	}
+	if n == 0 {
+		return
+	}
+	log.Info("querylog: index: imported %d entries from the query log files", n)
+}
+
+// searchIndex - searches log entries in the database
+// Returns the same values as searchFiles(), but "oldest" is not set if there are no more entries.
+func (l *queryLog) searchIndex(params *searchParams) ([]*logEntry, time.Time, int) {
+	entries := make([]*logEntry, 0)
+	totalLimit := params.offset + params.limit
+
+	err := l.index.search(params, func(entry *logEntry) bool {
+		entries = append(entries, entry)
+		return len(entries) != totalLimit
+	})
+	if err != nil {
+		log.Error("querylog: index: %s", err)
+	}
+
+	oldest := time.Time{}
+	if len(entries) == totalLimit {
+		oldest = entries[len(entries)-1].Time
+	}
+	return entries, oldest, len(entries)
+}
+
+// Periodically remove the old entries
+func (l *queryLog) periodicIndexCleanup() {
+	for range time.Tick(indexCleanupInterval) {
+		l.indexCleanup()
+	}
+}
+
+// Remove the entries older than the configured interval,
+// and the oldest entries while the size of the database exceeds the size limit
+func (l *queryLog) indexCleanup() {
+	t := time.Now().Add(-time.Duration(l.conf.Interval) * 24 * time.Hour)
+	n, err := l.index.deleteOlder(t)
+	if err != nil {
+		log.Error("querylog: index: cleanup: %s", err)
+	}
+	log.Debug("querylog: index: removed %d old entries", n)
+
+	if l.conf.MaxSize == 0 {
+		return
+	}
+	n, err = l.index.limitSize(int64(l.conf.MaxSize) * 1024 * 1024)
+	if err != nil {
+		log.Error("querylog: index: cleanup: %s", err)
+	}
+	log.Debug("querylog: index: removed %d entries out of the size limit", n)
+}
@@ -0,0 +1,173 @@
+package querylog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func searchHosts(l *queryLog, c ...searchCriteria) []string {
+	params := newSearchParams()
+	params.searchCriteria = c
+	entries, _ := l.search(params)
+	hosts := []string{}
+	for _, e := range entries {
+		hosts = append(hosts, e.QHost)
+	}
+	return hosts
+}
+
+func TestQueryLogIndex(t *testing.T) {
+	conf := Config{
+		Enabled:      true,
+		FileEnabled:  true,
+		IndexEnabled: true,
+		Interval:     1,
+		MemSize:      100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	assert.NotNil(t, l.index)
+	defer l.index.close()
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "test.example.org", "1.1.1.2", "2.2.2.2")
+	addEntry(l, "example.com", "1.1.1.3", "2.2.2.1")
+	_ = l.flushLogBuffer(true)
+	addEntry(l, "example.net", "1.1.1.4", "2.2.2.3")
+
+	// the entries are stored in the database, not in the file
+	assert.False(t, util.FileExists(l.logFile))
+
+	assert.Equal(t, []string{"example.net", "example.com", "test.example.org", "example.org"}, searchHosts(l))
+
+	// domain: strict and substring
+	assert.Equal(t, []string{"example.org"},
+		searchHosts(l, searchCriteria{criteriaType: ctDomainOrClient, strict: true, value: "example.org"}))
+	assert.Equal(t, []string{"test.example.org", "example.org"},
+		searchHosts(l, searchCriteria{criteriaType: ctDomainOrClient, value: "example.org"}))
+
+	// client
+	assert.Equal(t, []string{"example.com", "example.org"},
+		searchHosts(l, searchCriteria{criteriaType: ctDomainOrClient, strict: true, value: "2.2.2.1"}))
+
+	// combined with other criteria
+	assert.Equal(t, []string{"example.org"},
+		searchHosts(l,
+			searchCriteria{criteriaType: ctDomainOrClient, value: "2.2.2.1"},
+			searchCriteria{criteriaType: ctDomainOrClient, value: ".org"}))
+
+	// time range
+	params := newSearchParams()
+	params.timeFrom = time.Now().Add(time.Hour)
+	entries, _ := l.search(params)
+	assert.Equal(t, 0, len(entries))
+
+	// paging
+	params = newSearchParams()
+	params.limit = 2
+	entries, oldest := l.search(params)
+	assert.Equal(t, 2, len(entries))
+	params.olderThan = oldest
+	entries, _ = l.search(params)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "test.example.org", entries[0].QHost)
+	assert.Equal(t, "example.org", entries[1].QHost)
+
+	// remove old entries
+	n, err := l.index.deleteOlder(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"example.net"}, searchHosts(l))
+	assert.Equal(t, []string{},
+		searchHosts(l, searchCriteria{criteriaType: ctDomainOrClient, value: ".org"}))
+}
+
+// Check that the entries from the query log files are imported into the new database
+func TestQueryLogIndexImport(t *testing.T) {
+	conf := Config{
+		Enabled:     true,
+		FileEnabled: true,
+		Interval:    1,
+		MemSize:     100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example.com", "1.1.1.2", "2.2.2.2")
+	_ = l.flushLogBuffer(true)
+
+	conf.IndexEnabled = true
+	l = newQueryLog(conf)
+	assert.NotNil(t, l.index)
+	defer l.index.close()
+
+	assert.Equal(t, []string{"example.com", "example.org"}, searchHosts(l))
+	assert.Equal(t, []string{"example.com"},
+		searchHosts(l, searchCriteria{criteriaType: ctDomainOrClient, strict: true, value: "2.2.2.2"}))
+}
+
+// Check that the index and the old files are limited by time and size
+func TestQueryLogIndexCleanup(t *testing.T) {
+	conf := Config{
+		Enabled:      true,
+		FileEnabled:  true,
+		IndexEnabled: true,
+		Interval:     1,
+		MemSize:      100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	assert.NotNil(t, l.index)
+	defer l.index.close()
+
+	for i := 0; i < 5000; i++ {
+		addEntry(l, fmt.Sprintf("host%d.example.org", i), "1.1.1.1", fmt.Sprintf("2.2.%d.%d", i/256, i%256))
+	}
+	_ = l.flushLogBuffer(true)
+	size, count, err := l.index.size()
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, count)
+	fsize, err := l.index.fileSize()
+	assert.Nil(t, err)
+
+	// the oldest entries are removed while the data is out of the size limit,
+	// then the file is compacted
+	n, err := l.index.limitSize(size / 4)
+	assert.Nil(t, err)
+	assert.True(t, n > 0 && n < 5000)
+	newSize, count, err := l.index.size()
+	assert.Nil(t, err)
+	assert.True(t, newSize <= size/4)
+	assert.Equal(t, 5000-n, count)
+	newFileSize, err := l.index.fileSize()
+	assert.Nil(t, err)
+	assert.True(t, newFileSize < fsize, "%d %d", newFileSize, fsize)
+	assert.Equal(t, newFileSize, l.index.compactedSize)
+	hosts := searchHosts(l)
+	assert.Equal(t, "host4999.example.org", hosts[0])
+
+	// the database works as usual after compaction
+	addEntry(l, "host5000.example.org", "1.1.1.1", "2.2.2.2")
+	_ = l.flushLogBuffer(true)
+	assert.Equal(t, "host5000.example.org", searchHosts(l)[0])
+
+	n, err = l.index.limitSize(size)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// the files which have been left from the time the index was disabled are removed
+	assert.Nil(t, ioutil.WriteFile(l.segmentName(1, false), []byte("{}\n"), 0644))
+	tm := time.Now().Add(-48 * time.Hour)
+	assert.Nil(t, os.Chtimes(l.segmentName(1, false), tm, tm))
+	assert.Nil(t, l.rotate())
+	assert.Equal(t, 0, len(l.segments()))
+}
@@ -13,22 +13,28 @@ import (
 // Get Client IP address
 func (l *queryLog) getClientIP(clientIP string) string {
 	if l.conf.AnonymizeClientIP {
-		ip := net.ParseIP(clientIP)
-		if ip != nil {
-			ip4 := ip.To4()
-			const AnonymizeClientIP4Mask = 16
-			const AnonymizeClientIP6Mask = 112
-			if ip4 != nil {
-				clientIP = ip4.Mask(net.CIDRMask(AnonymizeClientIP4Mask, 32)).String()
-			} else {
-				clientIP = ip.Mask(net.CIDRMask(AnonymizeClientIP6Mask, 128)).String()
-			}
-		}
+		clientIP = anonymizeIP(clientIP)
 	}
 
 	return clientIP
 }
 
+// anonymizeIP - mask the last bytes of the IP address
+func anonymizeIP(clientIP string) string {
+	ip := net.ParseIP(clientIP)
+	if ip != nil {
+		ip4 := ip.To4()
+		const AnonymizeClientIP4Mask = 16
+		const AnonymizeClientIP6Mask = 112
+		if ip4 != nil {
+			clientIP = ip4.Mask(net.CIDRMask(AnonymizeClientIP4Mask, 32)).String()
+		} else {
+			clientIP = ip.Mask(net.CIDRMask(AnonymizeClientIP6Mask, 128)).String()
+		}
+	}
+	return clientIP
+}
+
 // entriesToJSON - converts log entries to JSON
 func (l *queryLog) entriesToJSON(entries []*logEntry, oldest time.Time) map[string]interface{} {
 	// init the response object
@@ -8,12 +8,13 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
 )
 
 const (
-	queryLogFileName = "querylog.json" // .gz added during compression
+	queryLogFileName = "querylog.json" // .N.gz added during rotation
 )
 
 // queryLog is a structure that writes and reads the DNS query log
@@ -27,6 +28,14 @@ type queryLog struct {
 	fileFlushLock sync.Mutex // synchronize a file-flushing goroutine and main thread
 	flushPending  bool       // don't start another goroutine while the previous one is still running
 	fileWriteLock sync.Mutex
+
+	sinksLock sync.RWMutex
+	sinks     []sink // remote destinations for log entries
+
+	subscribersLock sync.Mutex
+	subscribers     map[*streamSubscriber]bool // clients that receive new log entries
+
+	index *qlogIndex // database with indexes (optional, used instead of the file)
 }
 
 // logEntry - represents a single log entry
@@ -57,6 +66,14 @@ func newQueryLog(conf Config) *queryLog {
 	if !checkInterval(l.conf.Interval) {
 		l.conf.Interval = 1
 	}
+	var err error
+	l.conf.ignored, err = util.NewIgnoreList(conf.IgnoredDomains, conf.IgnoredClients)
+	if err != nil {
+		log.Error("querylog: ignore list: %s", err)
+	}
+	if l.conf.FileEnabled && l.conf.IndexEnabled {
+		l.initIndex()
+	}
 	return &l
 }
 
@@ -64,11 +81,20 @@ func (l *queryLog) Start() {
 	if l.conf.HTTPRegister != nil {
 		l.initWeb()
 	}
+	l.initSinks()
+	if l.index != nil {
+		go l.periodicIndexCleanup()
+	}
+	// with the index, the files are left from the time it was disabled:  they must be removed too
 	go l.periodicRotate()
 }
 
 func (l *queryLog) Close() {
 	_ = l.flushLogBuffer(true)
+	l.closeSinks()
+	if l.index != nil {
+		l.index.close()
+	}
 }
 
 func checkInterval(days uint32) bool {
@@ -89,12 +115,24 @@ func (l *queryLog) clear() {
 	l.flushPending = false
 	l.bufferLock.Unlock()
 
-	err := os.Remove(l.logFile + ".1")
-	if err != nil && !os.IsNotExist(err) {
-		log.Error("file remove: %s: %s", l.logFile+".1", err)
+	if l.index != nil {
+		err := l.index.clear()
+		if err != nil {
+			log.Error("querylog: index: clear: %s", err)
+		}
 	}
 
-	err = os.Remove(l.logFile)
+	l.fileWriteLock.Lock()
+	defer l.fileWriteLock.Unlock()
+
+	for _, name := range l.segments() {
+		err := os.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("file remove: %s: %s", name, err)
+		}
+	}
+
+	err := os.Remove(l.logFile)
 	if err != nil && !os.IsNotExist(err) {
 		log.Error("file remove: %s: %s", l.logFile, err)
 	}
@@ -112,6 +150,12 @@ func (l *queryLog) Add(params AddParams) {
 		return
 	}
 
+	q := params.Question.Question[0]
+	host := strings.ToLower(q.Name[:len(q.Name)-1]) // remove the last dot
+	if l.conf.ignored.Match(host, params.ClientIP) {
+		return
+	}
+
 	if params.Result == nil {
 		params.Result = &dnsfilter.Result{}
 	}
@@ -126,8 +170,7 @@ func (l *queryLog) Add(params AddParams) {
 		Upstream:    params.Upstream,
 		ClientProto: params.ClientProto,
 	}
-	q := params.Question.Question[0]
-	entry.QHost = strings.ToLower(q.Name[:len(q.Name)-1]) // remove the last dot
+	entry.QHost = host
 	entry.QType = dns.Type(q.Qtype).String()
 	entry.QClass = dns.Class(q.Qclass).String()
 
@@ -149,6 +192,13 @@ func (l *queryLog) Add(params AddParams) {
 		entry.OrigAnswer = a
 	}
 
+	l.sinksLock.RLock()
+	for _, s := range l.sinks {
+		s.send(&entry)
+	}
+	l.sinksLock.RUnlock()
+	l.publish(&entry)
+
 	l.bufferLock.Lock()
 	l.buffer = append(l.buffer, &entry)
 	needFlush := false
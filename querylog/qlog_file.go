@@ -3,6 +3,7 @@ package querylog
 import (
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,8 +29,8 @@ const bufferSize = 100 * maxEntrySize
 // Internally, it contains a pointer to a specific position in the file,
 // and it reads lines in reverse order starting from that position.
 type QLogFile struct {
-	file     *os.File // the query log file
-	position int64    // current position in the file
+	file     qlogSource // the query log file
+	position int64      // current position in the file
 
 	buffer      []byte // buffer that we've read from the file
 	bufferStart int64  // start of the buffer (in the file)
@@ -38,7 +39,29 @@ type QLogFile struct {
 	lock sync.Mutex // We use mutex to make it thread-safe
 }
 
+// qlogSource - the data of a query log file
+type qlogSource interface {
+	io.ReadSeeker
+	io.Closer
+	Name() string
+	fileSize() (int64, error)
+}
+
+// plainSource - uncompressed query log file
+type plainSource struct {
+	*os.File
+}
+
+func (s plainSource) fileSize() (int64, error) {
+	fileInfo, err := s.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Size(), nil
+}
+
 // NewQLogFile initializes a new instance of the QLogFile
+// Files with ".gz" extension are decompressed transparently, block by block while they're read
 func NewQLogFile(path string) (*QLogFile, error) {
 	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
 
@@ -46,8 +69,14 @@ func NewQLogFile(path string) (*QLogFile, error) {
 		return nil, err
 	}
 
+	if strings.HasSuffix(path, compressedExt) {
+		return &QLogFile{
+			file: newGzipSource(f),
+		}, nil
+	}
+
 	return &QLogFile{
-		file: f,
+		file: plainSource{f},
 	}, nil
 }
 
@@ -76,14 +105,14 @@ func (q *QLogFile) Seek(timestamp int64) (int64, int, error) {
 	q.buffer = nil
 
 	// First of all, check the file size
-	fileInfo, err := q.file.Stat()
+	size, err := q.file.fileSize()
 	if err != nil {
 		return 0, 0, err
 	}
 
 	// Define the search scope
 	start := int64(0)          // start of the search interval (position in the file)
-	end := fileInfo.Size()     // end of the search interval (position in the file)
+	end := size                // end of the search interval (position in the file)
 	probe := (end - start) / 2 // probe -- approximate index of the line we'll try to check
 	var line string
 	var lineIdx int64          // index of the probe line in the file
@@ -145,6 +174,69 @@ func (q *QLogFile) Seek(timestamp int64) (int64, int, error) {
 	return q.position, depth, nil
 }
 
+// SeekOlder sets "position" so that the next ReadNext calls returned the records
+// older than the specified timestamp.
+// Unlike Seek, there doesn't have to be a record with this timestamp:
+// the binary search stops at a record which isn't older than the timestamp,
+// so a few newer records may be returned first and the caller has to skip them.
+//
+// Returns ErrSeekNotFound if there are no older records in the file.
+func (q *QLogFile) SeekOlder(timestamp int64) (int64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Empty the buffer
+	q.buffer = nil
+
+	size, err := q.file.fileSize()
+	if err != nil {
+		return 0, err
+	}
+
+	// Check the oldest record first: maybe there's nothing to look for in this file
+	line, _, err := q.readProbeLine(0)
+	if err != nil {
+		return 0, err
+	}
+	ts := readQLogTimestamp(line)
+	if ts == 0 || ts >= timestamp {
+		return 0, ErrSeekNotFound
+	}
+
+	start := int64(0)
+	end := size
+	position := size - 1 // the end of the file, just like SeekStart does
+	lastProbeLineIdx := int64(-1)
+	for depth := 0; depth < 100; depth++ {
+		probe := start + (end-start)/2
+		line, lineIdx, err := q.readProbeLine(probe)
+		if err != nil {
+			return 0, err
+		}
+		if lastProbeLineIdx == lineIdx {
+			// the scope is too narrow, the last record which isn't older is close enough
+			break
+		}
+		lastProbeLineIdx = lineIdx
+
+		ts = readQLogTimestamp(line)
+		if ts == 0 {
+			return 0, ErrSeekNotFound
+		}
+
+		if ts >= timestamp {
+			// all older records are before this one
+			position = lineIdx + int64(len(line))
+			end = probe
+		} else {
+			start = probe
+		}
+	}
+
+	q.position = position
+	return q.position, nil
+}
+
 // SeekStart changes the current position to the end of the file
 // Please note that we're reading query log in the reverse order
 // and that's why log start is actually the end of file
@@ -159,13 +251,13 @@ func (q *QLogFile) SeekStart() (int64, error) {
 	q.buffer = nil
 
 	// First of all, check the file size
-	fileInfo, err := q.file.Stat()
+	size, err := q.file.fileSize()
 	if err != nil {
 		return 0, err
 	}
 
 	// Place the position to the very end of file
-	q.position = fileInfo.Size() - 1
+	q.position = size - 1
 	if q.position < 0 {
 		q.position = 0
 	}
@@ -0,0 +1,254 @@
+// Compressed query log files which can be read in the reverse order
+
+package querylog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// The compressed file is a sequence of gzip members (blocks), each contains gzipBlockSize bytes of data (or less).
+// The header of each member has an extra field with the sizes of the member and its data,
+// so we can find the block with the data we need without unpacking the whole file.
+// It's still a valid gzip file: gunzip unpacks all members one by one.
+//
+// Extra field: 'A', 'G', length (2 bytes: 8), member size (4 bytes), data size (4 bytes);  little-endian.
+const (
+	gzipBlockSize    = 64 * 1024
+	gzipExtraID1     = 'A'
+	gzipExtraID2     = 'G'
+	gzipExtraLen     = 12 // the length of the whole extra field
+	gzipHeaderLen    = 10 // the fixed part of gzip header
+	gzipExtraDataOff = gzipHeaderLen + 2 + 4
+	gzipFlagExtra    = 1 << 2
+)
+
+// gzipBlock - the position of a gzip member in the file and of its data
+type gzipBlock struct {
+	offset int64 // the position of the member in the file
+	size   int64 // the size of the member
+
+	start  int64 // the position of the data
+	length int64 // the size of the data
+}
+
+// gzipSource - compressed query log file
+// The blocks are unpacked one by one when they're read.
+type gzipSource struct {
+	f        *os.File
+	blocks   []gzipBlock // nil: the file isn't indexed yet
+	position int64       // the position in the unpacked data
+
+	data    []byte // the data of the last unpacked block
+	dataIdx int    // the index of the last unpacked block;  -1: none
+}
+
+func newGzipSource(f *os.File) *gzipSource {
+	return &gzipSource{f: f, dataIdx: -1}
+}
+
+func (s *gzipSource) Name() string {
+	return s.f.Name()
+}
+
+func (s *gzipSource) Close() error {
+	return s.f.Close()
+}
+
+// Get the list of blocks from the headers of gzip members
+// A file without block headers (a single gzip stream) is one big block:
+// its data size is taken from the gzip trailer.
+func (s *gzipSource) index() error {
+	if s.blocks != nil {
+		return nil
+	}
+
+	st, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	fsize := st.Size()
+
+	blocks := []gzipBlock{}
+	start := int64(0)
+	hdr := make([]byte, gzipExtraDataOff+8)
+	for off := int64(0); off < fsize; {
+		n, err := s.f.ReadAt(hdr, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n < 2 || hdr[0] != 0x1f || hdr[1] != 0x8b {
+			return fmt.Errorf("%s: invalid gzip header at %d", s.Name(), off)
+		}
+
+		b := gzipBlock{offset: off, start: start}
+		if n == len(hdr) && hdr[3]&gzipFlagExtra != 0 &&
+			binary.LittleEndian.Uint16(hdr[gzipHeaderLen:]) == gzipExtraLen &&
+			hdr[gzipHeaderLen+2] == gzipExtraID1 && hdr[gzipHeaderLen+3] == gzipExtraID2 {
+			b.size = int64(binary.LittleEndian.Uint32(hdr[gzipExtraDataOff:]))
+			b.length = int64(binary.LittleEndian.Uint32(hdr[gzipExtraDataOff+4:]))
+			if b.size == 0 || off+b.size > fsize || b.length > gzipBlockSize {
+				return fmt.Errorf("%s: invalid block at %d", s.Name(), off)
+			}
+
+		} else if off == 0 {
+			// a single gzip stream: ISIZE field of the trailer has the data size
+			trailer := make([]byte, 4)
+			_, err = s.f.ReadAt(trailer, fsize-4)
+			if err != nil {
+				return err
+			}
+			b.size = fsize
+			b.length = int64(binary.LittleEndian.Uint32(trailer))
+
+		} else {
+			return fmt.Errorf("%s: invalid block at %d", s.Name(), off)
+		}
+
+		blocks = append(blocks, b)
+		off += b.size
+		start += b.length
+	}
+
+	s.blocks = blocks
+	return nil
+}
+
+func (s *gzipSource) fileSize() (int64, error) {
+	err := s.index()
+	if err != nil {
+		return 0, err
+	}
+	if len(s.blocks) == 0 {
+		return 0, nil
+	}
+	last := s.blocks[len(s.blocks)-1]
+	return last.start + last.length, nil
+}
+
+func (s *gzipSource) Seek(offset int64, whence int) (int64, error) {
+	size, err := s.fileSize()
+	if err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.position
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	s.position = offset
+	return offset, nil
+}
+
+// Read the data from the current position
+// Unlike the usual io.Reader, it fills the whole buffer unless the end of data is reached.
+func (s *gzipSource) Read(p []byte) (int, error) {
+	size, err := s.fileSize()
+	if err != nil {
+		return 0, err
+	}
+	if s.position >= size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && s.position < size {
+		i := sort.Search(len(s.blocks), func(i int) bool {
+			b := s.blocks[i]
+			return b.start+b.length > s.position
+		})
+		m, err := s.readBlock(i, p[n:], s.position-s.blocks[i].start)
+		n += m
+		s.position += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Read the data of the block starting with the offset "off"
+func (s *gzipSource) readBlock(i int, p []byte, off int64) (int, error) {
+	if s.dataIdx == i {
+		return copy(p, s.data[off:]), nil
+	}
+
+	b := s.blocks[i]
+	zr, err := gzip.NewReader(io.NewSectionReader(s.f, b.offset, b.size))
+	if err != nil {
+		return 0, err
+	}
+	zr.Multistream(false)
+
+	if b.length > gzipBlockSize {
+		// a single gzip stream: unpack the data up to the position every time, but don't keep it in memory
+		_, err = io.CopyN(ioutil.Discard, zr, off)
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(p)) > b.length-off {
+			p = p[:b.length-off]
+		}
+		return io.ReadFull(zr, p)
+	}
+
+	if s.data == nil {
+		s.data = make([]byte, gzipBlockSize)
+	}
+	s.dataIdx = -1
+	n, err := io.ReadFull(zr, s.data[:b.length])
+	if err != nil {
+		return 0, fmt.Errorf("%s: block at %d: %s", s.Name(), b.offset, err)
+	}
+	s.data = s.data[:n]
+	s.dataIdx = i
+	return copy(p, s.data[off:]), nil
+}
+
+// writeGzipBlocks compresses the data from "src" as a sequence of gzip members which can be read by gzipSource
+func writeGzipBlocks(dst io.Writer, src io.Reader) error {
+	data := make([]byte, gzipBlockSize)
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	for {
+		n, err := io.ReadFull(src, data)
+		if n == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		buf.Reset()
+		zw.Reset(buf)
+		// the sizes are set when the member is written
+		zw.Header.Extra = []byte{gzipExtraID1, gzipExtraID2, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		_, err = zw.Write(data[:n])
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		member := buf.Bytes()
+		binary.LittleEndian.PutUint32(member[gzipExtraDataOff:], uint32(len(member)))
+		binary.LittleEndian.PutUint32(member[gzipExtraDataOff+4:], uint32(n))
+		_, err = dst.Write(member)
+		if err != nil {
+			return err
+		}
+	}
+}
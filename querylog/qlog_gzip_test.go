@@ -0,0 +1,107 @@
+package querylog
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readAllLines reads all lines from the query log file in the reverse order
+func readAllLines(t *testing.T, q *QLogFile) []string {
+	_, err := q.SeekStart()
+	assert.Nil(t, err)
+
+	lines := []string{}
+	for {
+		line, err := q.ReadNext()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestQLogGzip(t *testing.T) {
+	count := 2000
+
+	testDir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(testDir) }()
+	testFile := prepareTestFile(testDir, count)
+	st, err := os.Stat(testFile)
+	assert.Nil(t, err)
+	assert.True(t, st.Size() > 3*gzipBlockSize)
+
+	compressed := testFile + ".gz"
+	assert.Nil(t, compressFile(testFile, compressed))
+
+	// the file has several blocks and gunzip reads it as a whole
+	f, err := os.Open(compressed)
+	assert.Nil(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(zr)
+	assert.Nil(t, err)
+	orig, err := ioutil.ReadFile(testFile)
+	assert.Nil(t, err)
+	assert.Equal(t, orig, data)
+
+	s := newGzipSource(f)
+	size, err := s.fileSize()
+	assert.Nil(t, err)
+	assert.Equal(t, st.Size(), size)
+	assert.True(t, len(s.blocks) > 3)
+
+	// the lines are the same as in the plain file
+	plain, err := NewQLogFile(testFile)
+	assert.Nil(t, err)
+	defer plain.Close()
+	q, err := NewQLogFile(compressed)
+	assert.Nil(t, err)
+	defer q.Close()
+	lines := readAllLines(t, q)
+	assert.Equal(t, count, len(lines))
+	assert.Equal(t, readAllLines(t, plain), lines)
+
+	// seek works across the blocks
+	testSeekLineQLogFile(t, q, 300)
+	testSeekLineQLogFile(t, q, count/2)
+	testSeekLineQLogFile(t, q, count)
+}
+
+// The files compressed as a single gzip stream can still be read
+func TestQLogGzipSingleStream(t *testing.T) {
+	count := 1000
+
+	testDir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(testDir) }()
+	testFile := prepareTestFile(testDir, count)
+
+	orig, err := ioutil.ReadFile(testFile)
+	assert.Nil(t, err)
+	assert.True(t, len(orig) > gzipBlockSize)
+	compressed := testFile + ".gz"
+	f, err := os.Create(compressed)
+	assert.Nil(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(orig)
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, f.Close())
+
+	plain, err := NewQLogFile(testFile)
+	assert.Nil(t, err)
+	defer plain.Close()
+	q, err := NewQLogFile(compressed)
+	assert.Nil(t, err)
+	defer q.Close()
+	assert.Equal(t, readAllLines(t, plain), readAllLines(t, q))
+
+	testSeekLineQLogFile(t, q, count/2)
+}
@@ -6,23 +6,29 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/util"
 
 	"github.com/AdguardTeam/golibs/jsonutil"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
 )
 
 type qlogConfig struct {
-	Enabled           bool   `json:"enabled"`
-	Interval          uint32 `json:"interval"`
-	AnonymizeClientIP bool   `json:"anonymize_client_ip"`
+	Enabled           bool     `json:"enabled"`
+	Interval          uint32   `json:"interval"`
+	AnonymizeClientIP bool     `json:"anonymize_client_ip"`
+	IgnoredDomains    []string `json:"ignored_domains"`
+	IgnoredClients    []string `json:"ignored_clients"`
 }
 
 // Register web handlers
 func (l *queryLog) initWeb() {
 	l.conf.HTTPRegister("GET", "/control/querylog", l.handleQueryLog)
+	l.conf.HTTPRegister("GET", "/control/querylog/export", l.handleQueryLogExport)
+	l.conf.HTTPRegister("GET", "/control/querylog/stream", l.handleQueryLogStream)
 	l.conf.HTTPRegister("GET", "/control/querylog_info", l.handleQueryLogInfo)
 	l.conf.HTTPRegister("POST", "/control/querylog_clear", l.handleQueryLogClear)
 	l.conf.HTTPRegister("POST", "/control/querylog_config", l.handleQueryLogConfig)
@@ -72,6 +78,14 @@ func (l *queryLog) handleQueryLogInfo(w http.ResponseWriter, r *http.Request) {
 	resp.Enabled = l.conf.Enabled
 	resp.Interval = l.conf.Interval
 	resp.AnonymizeClientIP = l.conf.AnonymizeClientIP
+	resp.IgnoredDomains = l.conf.IgnoredDomains
+	resp.IgnoredClients = l.conf.IgnoredClients
+	if resp.IgnoredDomains == nil {
+		resp.IgnoredDomains = []string{}
+	}
+	if resp.IgnoredClients == nil {
+		resp.IgnoredClients = []string{}
+	}
 
 	jsonVal, err := json.Marshal(resp)
 	if err != nil {
@@ -102,6 +116,20 @@ func (l *queryLog) handleQueryLogConfig(w http.ResponseWriter, r *http.Request)
 	l.lock.Lock()
 	// copy data, modify it, then activate.  Other threads (readers) don't need to use this lock.
 	conf := *l.conf
+	if req.Exists("ignored_domains") || req.Exists("ignored_clients") {
+		if req.Exists("ignored_domains") {
+			conf.IgnoredDomains = d.IgnoredDomains
+		}
+		if req.Exists("ignored_clients") {
+			conf.IgnoredClients = d.IgnoredClients
+		}
+		conf.ignored, err = util.NewIgnoreList(conf.IgnoredDomains, conf.IgnoredClients)
+		if err != nil {
+			l.lock.Unlock()
+			httpError(r, w, http.StatusBadRequest, "ignore list: %s", err)
+			return
+		}
+	}
 	if req.Exists("enabled") {
 		conf.Enabled = d.Enabled
 	}
@@ -142,13 +170,74 @@ func (l *queryLog) parseSearchCriteria(q url.Values, name string, ct criteriaTyp
 		c.strict = true
 	}
 
-	if ct == ctFilteringStatus && !util.ContainsString(filteringStatusValues, c.value) {
-		return false, c, fmt.Errorf("invalid value %s", c.value)
+	switch ct {
+	case ctFilteringStatus:
+		if !util.ContainsString(filteringStatusValues, c.value) {
+			return false, c, fmt.Errorf("invalid value %s", c.value)
+		}
+
+	case ctQuestionType:
+		c.value = strings.ToUpper(c.value)
+		c.strict = true
+
+	case ctResponseCode:
+		// "SERVFAIL" or "2" -> "2"
+		rcode, ok := dns.StringToRcode[strings.ToUpper(c.value)]
+		if !ok {
+			n, err := strconv.ParseUint(c.value, 10, 8)
+			if err != nil {
+				return false, c, fmt.Errorf("invalid value %s", c.value)
+			}
+			rcode = int(n)
+		}
+		c.value = strconv.Itoa(rcode)
+		c.strict = true
+
+	case ctClientProto:
+		if !util.ContainsString(clientProtoValues, c.value) {
+			return false, c, fmt.Errorf("invalid value %s", c.value)
+		}
+		c.strict = true
+
+	case ctFilterID:
+		_, err := strconv.ParseInt(c.value, 10, 64)
+		if err != nil {
+			return false, c, fmt.Errorf("invalid value %s", c.value)
+		}
+		c.strict = true
 	}
 
 	return true, c, nil
 }
 
+// parseElapsed - parses the request processing time (in milliseconds) from the specified query parameter
+func parseElapsed(q url.Values, name string) (time.Duration, error) {
+	val := q.Get(name)
+	if len(val) == 0 {
+		return 0, nil
+	}
+
+	ms, err := strconv.ParseFloat(val, 64)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid %s value %s", name, val)
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// parseTime - parses time in RFC3339 format from the specified query parameter
+func parseTime(q url.Values, name string) (time.Time, error) {
+	val := q.Get(name)
+	if len(val) == 0 {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s value %s", name, val)
+	}
+	return t, nil
+}
+
 // parseSearchParams - parses "searchParams" from the HTTP request's query string
 func (l *queryLog) parseSearchParams(r *http.Request) (*searchParams, error) {
 	p := newSearchParams()
@@ -175,9 +264,33 @@ func (l *queryLog) parseSearchParams(r *http.Request) (*searchParams, error) {
 		p.maxFileScanEntries = 0
 	}
 
+	p.timeFrom, err = parseTime(q, "time_from")
+	if err != nil {
+		return nil, err
+	}
+	p.timeTo, err = parseTime(q, "time_to")
+	if err != nil {
+		return nil, err
+	}
+
+	p.elapsedMin, err = parseElapsed(q, "elapsed_min")
+	if err != nil {
+		return nil, err
+	}
+	p.elapsedMax, err = parseElapsed(q, "elapsed_max")
+	if err != nil {
+		return nil, err
+	}
+
 	paramNames := map[string]criteriaType{
 		"search":          ctDomainOrClient,
 		"response_status": ctFilteringStatus,
+		"question_type":   ctQuestionType,
+		"response_code":   ctResponseCode,
+		"upstream":        ctUpstream,
+		"client_proto":    ctClientProto,
+		"filter_id":       ctFilterID,
+		"rule":            ctRule,
 	}
 
 	for k, v := range paramNames {
@@ -3,6 +3,7 @@ package querylog
 import (
 	"io"
 
+	"github.com/AdguardTeam/golibs/log"
 	"github.com/joomcode/errorx"
 )
 
@@ -12,6 +13,9 @@ import (
 // Internally, it contains a pointer to a particular query log file, and
 // to a specific position in this file, and it reads lines in reverse order
 // starting from that position.
+//
+// All files are opened when the reader is created, so the files which are renamed by rotation
+// while we read them are still the same files for us.
 type QLogReader struct {
 	// qFiles - array with the query log files
 	// The order is - from oldest to newest
@@ -22,15 +26,15 @@ type QLogReader struct {
 
 // NewQLogReader initializes a QLogReader instance
 // with the specified files
+// A file which can't be opened is skipped, so a broken file doesn't break the whole query log.
 func NewQLogReader(files []string) (*QLogReader, error) {
 	qFiles := make([]*QLogFile, 0)
 
 	for _, f := range files {
 		q, err := NewQLogFile(f)
 		if err != nil {
-			// Close what we've already opened
-			_ = closeQFiles(qFiles)
-			return nil, err
+			log.Error("querylog: skipping %s: %s", f, err)
+			continue
 		}
 
 		qFiles = append(qFiles, q)
@@ -63,6 +67,24 @@ func (r *QLogReader) Seek(timestamp int64) error {
 	return ErrSeekNotFound
 }
 
+// SeekOlder sets QLogReader's position so that the next ReadNext calls returned
+// the records older than the specified timestamp.
+// A few newer records may be returned first (see QLogFile.SeekOlder).
+//
+// Returns ErrSeekNotFound if there are no older records.
+func (r *QLogReader) SeekOlder(timestamp int64) error {
+	for i := len(r.qFiles) - 1; i >= 0; i-- {
+		q := r.qFiles[i]
+		_, err := q.SeekOlder(timestamp)
+		if err == nil {
+			r.currentFile = i
+			return nil
+		}
+	}
+
+	return ErrSeekNotFound
+}
+
 // SeekStart changes the current position to the end of the newest file
 // Please note that we're reading query log in the reverse order
 // and that's why log start is actually the end of file
@@ -70,45 +92,41 @@ func (r *QLogReader) Seek(timestamp int64) error {
 // Returns nil if we were able to change the current position.
 // Returns error in any other case.
 func (r *QLogReader) SeekStart() error {
-	if len(r.qFiles) == 0 {
-		return nil
-	}
-
 	r.currentFile = len(r.qFiles) - 1
-	_, err := r.qFiles[r.currentFile].SeekStart()
-	return err
+	r.seekStartValid()
+	return nil
+}
+
+// seekStartValid sets the position of the current file to its end
+// The files which can't be read (e.g. broken compressed files) are skipped.
+func (r *QLogReader) seekStartValid() {
+	for ; r.currentFile >= 0; r.currentFile-- {
+		q := r.qFiles[r.currentFile]
+		_, err := q.SeekStart()
+		if err == nil {
+			return
+		}
+		log.Error("querylog: skipping %s: %s", q.file.Name(), err)
+	}
 }
 
 // ReadNext reads the next line (in the reverse order) from the query log files.
 // and shifts the current position left to the next (actually prev) line (or the next file).
 // returns io.EOF if there's nothing to read more.
 func (r *QLogReader) ReadNext() (string, error) {
-	if len(r.qFiles) == 0 {
-		return "", io.EOF
-	}
-
 	for r.currentFile >= 0 {
 		q := r.qFiles[r.currentFile]
 		line, err := q.ReadNext()
-		if err != nil {
-			// Shift to the older file
-			r.currentFile--
-			if r.currentFile < 0 {
-				break
-			}
-
-			q = r.qFiles[r.currentFile]
-
-			// Set it's position to the start right away
-			_, err = q.SeekStart()
-
-			// This is unexpected, return an error right away
-			if err != nil {
-				return "", err
-			}
-		} else {
+		if err == nil {
 			return line, nil
 		}
+		if err != io.EOF {
+			log.Error("querylog: %s: %s", q.file.Name(), err)
+		}
+
+		// Shift to the older file and set it's position to the start right away
+		r.currentFile--
+		r.seekStartValid()
 	}
 
 	// Nothing to read anymore
@@ -2,7 +2,9 @@ package querylog
 
 import (
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -126,6 +128,53 @@ func TestQLogReaderSeek(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestQLogReaderSeekOlder(t *testing.T) {
+	count := 10000
+	filesCount := 2
+
+	testDir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(testDir) }()
+	testFiles := prepareTestFiles(testDir, filesCount, count)
+
+	r, err := NewQLogReader(testFiles)
+	assert.Nil(t, err)
+	defer r.Close()
+
+	// there's no record with this exact time: the lines are 1 second apart
+	for _, n := range []int{1, 300, count - 1, count, count + 1, count + 300, 2 * count} {
+		line, err := getQLogReaderLine(r, n)
+		assert.Nil(t, err)
+		ts := readQLogTimestamp(line) + int64(time.Second/2)
+
+		assert.Nil(t, r.SeekOlder(ts))
+		skipped := 0
+		for {
+			testLine, err := r.ReadNext()
+			assert.Nil(t, err)
+			if readQLogTimestamp(testLine) < ts {
+				assert.Equal(t, line, testLine)
+				break
+			}
+			skipped++
+		}
+		assert.True(t, skipped < 10)
+	}
+
+	// nothing is older
+	err = r.SeekOlder(123)
+	assert.Equal(t, ErrSeekNotFound, err)
+
+	// everything is older
+	ts, _ := time.Parse(time.RFC3339, "2100-01-02T15:04:05Z")
+	assert.Nil(t, r.SeekOlder(ts.UnixNano()))
+	line, err := getQLogReaderLine(r, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, r.SeekOlder(ts.UnixNano()))
+	testLine, err := r.ReadNext()
+	assert.Nil(t, err)
+	assert.Equal(t, line, testLine)
+}
+
 func testSeekLineQLogReader(t *testing.T, r *QLogReader, lineNumber int) {
 	line, err := getQLogReaderLine(r, lineNumber)
 	assert.Nil(t, err)
@@ -155,3 +204,83 @@ func getQLogReaderLine(r *QLogReader, lineNumber int) (string, error) {
 	}
 	return r.ReadNext()
 }
+
+func TestQLogReaderBrokenFile(t *testing.T) {
+	count := 10
+	filesCount := 2
+
+	testDir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(testDir) }()
+	testFiles := prepareTestFiles(testDir, filesCount, count)
+
+	// the broken compressed file and the file which doesn't exist anymore are skipped
+	broken := filepath.Join(testDir, "querylog.json.2.gz")
+	assert.Nil(t, ioutil.WriteFile(broken, []byte("not gzip"), 0644))
+	files := []string{testFiles[0], broken, filepath.Join(testDir, "querylog.json.1"), testFiles[1]}
+
+	r, err := NewQLogReader(files)
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Nil(t, r.SeekStart())
+
+	read := 0
+	for {
+		_, err = r.ReadNext()
+		if err != nil {
+			break
+		}
+		read++
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, count*filesCount, read)
+
+	// seek to a record of the oldest file
+	r2, err := NewQLogReader(files)
+	assert.Nil(t, err)
+	defer r2.Close()
+	assert.Nil(t, r2.SeekStart())
+	line, err := r2.ReadNext()
+	assert.Nil(t, err)
+	ts := readQLogTimestamp(line)
+	assert.Nil(t, r2.Seek(ts-int64(count)*int64(time.Second)))
+	assert.Equal(t, 0, r2.currentFile)
+}
+
+// The files which are renamed (rotated) while we read them are read completely and only once
+func TestQLogReaderRotate(t *testing.T) {
+	count := 100
+	filesCount := 3
+
+	testDir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(testDir) }()
+	testFiles := prepareTestFiles(testDir, filesCount, count)
+
+	r, err := NewQLogReader(testFiles)
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Nil(t, r.SeekStart())
+
+	read := 0
+	for ; read < count/2; read++ {
+		_, err = r.ReadNext()
+		assert.Nil(t, err)
+	}
+
+	// rotate: the files are shifted, the oldest is compressed and the newest is a new file
+	assert.Nil(t, compressFile(testFiles[0], testFiles[0]+".gz"))
+	assert.Nil(t, os.Remove(testFiles[0]))
+	assert.Nil(t, os.Rename(testFiles[1], testFiles[0]))
+	assert.Nil(t, os.Rename(testFiles[2], testFiles[1]))
+	newFiles := prepareTestFiles(testDir, 1, count)
+	assert.Nil(t, os.Rename(newFiles[0], testFiles[2]))
+
+	for {
+		_, err = r.ReadNext()
+		if err != nil {
+			break
+		}
+		read++
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, count*filesCount, read)
+}
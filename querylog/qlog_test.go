@@ -1,13 +1,22 @@
 package querylog
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/dnsproxy/proxyutil"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
@@ -208,6 +217,222 @@ func TestQueryLogFileDisabled(t *testing.T) {
 	assert.Equal(t, "example2.org", ll[1].QHost)
 }
 
+func TestQueryLogIgnored(t *testing.T) {
+	conf := Config{
+		Enabled:        true,
+		Interval:       1,
+		MemSize:        100,
+		IgnoredDomains: []string{"*.health.example.org"},
+		IgnoredClients: []string{"2.2.2.0/24"},
+		ConfigModified: func() {},
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	addEntry(l, "check.health.example.org", "1.1.1.1", "3.3.3.1")
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example.org", "1.1.1.1", "3.3.3.1")
+
+	ll, _ := l.search(newSearchParams())
+	assert.Equal(t, 1, len(ll))
+
+	// change the list via HTTP
+	req := httptest.NewRequest("POST", "/control/querylog_config", strings.NewReader(`{"ignored_clients":["3.3.3.1"]}`))
+	w := httptest.NewRecorder()
+	l.handleQueryLogConfig(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"*.health.example.org"}, l.conf.IgnoredDomains)
+
+	addEntry(l, "example.org", "1.1.1.1", "3.3.3.1")
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	ll, _ = l.search(newSearchParams())
+	assert.Equal(t, 2, len(ll))
+
+	// invalid lists are rejected
+	req = httptest.NewRequest("POST", "/control/querylog_config", strings.NewReader(`{"ignored_clients":["host"]}`))
+	w = httptest.NewRecorder()
+	l.handleQueryLogConfig(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"3.3.3.1"}, l.conf.IgnoredClients)
+}
+
+// Check searching by the additional criteria (combined with AND)
+func TestQueryLogSearchCriteria(t *testing.T) {
+	conf := Config{
+		Enabled:     true,
+		FileEnabled: true,
+		Interval:    1,
+		MemSize:     100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	add := func(host string, qtype uint16, rcode int, upstream, proto string, elapsed time.Duration, res dnsfilter.Result) {
+		q := dns.Msg{}
+		q.SetQuestion(host+".", qtype)
+		a := dns.Msg{}
+		a.SetRcode(&q, rcode)
+		l.Add(AddParams{
+			Question:    &q,
+			Answer:      &a,
+			Result:      &res,
+			ClientIP:    net.ParseIP("1.2.3.4"),
+			Elapsed:     elapsed,
+			Upstream:    upstream,
+			ClientProto: proto,
+		})
+	}
+	add("a.org", dns.TypeAAAA, dns.RcodeServerFailure, "tls://1.1.1.1", "", 100*time.Millisecond, dnsfilter.Result{})
+	add("b.org", dns.TypeA, dns.RcodeServerFailure, "tls://1.1.1.1", "doh", 10*time.Millisecond, dnsfilter.Result{})
+	_ = l.flushLogBuffer(true)
+	add("c.org", dns.TypeAAAA, dns.RcodeSuccess, "8.8.8.8:53", "dot", 5*time.Millisecond, dnsfilter.Result{})
+	add("d.org", dns.TypeAAAA, dns.RcodeNameError, "", "", time.Millisecond,
+		dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredBlackList, Rule: "||d.org^", FilterID: 2})
+
+	search := func(query string) []string {
+		r, _ := http.NewRequest("GET", "http://127.0.0.1/control/querylog?"+query, nil)
+		params, err := l.parseSearchParams(r)
+		assert.Nil(t, err)
+		entries, _ := l.search(params)
+		hosts := []string{}
+		for _, e := range entries {
+			hosts = append(hosts, e.QHost)
+		}
+		return hosts
+	}
+
+	assert.Equal(t, []string{"d.org", "c.org", "a.org"}, search("question_type=aaaa"))
+	assert.Equal(t, []string{"b.org", "a.org"}, search("response_code=SERVFAIL"))
+	assert.Equal(t, []string{"a.org"}, search("question_type=AAAA&response_code=2&upstream=1.1.1.1"))
+	assert.Equal(t, []string{"d.org", "a.org"}, search("client_proto=plain"))
+	assert.Equal(t, []string{"b.org"}, search("client_proto=doh"))
+	assert.Equal(t, []string{"d.org"}, search("filter_id=2&rule=d.org"))
+	assert.Equal(t, []string{}, search("rule=%22d.org%22"))
+	assert.Equal(t, []string{"c.org", "b.org"}, search("elapsed_min=5&elapsed_max=10"))
+
+	from := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	assert.Equal(t, 4, len(search("time_from="+url.QueryEscape(from))))
+	to := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	assert.Equal(t, 0, len(search("time_to="+url.QueryEscape(to))))
+	from = time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	assert.Equal(t, 0, len(search("time_from="+url.QueryEscape(from))))
+
+	r, _ := http.NewRequest("GET", "http://127.0.0.1/control/querylog?client_proto=udp", nil)
+	_, err := l.parseSearchParams(r)
+	assert.NotNil(t, err)
+	r, _ = http.NewRequest("GET", "http://127.0.0.1/control/querylog?response_code=BAD", nil)
+	_, err = l.parseSearchParams(r)
+	assert.NotNil(t, err)
+}
+
+func TestQueryLogExport(t *testing.T) {
+	conf := Config{
+		Enabled:     true,
+		FileEnabled: true,
+		Interval:    1,
+		MemSize:     100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example.net", "1.1.1.2", "2.2.2.2")
+	_ = l.flushLogBuffer(true)
+	addEntry(l, "example.com", "1.1.1.3", "2.2.2.3")
+
+	// NDJSON: all entries from memory and file, with search filter
+	r, _ := http.NewRequest("GET", "http://127.0.0.1/control/querylog/export?search=example.org", nil)
+	w := httptest.NewRecorder()
+	l.handleQueryLogExport(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, 1, len(lines))
+	m := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &m))
+	assert.Equal(t, "2.2.2.1", m["client"])
+
+	// CSV: header + all entries from newer to older
+	r, _ = http.NewRequest("GET", "http://127.0.0.1/control/querylog/export?format=csv", nil)
+	w = httptest.NewRecorder()
+	l.handleQueryLogExport(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, csvHeader, records[0])
+	assert.Equal(t, "example.com", records[1][3])
+	assert.Equal(t, "example.net", records[2][3])
+	assert.Equal(t, "example.org", records[3][3])
+	assert.Equal(t, "NOERROR", records[3][6])
+	assert.Equal(t, "A 1.1.1.1", records[3][13])
+
+	r, _ = http.NewRequest("GET", "http://127.0.0.1/control/querylog/export?format=xml", nil)
+	w = httptest.NewRecorder()
+	l.handleQueryLogExport(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// Check rotation with compression and the removal of old files
+func TestQueryLogRotateCompressed(t *testing.T) {
+	conf := Config{
+		Enabled:     true,
+		FileEnabled: true,
+		Interval:    1,
+		MemSize:     100,
+		MaxSize:     1,
+		Compress:    true,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	addEntry(l, "example1.org", "1.1.1.1", "2.2.2.1")
+	_ = l.flushLogBuffer(true)
+	assert.Nil(t, l.rotate())
+	addEntry(l, "example2.org", "1.1.1.2", "2.2.2.2")
+	_ = l.flushLogBuffer(true)
+	assert.Nil(t, l.rotate())
+	addEntry(l, "example3.org", "1.1.1.3", "2.2.2.3")
+	_ = l.flushLogBuffer(true)
+
+	assert.Equal(t, []string{l.logFile + ".1.gz", l.logFile + ".2.gz"}, l.segments())
+	assert.Equal(t, []string{"example3.org", "example2.org", "example1.org"}, searchHosts(l))
+
+	// the oldest file is out of the time limit
+	tm := time.Now().Add(-48 * time.Hour)
+	assert.Nil(t, os.Chtimes(l.logFile+".2.gz", tm, tm))
+	l.removeOldSegments()
+	assert.Equal(t, []string{l.logFile + ".1.gz"}, l.segments())
+	assert.Equal(t, []string{"example3.org", "example2.org"}, searchHosts(l))
+
+	// the total size is out of the limit: the oldest file is removed
+	data := make([]byte, 600*1024)
+	assert.Nil(t, ioutil.WriteFile(l.logFile+".2", data, 0644))
+	assert.Nil(t, ioutil.WriteFile(l.logFile+".3", data, 0644))
+	l.removeOldSegments()
+	assert.Equal(t, []string{l.logFile + ".1.gz", l.logFile + ".2"}, l.segments())
+
+	// the current file is rotated when it reaches the segment size
+	assert.Nil(t, ioutil.WriteFile(l.logFile, data[:l.segmentSize()], 0644))
+	addEntry(l, "example4.org", "1.1.1.4", "2.2.2.4")
+	_ = l.flushLogBuffer(true)
+	assert.False(t, util.FileExists(l.logFile))
+	assert.Equal(t, []string{l.logFile + ".1.gz", l.logFile + ".2.gz", l.logFile + ".3"}, l.segments())
+
+	l.clear()
+	assert.Equal(t, 0, len(l.segments()))
+
+	l.conf.MaxSize = 0
+	assert.Equal(t, int64(0), l.segmentSize())
+	l.conf.MaxSize = 1000
+	assert.Equal(t, int64(100*1024*1024), l.segmentSize())
+	l.conf.Compress = false
+	assert.Equal(t, int64(100*1024*1024), l.segmentSize())
+}
+
 func addEntry(l *queryLog, host, answerStr, client string) {
 	q := dns.Msg{}
 	q.Question = append(q.Question, dns.Question{
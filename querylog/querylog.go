@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/miekg/dns"
 )
 
@@ -31,12 +32,22 @@ type Config struct {
 	Interval          uint32 // interval to rotate logs (in days)
 	MemSize           uint32 // number of entries kept in memory before they are flushed to disk
 	AnonymizeClientIP bool   // anonymize clients' IP addresses
+	IndexEnabled      bool   // store entries in a database with indexes instead of the file
+	MaxSize           uint32 // max size of all query log files (in MB), 0: unlimited
+	Compress          bool   // compress rotated query log files with gzip
+
+	IgnoredDomains []string // domain names and wildcard patterns (e.g. "*.example.org") which requests aren't logged
+	IgnoredClients []string // IP addresses and subnets of the clients which requests aren't logged
+
+	Sinks []SinkConfig // remote destinations for log entries
 
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func()
 
 	// Register an HTTP handler
 	HTTPRegister func(string, string, func(http.ResponseWriter, *http.Request))
+
+	ignored *util.IgnoreList // requests which aren't logged
 }
 
 // AddParams - parameters for Add()
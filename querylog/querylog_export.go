@@ -0,0 +1,230 @@
+package querylog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+const (
+	exportFormatNDJSON = "ndjson" // one JSON object per line
+	exportFormatCSV    = "csv"
+
+	// flush the response to the client after this number of entries
+	exportFlushEntries = 1000
+)
+
+// iterate - goes through all log entries (from newer to older) that match the search parameters
+// unlike search(), it doesn't use limit/offset and doesn't collect entries in memory
+// the callback returns false to stop the iteration
+func (l *queryLog) iterate(params *searchParams, cb func(entry *logEntry) bool) {
+	// memory buffer contains the newest entries, so we start with it
+	l.bufferLock.Lock()
+	memoryEntries := make([]*logEntry, 0)
+	for i := len(l.buffer) - 1; i >= 0; i-- {
+		entry := l.buffer[i]
+		if params.isTooOld(entry.Time.UnixNano()) {
+			break
+		}
+		if params.match(entry) {
+			memoryEntries = append(memoryEntries, entry)
+		}
+	}
+	l.bufferLock.Unlock()
+
+	for _, entry := range memoryEntries {
+		if !cb(entry) {
+			return
+		}
+	}
+
+	if l.index != nil {
+		err := l.index.search(params, cb)
+		if err != nil {
+			log.Error("querylog: index: %s", err)
+		}
+		return
+	}
+
+	// the entries newer than timeTo aren't needed, so we start reading right from it
+	if !params.timeTo.IsZero() {
+		olderThan := params.timeTo.Add(time.Nanosecond)
+		if params.olderThan.IsZero() || olderThan.Before(params.olderThan) {
+			params.olderThan = olderThan
+		}
+	}
+
+	r := l.openReaderOlder(params.olderThan)
+	if r == nil {
+		return
+	}
+	defer r.Close()
+
+	for {
+		entry, ts, err := l.readNextEntry(r, params)
+		if err != nil {
+			if err != io.EOF {
+				log.Error("querylog: export: %s", err)
+			}
+			break
+		}
+		if params.isTooOld(ts) {
+			break
+		}
+		if entry != nil && !cb(entry) {
+			break
+		}
+	}
+}
+
+// entryWriter - writes log entries in the specific format
+type entryWriter interface {
+	writeEntry(entry *logEntry) error
+	flush() error
+}
+
+// ndjsonWriter - writes log entries as JSON objects, one per line
+type ndjsonWriter struct {
+	l   *queryLog
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) writeEntry(entry *logEntry) error {
+	return w.enc.Encode(w.l.logEntryToJSONEntry(entry))
+}
+
+func (w *ndjsonWriter) flush() error {
+	return nil
+}
+
+// csvHeader - columns of the exported CSV file
+var csvHeader = []string{
+	"time", "client", "client_proto", "host", "type", "class", "status",
+	"reason", "rule", "filter_id", "service_name", "upstream", "elapsed_ms", "answer",
+}
+
+// csvWriter - writes log entries as CSV records
+type csvWriter struct {
+	l *queryLog
+	w *csv.Writer
+}
+
+func (w *csvWriter) writeEntry(entry *logEntry) error {
+	status := ""
+	answer := []string{}
+	if len(entry.Answer) != 0 {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(entry.Answer); err == nil {
+			status = dns.RcodeToString[msg.Rcode]
+			for _, a := range answerToMap(msg) {
+				answer = append(answer, fmt.Sprintf("%s %v", a["type"], a["value"]))
+			}
+		}
+	}
+
+	filterID := ""
+	if len(entry.Result.Rule) != 0 {
+		filterID = strconv.FormatInt(entry.Result.FilterID, 10)
+	}
+
+	return w.w.Write([]string{
+		entry.Time.Format(time.RFC3339Nano),
+		w.l.getClientIP(entry.IP),
+		entry.ClientProto,
+		entry.QHost,
+		entry.QType,
+		entry.QClass,
+		status,
+		entry.Result.Reason.String(),
+		entry.Result.Rule,
+		filterID,
+		entry.Result.ServiceName,
+		entry.Upstream,
+		strconv.FormatFloat(entry.Elapsed.Seconds()*1000, 'f', -1, 64),
+		strings.Join(answer, "; "),
+	})
+}
+
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Export log entries that match the search parameters
+// The data is streamed to the client without loading all the entries in memory
+func (l *queryLog) handleQueryLogExport(w http.ResponseWriter, r *http.Request) {
+	params, err := l.parseSearchParams(r)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "failed to parse params: %s", err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = exportFormatNDJSON
+	}
+
+	var ew entryWriter
+	var ext string
+	switch format {
+	case exportFormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		ew = &ndjsonWriter{l: l, enc: json.NewEncoder(w)}
+		ext = "json"
+	case exportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		err = cw.Write(csvHeader)
+		if err != nil {
+			log.Debug("querylog: export: %s", err)
+			return
+		}
+		ew = &csvWriter{l: l, w: cw}
+		ext = "csv"
+	default:
+		httpError(r, w, http.StatusBadRequest, "unsupported format %s", format)
+		return
+	}
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"querylog-%s.%s\"", time.Now().Format("20060102-150405"), ext))
+
+	flusher, _ := w.(http.Flusher)
+	start := time.Now()
+	n := 0
+	l.iterate(params, func(entry *logEntry) bool {
+		err = ew.writeEntry(entry)
+		if err != nil {
+			return false
+		}
+
+		n++
+		if n%exportFlushEntries == 0 {
+			err = ew.flush()
+			if err != nil {
+				return false
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		// the response is already being sent, so we can only log the error
+		log.Debug("querylog: export: %s", err)
+		return
+	}
+
+	log.Debug("querylog: exported %d entries in %s", n, time.Since(start))
+}
@@ -3,12 +3,21 @@ package querylog
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 )
 
+const (
+	compressedExt  = ".gz"     // extension of the compressed rotated files
+	maxSegments    = 10        // the current file is rotated when it reaches 1/maxSegments of the size limit
+	minSegmentSize = 64 * 1024 // min size of the current file at which it's rotated
+)
+
 // flushLogBuffer flushes the current buffer to file and resets the current buffer
 func (l *queryLog) flushLogBuffer(fullFlush bool) error {
 	if !l.conf.FileEnabled {
@@ -45,6 +54,16 @@ func (l *queryLog) flushToFile(buffer []*logEntry) error {
 	}
 	start := time.Now()
 
+	if l.index != nil {
+		err := l.index.add(buffer)
+		if err != nil {
+			log.Error("querylog: index: %s", err)
+			return err
+		}
+		log.Debug("querylog: index: %d entries stored in %s", len(buffer), time.Since(start))
+		return nil
+	}
+
 	var b bytes.Buffer
 	e := json.NewEncoder(&b)
 	for _, entry := range buffer {
@@ -80,28 +99,173 @@ func (l *queryLog) flushToFile(buffer []*logEntry) error {
 
 	log.Debug("ok \"%s\": %v bytes written", filename, n)
 
+	segmentSize := l.segmentSize()
+	if segmentSize != 0 {
+		st, err := f.Stat()
+		if err == nil && st.Size() >= segmentSize {
+			_ = f.Close()
+			return l.rotateLocked()
+		}
+	}
+
 	return nil
 }
 
+// segmentName returns the name of the rotated query log file with the specified number
+func (l *queryLog) segmentName(n int, compressed bool) string {
+	name := fmt.Sprintf("%s.%d", l.logFile, n)
+	if compressed {
+		name += compressedExt
+	}
+	return name
+}
+
+// segments returns the names of the rotated query log files: from newest to oldest
+func (l *queryLog) segments() []string {
+	var files []string
+	for n := 1; ; n++ {
+		name := l.segmentName(n, false)
+		if !util.FileExists(name) {
+			name = l.segmentName(n, true)
+			if !util.FileExists(name) {
+				break
+			}
+		}
+		files = append(files, name)
+	}
+	return files
+}
+
+// segmentSize returns the size of the current file at which it's rotated
+// 0: the file is rotated only by time
+func (l *queryLog) segmentSize() int64 {
+	if l.conf.MaxSize == 0 {
+		return 0
+	}
+	size := int64(l.conf.MaxSize) * 1024 * 1024 / maxSegments
+	if size < minSegmentSize {
+		size = minSegmentSize
+	}
+	return size
+}
+
+// rotate renames the current file to "querylog.json.1" (compressing it if needed),
+// shifts the numbers of older files and removes the files that are out of limits
 func (l *queryLog) rotate() error {
+	l.fileWriteLock.Lock()
+	defer l.fileWriteLock.Unlock()
+	return l.rotateLocked()
+}
+
+// rotateLocked does the same as rotate(), but fileWriteLock must be held
+func (l *queryLog) rotateLocked() error {
 	from := l.logFile
-	to := l.logFile + ".1"
 
 	if _, err := os.Stat(from); os.IsNotExist(err) {
-		// do nothing, file doesn't exist
+		// file doesn't exist (e.g. the entries are stored in the index), but the old files are still removed
+		l.removeOldSegments()
 		return nil
 	}
 
-	err := os.Rename(from, to)
+	files := l.segments()
+	for i := len(files) - 1; i >= 0; i-- {
+		to := l.segmentName(i+2, strings.HasSuffix(files[i], compressedExt))
+		err := os.Rename(files[i], to)
+		if err != nil {
+			log.Error("Failed to rename querylog: %s", err)
+			return err
+		}
+	}
+
+	to := l.segmentName(1, l.conf.Compress)
+	var err error
+	if l.conf.Compress {
+		err = compressFile(from, to)
+		if err == nil {
+			err = os.Remove(from)
+		}
+	} else {
+		err = os.Rename(from, to)
+	}
 	if err != nil {
-		log.Error("Failed to rename querylog: %s", err)
+		log.Error("Failed to rotate querylog: %s", err)
 		return err
 	}
 
 	log.Debug("Rotated from %s to %s successfully", from, to)
+
+	l.removeOldSegments()
 	return nil
 }
 
+// removeOldSegments removes the rotated files which are older than the retention interval,
+// and the oldest files while the total size of all query log files exceeds the limit
+func (l *queryLog) removeOldSegments() {
+	total := int64(0)
+	st, err := os.Stat(l.logFile)
+	if err == nil {
+		total = st.Size()
+	}
+	maxSize := int64(l.conf.MaxSize) * 1024 * 1024
+	// the newest entry of the file is written approximately at its modification time
+	oldest := time.Now().Add(-time.Duration(l.conf.Interval) * 24 * time.Hour)
+
+	files := l.segments()
+	remove := len(files)
+	for i, name := range files {
+		st, err = os.Stat(name)
+		if err != nil {
+			log.Error("querylog: %s", err)
+			continue
+		}
+		total += st.Size()
+		if (maxSize != 0 && total > maxSize) || st.ModTime().Before(oldest) {
+			remove = i
+			break
+		}
+	}
+
+	for _, name := range files[remove:] {
+		err = os.Remove(name)
+		if err != nil {
+			log.Error("querylog: %s", err)
+			continue
+		}
+		log.Debug("querylog: removed %s", name)
+	}
+}
+
+// compressFile writes gzip-compressed data of the file "from" to the file "to"
+// The data is compressed block by block (see writeGzipBlocks), so the file isn't read into memory entirely.
+func compressFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := to + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = writeGzipBlocks(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, to)
+}
+
 func (l *queryLog) periodicRotate() {
 	for range time.Tick(time.Duration(l.conf.Interval) * 24 * time.Hour) {
 		err := l.rotate()
@@ -18,7 +18,14 @@ func (l *queryLog) search(params *searchParams) ([]*logEntry, time.Time) {
 	}
 
 	// add from file
-	fileEntries, oldest, total := l.searchFiles(params)
+	var fileEntries []*logEntry
+	var oldest time.Time
+	var total int
+	if l.index != nil {
+		fileEntries, oldest, total = l.searchIndex(params)
+	} else {
+		fileEntries, oldest, total = l.searchFiles(params)
+	}
 
 	// add from memory buffer
 	l.bufferLock.Lock()
@@ -29,6 +36,9 @@ func (l *queryLog) search(params *searchParams) ([]*logEntry, time.Time) {
 	// from NEWER to OLDER
 	for i := len(l.buffer) - 1; i >= 0; i-- {
 		entry := l.buffer[i]
+		if params.isTooOld(entry.Time.UnixNano()) {
+			break
+		}
 		if !params.match(entry) {
 			continue
 		}
@@ -79,30 +89,12 @@ func (l *queryLog) searchFiles(params *searchParams) ([]*logEntry, time.Time, in
 	entries := make([]*logEntry, 0)
 	oldest := time.Time{}
 
-	r, err := l.openReader()
-	if err != nil {
-		log.Error("Failed to open qlog reader: %v", err)
+	r := l.openReaderAt(params.olderThan)
+	if r == nil {
 		return entries, oldest, 0
 	}
 	defer r.Close()
 
-	if params.olderThan.IsZero() {
-		err = r.SeekStart()
-	} else {
-		err = r.Seek(params.olderThan.UnixNano())
-		if err == nil {
-			// Read to the next record right away
-			// The one that was specified in the "oldest" param is not needed,
-			// we need only the one next to it
-			_, err = r.ReadNext()
-		}
-	}
-
-	if err != nil {
-		log.Debug("Cannot Seek() to %v: %v", params.olderThan, err)
-		return entries, oldest, 0
-	}
-
 	totalLimit := params.offset + params.limit
 	total := 0
 	oldestNano := int64(0)
@@ -117,6 +109,11 @@ func (l *queryLog) searchFiles(params *searchParams) ([]*logEntry, time.Time, in
 			break
 		}
 
+		if params.isTooOld(ts) {
+			// all the remaining entries are out of the requested time range
+			break
+		}
+
 		oldestNano = ts
 		total++
 
@@ -166,12 +163,68 @@ func (l *queryLog) readNextEntry(r *QLogReader, params *searchParams) (*logEntry
 	return &entry, timestamp, nil
 }
 
+// openReaderAt - opens QLogReader instance and seeks to the first entry older than the specified time
+// if the time is not set, seeks to the newest entry
+// returns nil if there's nothing to read
+func (l *queryLog) openReaderAt(olderThan time.Time) *QLogReader {
+	r, err := l.openReader()
+	if err != nil {
+		log.Error("Failed to open qlog reader: %v", err)
+		return nil
+	}
+
+	if olderThan.IsZero() {
+		err = r.SeekStart()
+	} else {
+		err = r.Seek(olderThan.UnixNano())
+		if err == nil {
+			// Read to the next record right away
+			// The one that was specified in the "oldest" param is not needed,
+			// we need only the one next to it
+			_, err = r.ReadNext()
+		}
+	}
+
+	if err != nil {
+		log.Debug("Cannot Seek() to %v: %v", olderThan, err)
+		_ = r.Close()
+		return nil
+	}
+	return r
+}
+
+// openReaderOlder - opens QLogReader positioned at the records older than the specified time
+// Unlike openReaderAt, there doesn't have to be a record with this time.
+// A few newer records may be read first, they're skipped by searchParams.match().
+func (l *queryLog) openReaderOlder(olderThan time.Time) *QLogReader {
+	r, err := l.openReader()
+	if err != nil {
+		log.Error("Failed to open qlog reader: %v", err)
+		return nil
+	}
+
+	if olderThan.IsZero() {
+		err = r.SeekStart()
+	} else {
+		err = r.SeekOlder(olderThan.UnixNano())
+	}
+
+	if err != nil {
+		log.Debug("Cannot Seek() to %v: %v", olderThan, err)
+		_ = r.Close()
+		return nil
+	}
+	return r
+}
+
 // openReader - opens QLogReader instance
 func (l *queryLog) openReader() (*QLogReader, error) {
 	files := make([]string, 0)
 
-	if util.FileExists(l.logFile + ".1") {
-		files = append(files, l.logFile+".1")
+	// from oldest to newest
+	segments := l.segments()
+	for i := len(segments) - 1; i >= 0; i-- {
+		files = append(files, segments[i])
 	}
 	if util.FileExists(l.logFile) {
 		files = append(files, l.logFile)
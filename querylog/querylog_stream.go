@@ -0,0 +1,141 @@
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	maxStreamSubscribers = 10               // max number of simultaneous stream connections
+	streamQueueSize      = 100              // number of entries waiting to be sent to a client
+	streamHeartbeat      = 15 * time.Second // interval for sending a comment line to keep the connection alive
+)
+
+// streamSubscriber - a client that receives new log entries
+type streamSubscriber struct {
+	params  *searchParams  // only the entries that match these parameters are sent
+	queue   chan *logEntry // entries waiting to be sent
+	dropped uint64         // number of entries dropped because the client is too slow (atomic)
+}
+
+// subscribe - add a new subscriber
+// returns nil if there are too many subscribers
+func (l *queryLog) subscribe(params *searchParams) *streamSubscriber {
+	l.subscribersLock.Lock()
+	defer l.subscribersLock.Unlock()
+
+	if len(l.subscribers) >= maxStreamSubscribers {
+		return nil
+	}
+
+	s := &streamSubscriber{
+		params: params,
+		queue:  make(chan *logEntry, streamQueueSize),
+	}
+	if l.subscribers == nil {
+		l.subscribers = map[*streamSubscriber]bool{}
+	}
+	l.subscribers[s] = true
+	return s
+}
+
+// unsubscribe - remove the subscriber
+func (l *queryLog) unsubscribe(s *streamSubscriber) {
+	l.subscribersLock.Lock()
+	delete(l.subscribers, s)
+	l.subscribersLock.Unlock()
+}
+
+// publish - pass the new log entry to all subscribers that want it
+// If a subscriber's queue is full, the entry is dropped for this subscriber:
+// a slow client must not block DNS processing.
+func (l *queryLog) publish(entry *logEntry) {
+	l.subscribersLock.Lock()
+	defer l.subscribersLock.Unlock()
+
+	for s := range l.subscribers {
+		if !s.params.match(entry) {
+			continue
+		}
+		select {
+		case s.queue <- entry:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// writeEvent - send 1 Server-Sent Event
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// handleQueryLogStream - send new log entries to the client as they arrive (Server-Sent Events)
+// Events:
+// * "entry": log entry, the same object as in /control/querylog response
+// * "dropped": {"count":N} - N entries were not sent because the client was reading too slowly
+func (l *queryLog) handleQueryLogStream(w http.ResponseWriter, r *http.Request) {
+	params, err := l.parseSearchParams(r)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "failed to parse params: %s", err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(r, w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	s := l.subscribe(params)
+	if s == nil {
+		httpError(r, w, http.StatusServiceUnavailable, "too many stream connections")
+		return
+	}
+	defer l.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// gzip handler buffers small responses, so we must disable compression
+	w.Header().Set("Content-Encoding", "identity")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case entry := <-s.queue:
+			err = writeEvent(w, "entry", l.logEntryToJSONEntry(entry))
+
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": ping\n\n"))
+
+		case <-r.Context().Done():
+			return
+		}
+
+		if err == nil {
+			n := atomic.SwapUint64(&s.dropped, 0)
+			if n != 0 {
+				err = writeEvent(w, "dropped", map[string]uint64{"count": n})
+			}
+		}
+		if err != nil {
+			log.Debug("querylog: stream: %s", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
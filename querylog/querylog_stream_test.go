@@ -0,0 +1,93 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryLogStream(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	srv := httptest.NewServer(http.HandlerFunc(l.handleQueryLogStream))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?search=2.2.2.2")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// only the entries for the requested client are sent
+	addEntry(l, "example1.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example2.org", "1.1.1.2", "2.2.2.2")
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+
+	readLine := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(5 * time.Second):
+			return ""
+		}
+	}
+	assert.Equal(t, "event: entry", readLine())
+	data := readLine()
+	assert.True(t, strings.HasPrefix(data, "data: "))
+	m := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &m))
+	assert.Equal(t, "example2.org", m["question"].(map[string]interface{})["host"])
+	assert.Equal(t, "2.2.2.2", m["client"])
+}
+
+func TestQueryLogStreamBackpressure(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  1000,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	s := l.subscribe(newSearchParams())
+	assert.NotNil(t, s)
+
+	// nobody reads the entries: they are dropped when the queue is full
+	for i := 0; i != streamQueueSize+5; i++ {
+		addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	}
+	assert.Equal(t, streamQueueSize, len(s.queue))
+	assert.Equal(t, uint64(5), s.dropped)
+
+	l.unsubscribe(s)
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	assert.Equal(t, streamQueueSize, len(s.queue))
+
+	// the number of subscribers is limited
+	for i := 0; i != maxStreamSubscribers; i++ {
+		assert.NotNil(t, l.subscribe(newSearchParams()))
+	}
+	assert.Nil(t, l.subscribe(newSearchParams()))
+}
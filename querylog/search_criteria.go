@@ -1,6 +1,7 @@
 package querylog
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
@@ -11,8 +12,25 @@ type criteriaType int
 const (
 	ctDomainOrClient  criteriaType = iota // domain name or client IP address
 	ctFilteringStatus                     // filtering status
+	ctQuestionType                        // question type, e.g. "AAAA"
+	ctResponseCode                        // response code, e.g. "SERVFAIL"
+	ctUpstream                            // upstream server address
+	ctClientProto                         // protocol for the client connection
+	ctFilterID                            // ID of the filter list the matched rule belongs to
+	ctRule                                // text of the matched rule
 )
 
+const (
+	clientProtoPlain = "plain" // plain DNS (the protocol value is empty in log entries)
+	clientProtoDOH   = "doh"
+	clientProtoDOT   = "dot"
+)
+
+// clientProtoValues -- array with all possible client_proto values
+var clientProtoValues = []string{
+	clientProtoPlain, clientProtoDOH, clientProtoDOT,
+}
+
 const (
 	filteringStatusAll      = "all"
 	filteringStatusFiltered = "filtered" // all kinds of filtering
@@ -51,6 +69,15 @@ func (c *searchCriteria) quickMatch(line string) bool {
 	case ctDomainOrClient:
 		return c.quickMatchJSONValue(line, "QH") ||
 			c.quickMatchJSONValue(line, "IP")
+	case ctQuestionType:
+		return readJSONValue(line, "QT") == c.value
+	case ctUpstream:
+		return c.quickMatchJSONValue(line, "Upstream")
+	case ctClientProto:
+		if c.value == clientProtoPlain {
+			return true
+		}
+		return readJSONValue(line, "CP") == c.value
 	default:
 		return true
 	}
@@ -129,7 +156,46 @@ func (c *searchCriteria) match(entry *logEntry) bool {
 		default:
 			return false
 		}
+
+	case ctQuestionType:
+		return entry.QType == c.value
+
+	case ctResponseCode:
+		rcode, ok := getRcode(entry.Answer)
+		return ok && strconv.Itoa(rcode) == c.value
+
+	case ctUpstream:
+		return c.matchString(entry.Upstream)
+
+	case ctClientProto:
+		if c.value == clientProtoPlain {
+			return len(entry.ClientProto) == 0
+		}
+		return entry.ClientProto == c.value
+
+	case ctFilterID:
+		return strconv.FormatInt(entry.Result.FilterID, 10) == c.value
+
+	case ctRule:
+		return c.matchString(entry.Result.Rule)
 	}
 
 	return false
 }
+
+// matchString - checks if the value matches the search criteria (strictly or not)
+func (c *searchCriteria) matchString(val string) bool {
+	if c.strict {
+		return val == c.value
+	}
+	return strings.Contains(val, c.value)
+}
+
+// getRcode - get response code from the header of the packed DNS message
+// Note that the extended response code (from OPT record) isn't taken into account
+func getRcode(msg []byte) (int, bool) {
+	if len(msg) < 4 {
+		return 0, false
+	}
+	return int(msg[3] & 0x0f), true
+}
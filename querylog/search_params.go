@@ -11,6 +11,16 @@ type searchParams struct {
 	// if not set - disregard it and return any value
 	olderThan time.Time
 
+	// timeFrom, timeTo - absolute time range of the entries to return
+	// if not set - disregard it
+	timeFrom time.Time
+	timeTo   time.Time
+
+	// elapsedMin, elapsedMax - range of the request processing time
+	// if 0 - disregard it
+	elapsedMin time.Duration
+	elapsedMax time.Duration
+
 	offset             int // offset for the search
 	limit              int // limit the number of records returned
 	maxFileScanEntries int // maximum log entries to scan in query log files. if 0 - no limit
@@ -47,6 +57,20 @@ func (s *searchParams) match(entry *logEntry) bool {
 		return false
 	}
 
+	if !s.timeFrom.IsZero() && entry.Time.Before(s.timeFrom) {
+		return false
+	}
+	if !s.timeTo.IsZero() && entry.Time.After(s.timeTo) {
+		return false
+	}
+
+	if s.elapsedMin != 0 && entry.Elapsed < s.elapsedMin {
+		return false
+	}
+	if s.elapsedMax != 0 && entry.Elapsed > s.elapsedMax {
+		return false
+	}
+
 	for _, c := range s.searchCriteria {
 		if !c.match(entry) {
 			return false
@@ -55,3 +79,9 @@ func (s *searchParams) match(entry *logEntry) bool {
 
 	return true
 }
+
+// isTooOld - checks if the entry with this timestamp is older than the requested time range
+// entries are read from newer to older, so there's no need to read further after that
+func (s *searchParams) isTooOld(ts int64) bool {
+	return !s.timeFrom.IsZero() && ts != 0 && ts < s.timeFrom.UnixNano()
+}
@@ -0,0 +1,151 @@
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Sink types
+const (
+	SinkSyslog = "syslog" // RFC 5424 syslog over UDP, TCP or TLS
+	SinkHTTP   = "http"   // batched HTTP POST of JSON lines
+)
+
+// sinkQueueSize - max number of entries waiting to be sent
+const sinkQueueSize = 4096
+
+// SinkConfig - settings of a remote destination for log entries
+type SinkConfig struct {
+	Type string `yaml:"type"` // "syslog" or "http"
+
+	// syslog: udp://host:514, tcp://host:514, tls://host:6514
+	// http: URL which receives POST requests
+	Address string `yaml:"address"`
+
+	// The list of fields to send (names are the same as in "Get query log" API response)
+	// All fields are sent if the list is empty
+	Fields []string `yaml:"fields"`
+
+	// Anonymize clients' IP addresses in the entries sent to this sink
+	AnonymizeClientIP bool `yaml:"anonymize_client_ip"`
+
+	// http: max number of entries sent in 1 request
+	BatchSize uint32 `yaml:"batch_size"`
+	// http: max time (in seconds) the entries are waiting before they are sent
+	FlushInterval uint32 `yaml:"flush_interval"`
+	// http: max size (in bytes) of the file where entries are stored while the server is unavailable
+	BufferSize uint32 `yaml:"buffer_size"`
+}
+
+// sinkFields - all fields that may be sent to a sink
+var sinkFields = []string{
+	"time", "client", "client_proto", "question", "status", "answer_dnssec",
+	"reason", "rule", "filterId", "service_name", "answer", "original_answer",
+	"upstream", "elapsedMs",
+}
+
+// sink is a remote destination for log entries
+type sink interface {
+	// send queues the entry for sending; must not block
+	send(entry *logEntry)
+
+	// close sends the queued entries and stops the sink
+	close()
+}
+
+// sinkFormatter converts log entries to JSON objects according to the sink settings
+type sinkFormatter struct {
+	l       *queryLog
+	conf    SinkConfig
+	dropped uint64 // number of entries dropped because the queue is full (atomic)
+}
+
+// Convert the entry to JSON with the selected fields only
+func (f *sinkFormatter) format(entry *logEntry) ([]byte, error) {
+	jsonEntry := f.l.logEntryToJSONEntry(entry)
+	if f.conf.AnonymizeClientIP {
+		jsonEntry["client"] = anonymizeIP(entry.IP)
+	}
+
+	if len(f.conf.Fields) != 0 {
+		for k := range jsonEntry {
+			if !util.ContainsString(f.conf.Fields, k) {
+				delete(jsonEntry, k)
+			}
+		}
+	}
+
+	return json.Marshal(jsonEntry)
+}
+
+// Log a message about dropped entry
+func (f *sinkFormatter) drop() {
+	n := atomic.AddUint64(&f.dropped, 1)
+	if n == 1 || n%10000 == 0 {
+		log.Debug("querylog: %s sink %s: the queue is full, dropped %d entries", f.conf.Type, f.conf.Address, n)
+	}
+}
+
+// Check sink settings
+func checkSinkConfig(c SinkConfig) error {
+	if len(c.Address) == 0 {
+		return fmt.Errorf("no address")
+	}
+	for _, f := range c.Fields {
+		if !util.ContainsString(sinkFields, f) {
+			return fmt.Errorf("unknown field %s", f)
+		}
+	}
+	return nil
+}
+
+// Create sink objects
+func (l *queryLog) initSinks() {
+	sinks := []sink{}
+	for i, c := range l.conf.Sinks {
+		err := checkSinkConfig(c)
+		if err != nil {
+			log.Error("querylog: sink #%d: %s", i, err)
+			continue
+		}
+
+		var s sink
+		switch c.Type {
+		case SinkSyslog:
+			s, err = newSyslogSink(l, c)
+		case SinkHTTP:
+			bufFile := filepath.Join(l.conf.BaseDir, "querylog_sink_"+strconv.Itoa(i)+".buf")
+			s, err = newHTTPSink(l, c, bufFile)
+		default:
+			err = fmt.Errorf("unknown type %s", c.Type)
+		}
+		if err != nil {
+			log.Error("querylog: sink #%d: %s", i, err)
+			continue
+		}
+		sinks = append(sinks, s)
+	}
+
+	l.sinksLock.Lock()
+	l.sinks = sinks
+	l.sinksLock.Unlock()
+}
+
+// Stop all sinks
+// The sinks are removed first, so the new entries aren't sent to the stopped sinks.
+func (l *queryLog) closeSinks() {
+	l.sinksLock.Lock()
+	sinks := l.sinks
+	l.sinks = nil
+	l.sinksLock.Unlock()
+
+	for _, s := range sinks {
+		s.close()
+	}
+}
@@ -0,0 +1,257 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	httpSinkBatchSize     = 100              // default max number of entries in 1 request
+	httpSinkFlushInterval = 5                // default flush interval (in seconds)
+	httpSinkBufferSize    = 10 * 1024 * 1024 // default max size of the on-disk buffer
+	httpSinkTimeout       = 30 * time.Second
+	httpSinkRetries       = 3 // number of attempts to send a batch
+)
+
+// httpSinkRetryDelay - time to wait before the next attempt (doubled after each attempt)
+var httpSinkRetryDelay = time.Second
+
+// httpSinkStopTimeout - time for sending the remaining entries when the sink stops
+var httpSinkStopTimeout = 3 * time.Second
+
+// httpSink sends log entries to an HTTP server in batches:
+// POST request with JSON objects, one per line.
+// If the server isn't available, the entries are stored in a file and are sent later.
+type httpSink struct {
+	sinkFormatter
+	url        string
+	bufFile    string // file where entries are stored while the server is unavailable
+	batchSize  int
+	interval   time.Duration
+	bufferSize int64
+	client     *http.Client
+
+	queue chan *logEntry
+	stop  chan struct{} // closed when the sink must stop
+	done  chan struct{} // closed when the worker exits
+}
+
+func newHTTPSink(l *queryLog, c SinkConfig, bufFile string) (*httpSink, error) {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported address %s", c.Address)
+	}
+
+	s := &httpSink{
+		sinkFormatter: sinkFormatter{l: l, conf: c},
+		url:           c.Address,
+		bufFile:       bufFile,
+		batchSize:     int(c.BatchSize),
+		interval:      time.Duration(c.FlushInterval) * time.Second,
+		bufferSize:    int64(c.BufferSize),
+		client:        &http.Client{Timeout: httpSinkTimeout},
+		queue:         make(chan *logEntry, sinkQueueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if s.batchSize == 0 {
+		s.batchSize = httpSinkBatchSize
+	}
+	if s.interval == 0 {
+		s.interval = httpSinkFlushInterval * time.Second
+	}
+	if s.bufferSize == 0 {
+		s.bufferSize = httpSinkBufferSize
+	}
+
+	go s.run()
+	return s, nil
+}
+
+func (s *httpSink) send(entry *logEntry) {
+	select {
+	case s.queue <- entry:
+	default:
+		s.drop()
+	}
+}
+
+func (s *httpSink) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// Send 1 request
+func (s *httpSink) post(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: status code %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// Send data, retry on failure
+func (s *httpSink) postRetry(data []byte) error {
+	delay := httpSinkRetryDelay
+	var err error
+	for i := 0; i != httpSinkRetries; i++ {
+		if i != 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		err = s.post(context.Background(), data)
+		if err == nil {
+			return nil
+		}
+		log.Debug("querylog: http sink: %s", err)
+	}
+	return err
+}
+
+// Send the entries stored on disk
+func (s *httpSink) sendBuffered(ctx context.Context) error {
+	data, err := ioutil.ReadFile(s.bufFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// send in batches, don't split lines
+	for len(data) != 0 {
+		n := 0
+		end := 0
+		for end < len(data) && n != s.batchSize {
+			i := bytes.IndexByte(data[end:], '\n')
+			if i == -1 {
+				end = len(data)
+				break
+			}
+			end += i + 1
+			n++
+		}
+
+		err = s.post(ctx, data[:end])
+		if err != nil {
+			// store the rest of data for the next attempt
+			werr := ioutil.WriteFile(s.bufFile, data, 0644)
+			if werr != nil {
+				log.Error("querylog: http sink: %s", werr)
+			}
+			return err
+		}
+		data = data[end:]
+	}
+
+	return os.Remove(s.bufFile)
+}
+
+// Store the entries on disk
+func (s *httpSink) storeBuffered(data []byte) {
+	st, err := os.Stat(s.bufFile)
+	if err == nil && st.Size()+int64(len(data)) > s.bufferSize {
+		log.Debug("querylog: http sink: the buffer file is full, dropping %d bytes", len(data))
+		return
+	}
+
+	f, err := os.OpenFile(s.bufFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Error("querylog: http sink: %s", err)
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		log.Error("querylog: http sink: %s", err)
+	}
+}
+
+// Send a batch of entries
+// The previously stored entries are sent first to preserve the order.
+func (s *httpSink) flush(batch []byte) {
+	err := s.sendBuffered(context.Background())
+	if err == nil && len(batch) != 0 {
+		err = s.postRetry(batch)
+	}
+	if err != nil && len(batch) != 0 {
+		s.storeBuffered(batch)
+	}
+}
+
+// Worker: collect entries into batches and send them
+func (s *httpSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var batch []byte
+	n := 0
+	add := func(entry *logEntry) {
+		data, err := s.format(entry)
+		if err != nil {
+			log.Debug("querylog: http sink: %s", err)
+			return
+		}
+		batch = append(batch, data...)
+		batch = append(batch, '\n')
+		n++
+	}
+
+	for {
+		select {
+		case entry := <-s.queue:
+			add(entry)
+			if n < s.batchSize {
+				continue
+			}
+
+		case <-ticker.C:
+			if n == 0 {
+				// try to send the stored entries, if any
+				_ = s.sendBuffered(context.Background())
+				continue
+			}
+
+		case <-s.stop:
+			for len(s.queue) != 0 {
+				add(<-s.queue)
+			}
+			if n != 0 {
+				// don't retry and don't wait for the server for long: if it isn't available,
+				// store the entries so that they are sent after restart
+				ctx, cancel := context.WithTimeout(context.Background(), httpSinkStopTimeout)
+				if s.sendBuffered(ctx) != nil || s.post(ctx, batch) != nil {
+					s.storeBuffered(batch)
+				}
+				cancel()
+			}
+			return
+		}
+
+		s.flush(batch)
+		batch = nil
+		n = 0
+	}
+}
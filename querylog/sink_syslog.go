@@ -0,0 +1,163 @@
+package querylog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	syslogPriority     = 16*8 + 6 // facility: local0, severity: informational
+	syslogAppName      = "AdGuardHome"
+	syslogMsgID        = "querylog"
+	syslogTimeFormat   = "2006-01-02T15:04:05.000000Z07:00"
+	syslogReconnect    = 5 * time.Second
+	syslogWriteTimeout = 10 * time.Second
+)
+
+// syslogSink sends log entries to a syslog server (RFC 5424)
+// UDP: 1 message per datagram
+// TCP, TLS: octet-counting framing (RFC 6587)
+type syslogSink struct {
+	sinkFormatter
+	network  string // "udp", "tcp" or "tls"
+	addr     string
+	hostname string
+
+	queue chan *logEntry
+	stop  chan struct{} // closed when the sink must stop
+	done  chan struct{} // closed when the worker exits
+}
+
+func newSyslogSink(l *queryLog, c SinkConfig) (*syslogSink, error) {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported address %s", c.Address)
+	}
+	if len(u.Port()) == 0 {
+		return nil, fmt.Errorf("no port in address %s", c.Address)
+	}
+
+	s := &syslogSink{
+		sinkFormatter: sinkFormatter{l: l, conf: c},
+		network:       u.Scheme,
+		addr:          u.Host,
+		hostname:      "-",
+		queue:         make(chan *logEntry, sinkQueueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	h, err := os.Hostname()
+	if err == nil && len(h) != 0 {
+		s.hostname = h
+	}
+
+	go s.run()
+	return s, nil
+}
+
+func (s *syslogSink) send(entry *logEntry) {
+	select {
+	case s.queue <- entry:
+	default:
+		s.drop()
+	}
+}
+
+func (s *syslogSink) close() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *syslogSink) connect() (net.Conn, error) {
+	if s.network == "tls" {
+		d := &net.Dialer{Timeout: syslogWriteTimeout}
+		return tls.DialWithDialer(d, "tcp", s.addr, &tls.Config{})
+	}
+	return net.DialTimeout(s.network, s.addr, syslogWriteTimeout)
+}
+
+// Create syslog message:
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *syslogSink) message(entry *logEntry) ([]byte, error) {
+	data, err := s.format(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		syslogPriority, entry.Time.Format(syslogTimeFormat), s.hostname, syslogAppName, os.Getpid(), syslogMsgID)
+	b := append([]byte(msg), data...)
+
+	if s.network != "udp" {
+		// octet counting: "LENGTH MSG"
+		b = append([]byte(strconv.Itoa(len(b))+" "), b...)
+	}
+	return b, nil
+}
+
+// Worker: send queued entries
+func (s *syslogSink) run() {
+	defer close(s.done)
+
+	var conn net.Conn
+	var lastConnect time.Time
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		var entry *logEntry
+		select {
+		case entry = <-s.queue:
+		case <-s.stop:
+			// send the remaining entries
+			select {
+			case entry = <-s.queue:
+			default:
+				return
+			}
+		}
+
+		b, err := s.message(entry)
+		if err != nil {
+			log.Debug("querylog: syslog: %s", err)
+			continue
+		}
+
+		if conn == nil {
+			if time.Since(lastConnect) < syslogReconnect {
+				s.drop()
+				continue
+			}
+			lastConnect = time.Now()
+			conn, err = s.connect()
+			if err != nil {
+				log.Debug("querylog: syslog: %s", err)
+				s.drop()
+				continue
+			}
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		_, err = conn.Write(b)
+		if err != nil {
+			log.Debug("querylog: syslog: %s", err)
+			_ = conn.Close()
+			conn = nil
+		}
+	}
+}
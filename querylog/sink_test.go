@@ -0,0 +1,216 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinkSyslog(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+		Sinks: []SinkConfig{{
+			Type:              SinkSyslog,
+			Address:           "udp://" + ln.LocalAddr().String(),
+			Fields:            []string{"client", "question"},
+			AnonymizeClientIP: true,
+		}},
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	l.initSinks()
+	defer l.closeSinks()
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+
+	_ = ln.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := ln.ReadFrom(buf)
+	assert.Nil(t, err)
+	msg := string(buf[:n])
+
+	assert.True(t, strings.HasPrefix(msg, "<134>1 "))
+	assert.Contains(t, msg, " AdGuardHome ")
+	assert.Contains(t, msg, " querylog - ")
+
+	m := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(msg[strings.Index(msg, "{"):]), &m))
+	assert.Equal(t, 2, len(m))
+	assert.Equal(t, "2.2.0.0", m["client"])
+	assert.Equal(t, "example.org", m["question"].(map[string]interface{})["host"])
+}
+
+func TestSinkHTTP(t *testing.T) {
+	httpSinkRetryDelay = time.Millisecond
+
+	var lock sync.Mutex
+	available := false
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+	}))
+	defer srv.Close()
+
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+		Sinks: []SinkConfig{{
+			Type:      SinkHTTP,
+			Address:   srv.URL,
+			Fields:    []string{"question"},
+			BatchSize: 2,
+		}},
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	l.initSinks()
+	s := l.sinks[0].(*httpSink)
+
+	// the server isn't available: the entries are stored on disk
+	addEntry(l, "example1.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example2.org", "1.1.1.1", "2.2.2.1")
+	for i := 0; i != 100 && !util.FileExists(s.bufFile); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, err := ioutil.ReadFile(s.bufFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	// the server is available: the stored entries are sent first
+	lock.Lock()
+	available = true
+	lock.Unlock()
+	addEntry(l, "example3.org", "1.1.1.1", "2.2.2.1")
+	l.closeSinks()
+
+	assert.False(t, util.FileExists(s.bufFile))
+	assert.Equal(t, 3, len(lines))
+	for i, host := range []string{"example1.org", "example2.org", "example3.org"} {
+		m := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal([]byte(lines[i]), &m))
+		assert.Equal(t, 1, len(m))
+		assert.Equal(t, host, m["question"].(map[string]interface{})["host"])
+	}
+}
+
+// The sink doesn't wait for the server for long when it stops: the entries are stored on disk
+func TestSinkHTTPStop(t *testing.T) {
+	httpSinkStopTimeout = 100 * time.Millisecond
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+		Sinks: []SinkConfig{{
+			Type:          SinkHTTP,
+			Address:       srv.URL,
+			Fields:        []string{"question"},
+			FlushInterval: 3600,
+		}},
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	l.initSinks()
+	s := l.sinks[0].(*httpSink)
+
+	addEntry(l, "example1.org", "1.1.1.1", "2.2.2.1")
+	start := time.Now()
+	l.closeSinks()
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	data, err := ioutil.ReadFile(s.bufFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+}
+
+func TestSinkConfig(t *testing.T) {
+	assert.NotNil(t, checkSinkConfig(SinkConfig{Type: SinkHTTP}))
+	assert.NotNil(t, checkSinkConfig(SinkConfig{Type: SinkHTTP, Address: "http://127.0.0.1", Fields: []string{"unknown"}}))
+	assert.Nil(t, checkSinkConfig(SinkConfig{Type: SinkHTTP, Address: "http://127.0.0.1", Fields: []string{"client"}}))
+
+	_, err := newSyslogSink(nil, SinkConfig{Type: SinkSyslog, Address: "udp://127.0.0.1"})
+	assert.NotNil(t, err)
+	_, err = newHTTPSink(nil, SinkConfig{Type: SinkHTTP, Address: "ftp://127.0.0.1"}, "")
+	assert.NotNil(t, err)
+}
+
+func TestSinkClose(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+		Sinks: []SinkConfig{{
+			Type:    SinkSyslog,
+			Address: "udp://" + ln.LocalAddr().String(),
+		}},
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	l.initSinks()
+
+	// the entries are added while the sinks are stopped and started again
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+			}
+		}
+	}()
+	for i := 0; i != 10; i++ {
+		l.closeSinks()
+		l.initSinks()
+	}
+	l.closeSinks()
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, 0, len(l.sinks))
+}
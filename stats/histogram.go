@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"math"
+)
+
+// relative accuracy of the values returned by histogram.quantile()
+const histAccuracy = 0.02
+
+var (
+	histGamma    = (1 + histAccuracy) / (1 - histAccuracy)
+	histLogGamma = math.Log(histGamma)
+)
+
+// histogram - compact logarithmic histogram of processing time values (as in DDSketch)
+// Bucket N contains the number of values in range (gamma^(N-1) .. gamma^N],
+// so that any quantile is returned with the relative error not larger than histAccuracy.
+// Only the buckets from the first non-empty to the last non-empty one are stored.
+// The fields are exported so that the object can be stored in file.
+type histogram struct {
+	Offset int32    // index of the first bucket
+	Counts []uint64 // number of values per bucket
+}
+
+// Get bucket index for the value
+func histIndex(v uint32) int32 {
+	if v <= 1 {
+		return 0
+	}
+	return int32(math.Ceil(math.Log(float64(v)) / histLogGamma))
+}
+
+// Get the value that represents all values in the bucket
+func histValue(i int32) float64 {
+	if i == 0 {
+		return 1
+	}
+	return 2 * math.Pow(histGamma, float64(i)) / (histGamma + 1)
+}
+
+// Add n values to the bucket
+func (h *histogram) addN(i int32, n uint64) {
+	if len(h.Counts) == 0 {
+		h.Offset = i
+		h.Counts = []uint64{n}
+		return
+	}
+
+	if i < h.Offset {
+		counts := make([]uint64, int(h.Offset-i)+len(h.Counts))
+		copy(counts[h.Offset-i:], h.Counts)
+		h.Counts = counts
+		h.Offset = i
+	} else if int(i-h.Offset) >= len(h.Counts) {
+		counts := make([]uint64, int(i-h.Offset)+1)
+		copy(counts, h.Counts)
+		h.Counts = counts
+	}
+	h.Counts[i-h.Offset] += n
+}
+
+// Get a copy of the histogram
+func (h *histogram) clone() histogram {
+	c := histogram{Offset: h.Offset}
+	if len(h.Counts) != 0 {
+		c.Counts = make([]uint64, len(h.Counts))
+		copy(c.Counts, h.Counts)
+	}
+	return c
+}
+
+// Add a value
+func (h *histogram) add(v uint32) {
+	h.addN(histIndex(v), 1)
+}
+
+// Add all values from another histogram
+func (h *histogram) merge(o histogram) {
+	for i, n := range o.Counts {
+		if n != 0 {
+			h.addN(o.Offset+int32(i), n)
+		}
+	}
+}
+
+// Get the value at quantile q (0..1)
+// Returns 0 if there are no values
+func (h *histogram) quantile(q float64) float64 {
+	var total uint64
+	for _, n := range h.Counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(total-1))
+	var sum uint64
+	for i, n := range h.Counts {
+		sum += n
+		if sum > rank {
+			return histValue(h.Offset + int32(i))
+		}
+	}
+	return histValue(h.Offset + int32(len(h.Counts)-1))
+}
+
+// Get p50, p90, p99 values (in seconds)
+func (h *histogram) percentiles() map[string]float64 {
+	return map[string]float64{
+		"p50": h.quantile(0.5) / 1000000,
+		"p90": h.quantile(0.9) / 1000000,
+		"p99": h.quantile(0.99) / 1000000,
+	}
+}
@@ -5,13 +5,20 @@ package stats
 import (
 	"net"
 	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
 )
 
 type unitIDCallback func() uint32
 
 // DiskConfig - configuration settings that are stored on disk
 type DiskConfig struct {
-	Interval uint32 `yaml:"statistics_interval"` // time interval for statistics (in days)
+	Interval    uint32 `yaml:"statistics_interval"`   // time interval for statistics (in days)
+	PerClient   bool   `yaml:"statistics_per_client"` // collect statistics for each client separately
+	MinuteUnits uint32 `yaml:"statistics_minutes"`    // number of minutes for which minute-resolution data is kept
+
+	IgnoredDomains []string `yaml:"statistics_ignored_domains"` // domain names and wildcard patterns which requests aren't counted
+	IgnoredClients []string `yaml:"statistics_ignored_clients"` // IP addresses and subnets of the clients which requests aren't counted
 }
 
 // Config - module configuration
@@ -20,6 +27,11 @@ type Config struct {
 	LimitDays         uint32         // time limit (in days)
 	UnitID            unitIDCallback // user function to get the current unit ID.  If nil, the current time hour is used.
 	AnonymizeClientIP bool           // anonymize clients' IP addresses
+	PerClient         bool           // collect statistics for each client separately
+	MinuteUnits       uint32         // number of minutes for which minute-resolution data is kept (default: 60)
+	MinuteID          unitIDCallback // user function to get the current minute ID.  If nil, the current time minute is used.
+	IgnoredDomains    []string       // domain names and wildcard patterns (e.g. "*.example.org") which requests aren't counted
+	IgnoredClients    []string       // IP addresses and subnets of the clients which requests aren't counted
 
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func()
@@ -27,7 +39,8 @@ type Config struct {
 	// Register an HTTP handler
 	HTTPRegister func(string, string, func(http.ResponseWriter, *http.Request))
 
-	limit uint32 // maximum time we need to keep data for (in hours)
+	limit   uint32           // maximum time we need to keep data for (in hours)
+	ignored *util.IgnoreList // requests which aren't counted
 }
 
 // New - create object
@@ -47,6 +60,9 @@ type Stats interface {
 	// Update counters
 	Update(e Entry)
 
+	// Update counters for an upstream server
+	UpdateUpstream(e UpstreamEntry)
+
 	// Get IP addresses of the clients with the most number of requests
 	GetTopClientsIP(limit uint) []string
 
@@ -82,4 +98,17 @@ type Entry struct {
 	Client net.IP
 	Result Result
 	Time   uint32 // processing time (msec)
+
+	QType  string // question type, e.g. "A"
+	RCode  string // response code, e.g. "NOERROR" (empty if there's no response)
+	Proto  string // client protocol: "plain", "doh", "dot"
+	Cached bool   // the response was taken from the cache
+}
+
+// UpstreamEntry - data about 1 request to an upstream server
+type UpstreamEntry struct {
+	Upstream string // upstream server address
+	Time     uint32 // response time (usec)
+	Error    bool   // the request failed
+	Timeout  bool   // the request failed because the server didn't respond in time
 }
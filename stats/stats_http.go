@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/golibs/jsonutil"
 	"github.com/AdguardTeam/golibs/log"
 )
 
@@ -20,9 +22,34 @@ func httpError(r *http.Request, w http.ResponseWriter, code int, format string,
 }
 
 // Return data
+// If "client" parameter is set, return data only for this client
+// If "from", "to" or "step" parameter is set, return data for the specified time range
 func (s *statsCtx) handleStats(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	d := s.getData()
+	var d map[string]interface{}
+	q := r.URL.Query()
+	client := q.Get("client")
+	rp, err := s.parseRangeParams(q)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if rp != nil {
+		if len(client) != 0 {
+			httpError(r, w, http.StatusBadRequest, "client can't be used with time range parameters")
+			return
+		}
+		d, err = s.getRangeData(rp)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	} else if len(client) != 0 {
+		d = s.getClientData(client)
+	} else {
+		d = s.getData()
+	}
 	log.Debug("Stats: prepared data in %v", time.Since(start))
 
 	if d == nil {
@@ -41,13 +68,25 @@ func (s *statsCtx) handleStats(w http.ResponseWriter, r *http.Request) {
 }
 
 type config struct {
-	IntervalDays uint32 `json:"interval"`
+	IntervalDays   uint32   `json:"interval"`
+	PerClient      bool     `json:"per_client"`
+	IgnoredDomains []string `json:"ignored_domains"`
+	IgnoredClients []string `json:"ignored_clients"`
 }
 
 // Get configuration
 func (s *statsCtx) handleStatsInfo(w http.ResponseWriter, r *http.Request) {
 	resp := config{}
 	resp.IntervalDays = s.conf.limit / 24
+	resp.PerClient = s.conf.PerClient
+	resp.IgnoredDomains = s.conf.IgnoredDomains
+	resp.IgnoredClients = s.conf.IgnoredClients
+	if resp.IgnoredDomains == nil {
+		resp.IgnoredDomains = []string{}
+	}
+	if resp.IgnoredClients == nil {
+		resp.IgnoredClients = []string{}
+	}
 
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -64,18 +103,40 @@ func (s *statsCtx) handleStatsInfo(w http.ResponseWriter, r *http.Request) {
 // Set configuration
 func (s *statsCtx) handleStatsConfig(w http.ResponseWriter, r *http.Request) {
 	reqData := config{}
-	err := json.NewDecoder(r.Body).Decode(&reqData)
+	req, err := jsonutil.DecodeObject(&reqData, r.Body)
 	if err != nil {
 		httpError(r, w, http.StatusBadRequest, "json decode: %s", err)
 		return
 	}
 
-	if !checkInterval(reqData.IntervalDays) {
+	if req.Exists("interval") && !checkInterval(reqData.IntervalDays) {
 		httpError(r, w, http.StatusBadRequest, "Unsupported interval")
 		return
 	}
 
-	s.setLimit(int(reqData.IntervalDays))
+	domains := s.conf.IgnoredDomains
+	if req.Exists("ignored_domains") {
+		domains = reqData.IgnoredDomains
+	}
+	clients := s.conf.IgnoredClients
+	if req.Exists("ignored_clients") {
+		clients = reqData.IgnoredClients
+	}
+	ignored, err := util.NewIgnoreList(domains, clients)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "ignore list: %s", err)
+		return
+	}
+
+	if req.Exists("interval") {
+		s.setLimit(int(reqData.IntervalDays))
+	}
+	if req.Exists("per_client") {
+		s.setPerClient(reqData.PerClient)
+	}
+	if req.Exists("ignored_domains") || req.Exists("ignored_clients") {
+		s.setIgnored(domains, clients, ignored)
+	}
 	s.conf.ConfigModified()
 }
 
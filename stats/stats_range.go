@@ -0,0 +1,161 @@
+package stats
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRangePoints = 2000         // max number of values in per-step arrays
+	maxRangeUnits  = 366 * 24 * 2 // max number of units for 1 request
+)
+
+// rangeParams - parameters for getting data for an arbitrary time range
+type rangeParams struct {
+	from time.Time     // start of the range (inclusive)
+	to   time.Time     // end of the range (exclusive)
+	step time.Duration // time interval for 1 value in per-step arrays
+
+	fromSet bool // "from" is set by user;  otherwise the range starts with the oldest available data
+}
+
+// parseStep - parse time interval: "1m", "90m", "1h", "1d", etc.
+func parseStep(val string) (time.Duration, error) {
+	if strings.HasSuffix(val, "d") {
+		days, err := strconv.ParseUint(val[:len(val)-1], 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(val)
+}
+
+// parseRangeParams - parse "from", "to" and "step" query parameters
+// Returns nil if none of them is set
+func (s *statsCtx) parseRangeParams(q url.Values) (*rangeParams, error) {
+	from := q.Get("from")
+	to := q.Get("to")
+	step := q.Get("step")
+	if len(from) == 0 && len(to) == 0 && len(step) == 0 {
+		return nil, nil
+	}
+
+	var err error
+	p := rangeParams{
+		to:   time.Now(),
+		step: time.Hour,
+	}
+	if len(to) != 0 {
+		p.to, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to value %s", to)
+		}
+	}
+	if len(step) != 0 {
+		p.step, err = parseStep(step)
+		if err != nil || p.step < time.Minute || p.step%time.Minute != 0 {
+			return nil, fmt.Errorf("invalid step value %s", step)
+		}
+	}
+
+	// by default, return all available data
+	if p.step%time.Hour == 0 {
+		p.from = p.to.Add(-time.Duration(s.conf.limit-1) * time.Hour)
+	} else {
+		p.from = p.to.Add(-time.Duration(len(s.minutes)-1) * time.Minute)
+	}
+	if len(from) != 0 {
+		p.from, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from value %s", from)
+		}
+		p.fromSet = true
+	}
+
+	if !p.from.Before(p.to) {
+		return nil, fmt.Errorf("from must be earlier than to")
+	}
+	return &p, nil
+}
+
+// Get the array of per-step values: sum up the values of each n consecutive units
+func sumUnits(units []*unitDB, n int, get func(u *unitDB) uint64) []uint64 {
+	a := []uint64{}
+	for i := 0; i < len(units); i += n {
+		var sum uint64
+		for _, u := range units[i : i+n] {
+			sum += get(u)
+		}
+		a = append(a, sum)
+	}
+	return a
+}
+
+// Get data for an arbitrary time range:
+// . If the step is a multiple of 1 hour, the hourly units are used.
+// . Otherwise, the minute-resolution units are used: they are kept only for the last N minutes.
+// . The range is extended so that it's aligned to the step (from the beginning of Unix time).
+// . If "from" isn't set, the minute-resolution range starts with the first step which has the data.
+// . per-step counters: DNS-queries, blocked, safebrowsing-blocked, parental-blocked, SERVFAIL
+// . top counters and breakdowns (only for the hourly units)
+// . total counters
+func (s *statsCtx) getRangeData(p *rangeParams) (map[string]interface{}, error) {
+	base := time.Minute
+	if p.step%time.Hour == 0 {
+		base = time.Hour
+	}
+	n := int64(p.step / base) // number of units in 1 step
+
+	// unit IDs: [first..last)
+	first := p.from.Unix() / int64(base/time.Second) / n * n
+	last := (p.to.Unix() + int64(base/time.Second) - 1) / int64(base/time.Second)
+	last = (last + n - 1) / n * n
+	if (last-first)/n > maxRangePoints || last-first > maxRangeUnits {
+		return nil, fmt.Errorf("the time range is too large")
+	}
+
+	var units []*unitDB
+	if base == time.Hour {
+		units = s.loadUnitRange(uint32(first), uint32(last-1))
+		if units == nil {
+			return nil, fmt.Errorf("couldn't load data")
+		}
+	} else {
+		oldest := int64(s.conf.MinuteID()) - int64(len(s.minutes)) + 1
+		if first < oldest && !p.fromSet {
+			first = (oldest + n - 1) / n * n
+		}
+		if first < oldest || first >= last {
+			return nil, fmt.Errorf("minute-resolution data is available only for the last %d minutes", len(s.minutes))
+		}
+		units = s.loadMinuteRange(uint32(first), uint32(last-1))
+	}
+
+	d := map[string]interface{}{}
+	d["from"] = time.Unix(first*int64(base/time.Second), 0).UTC().Format(time.RFC3339)
+	d["to"] = time.Unix(last*int64(base/time.Second), 0).UTC().Format(time.RFC3339)
+	d["step"] = uint64(p.step / time.Second)
+
+	d["dns_queries"] = sumUnits(units, int(n), func(u *unitDB) uint64 { return u.NTotal })
+	d["blocked_filtering"] = sumUnits(units, int(n),
+		func(u *unitDB) uint64 { return u.NResult[RFiltered] })
+	d["replaced_safebrowsing"] = sumUnits(units, int(n),
+		func(u *unitDB) uint64 { return u.NResult[RSafeBrowsing] })
+	d["replaced_parental"] = sumUnits(units, int(n),
+		func(u *unitDB) uint64 { return u.NResult[RParental] })
+	d["servfail"] = sumUnits(units, int(n),
+		func(u *unitDB) uint64 { return findCount(u.RCodes, "SERVFAIL") })
+
+	if base == time.Hour {
+		addTopData(d, units)
+		addBreakdownData(d, units)
+		addUpstreamData(d, units)
+	}
+	addTotalData(d, units)
+	addLatencyData(d, units)
+	return d, nil
+}
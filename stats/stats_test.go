@@ -2,10 +2,13 @@ package stats
 
 import (
 	"fmt"
+	"math"
 	"net"
+	"net/url"
 	"os"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -82,6 +85,309 @@ func TestStats(t *testing.T) {
 	os.Remove(conf.Filename)
 }
 
+func TestStatsPerClient(t *testing.T) {
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+		PerClient: true,
+	}
+	s, _ := createObject(conf)
+
+	e := Entry{}
+	e.Domain = "domain"
+	e.Client = net.ParseIP("127.0.0.1")
+	e.Result = RFiltered
+	s.Update(e)
+
+	e.Domain = "domain2"
+	e.Result = RNotFiltered
+	s.Update(e)
+
+	e.Client = net.ParseIP("127.0.0.2")
+	s.Update(e)
+	// only the requests blocked by filters are counted as blocked
+	e.Result = RSafeSearch
+	s.Update(e)
+	e.Result = RNotFiltered
+
+	d := s.getClientData("127.0.0.1")
+	assert.Equal(t, "127.0.0.1", d["client"])
+	assert.Equal(t, uint64(2), d["num_dns_queries"])
+	assert.Equal(t, uint64(1), d["num_blocked_filtering"])
+	a := d["dns_queries"].([]uint64)
+	assert.Equal(t, uint64(2), a[len(a)-1])
+	a = d["blocked_filtering"].([]uint64)
+	assert.Equal(t, uint64(1), a[len(a)-1])
+	m := d["top_queried_domains"].([]map[string]uint64)
+	assert.Equal(t, 2, len(m))
+
+	d = s.getClientData("127.0.0.2")
+	assert.Equal(t, uint64(2), d["num_dns_queries"])
+	assert.Equal(t, uint64(0), d["num_blocked_filtering"])
+
+	d = s.getClientData("127.0.0.3")
+	assert.Equal(t, uint64(0), d["num_dns_queries"])
+
+	// per-client data isn't collected when disabled
+	s.setPerClient(false)
+	s.Update(e)
+	d = s.getClientData("127.0.0.2")
+	assert.Equal(t, uint64(2), d["num_dns_queries"])
+
+	s.clear()
+	s.Close()
+	os.Remove(conf.Filename)
+}
+
+func TestStatsBreakdown(t *testing.T) {
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+	}
+	os.Remove(conf.Filename)
+	s, _ := createObject(conf)
+
+	e := Entry{}
+	e.Domain = "domain"
+	e.Client = net.ParseIP("127.0.0.1")
+	e.Result = RNotFiltered
+	e.QType = "A"
+	e.RCode = "NOERROR"
+	e.Proto = "plain"
+	s.Update(e)
+	e.Cached = true
+	s.Update(e)
+	e.Cached = false
+	e.QType = "AAAA"
+	e.RCode = "SERVFAIL"
+	e.Proto = "doh"
+	s.Update(e)
+
+	d := s.getData()
+	assert.Equal(t, []map[string]uint64{{"A": 2}, {"AAAA": 1}}, d["query_types"])
+	assert.Equal(t, []map[string]uint64{{"NOERROR": 2}, {"SERVFAIL": 1}}, d["response_codes"])
+	assert.Equal(t, []map[string]uint64{{"plain": 2}, {"doh": 1}}, d["client_protos"])
+	assert.Equal(t, uint64(1), d["num_cached"])
+	a := d["servfail"].([]uint64)
+	assert.Equal(t, uint64(1), a[len(a)-1])
+
+	// the data is stored in DB
+	s.Close()
+	s, _ = createObject(conf)
+	d = s.getData()
+	assert.Equal(t, []map[string]uint64{{"A": 2}, {"AAAA": 1}}, d["query_types"])
+	assert.Equal(t, uint64(1), d["num_cached"])
+
+	s.Close()
+	os.Remove(conf.Filename)
+}
+
+func TestStatsIgnored(t *testing.T) {
+	conf := Config{
+		Filename:       "./stats.db",
+		LimitDays:      1,
+		IgnoredDomains: []string{"*.health.example.org"},
+		IgnoredClients: []string{"10.0.0.0/8"},
+	}
+	os.Remove(conf.Filename)
+	s, _ := createObject(conf)
+
+	e := Entry{}
+	e.Domain = "check.health.example.org"
+	e.Client = net.ParseIP("127.0.0.1")
+	e.Result = RNotFiltered
+	s.Update(e)
+	e.Domain = "domain"
+	e.Client = net.ParseIP("10.1.1.1")
+	s.Update(e)
+	e.Client = net.ParseIP("127.0.0.1")
+	s.Update(e)
+
+	d := s.getData()
+	assert.Equal(t, uint64(1), d["num_dns_queries"])
+
+	dc := DiskConfig{}
+	s.WriteDiskConfig(&dc)
+	assert.Equal(t, []string{"*.health.example.org"}, dc.IgnoredDomains)
+	assert.Equal(t, []string{"10.0.0.0/8"}, dc.IgnoredClients)
+
+	s.Close()
+	os.Remove(conf.Filename)
+}
+
+func TestHistogram(t *testing.T) {
+	h := histogram{}
+	assert.Equal(t, float64(0), h.quantile(0.5))
+
+	// 1..1000 msec
+	for i := uint32(1000000); i != 0; i -= 1000 {
+		h.add(i)
+	}
+	for q, exp := range map[float64]float64{0.5: 500000, 0.9: 900000, 0.99: 990000} {
+		v := h.quantile(q)
+		assert.True(t, math.Abs(v-exp)/exp <= histAccuracy+0.001, "q=%f v=%f", q, v)
+	}
+
+	// the buckets are stored compactly
+	assert.True(t, len(h.Counts) < 200)
+
+	h2 := histogram{}
+	h2.add(1)
+	h2.merge(h)
+	assert.Equal(t, int32(0), h2.Offset)
+	assert.Equal(t, float64(1), h2.quantile(0))
+	assert.Equal(t, h.quantile(1), h2.quantile(1))
+}
+
+func TestStatsLatency(t *testing.T) {
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+	}
+	os.Remove(conf.Filename)
+	s, _ := createObject(conf)
+
+	e := Entry{}
+	e.Domain = "domain"
+	e.Client = net.ParseIP("127.0.0.1")
+	e.Result = RNotFiltered
+	for i := 0; i != 100; i++ {
+		e.Time = 10000 // 10ms
+		if i >= 90 {
+			e.Time = 100000
+		}
+		s.Update(e)
+	}
+
+	d := s.getData()
+	p := d["processing_time_percentiles"].(map[string]float64)
+	assert.InDelta(t, 0.01, p["p50"], 0.01*histAccuracy)
+	assert.InDelta(t, 0.1, p["p99"], 0.1*histAccuracy)
+
+	s.Close()
+	os.Remove(conf.Filename)
+}
+
+func TestStatsUpstreams(t *testing.T) {
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+	}
+	os.Remove(conf.Filename)
+	s, _ := createObject(conf)
+
+	for i := 0; i != 10; i++ {
+		s.UpdateUpstream(UpstreamEntry{Upstream: "1.1.1.1:53", Time: 10000})
+	}
+	s.UpdateUpstream(UpstreamEntry{Upstream: "8.8.8.8:53", Time: 100000})
+	s.UpdateUpstream(UpstreamEntry{Upstream: "8.8.8.8:53", Time: 100000, Error: true})
+	s.UpdateUpstream(UpstreamEntry{Upstream: "8.8.8.8:53", Time: 100000, Error: true, Timeout: true})
+
+	check := func(d map[string]interface{}) {
+		a := d["top_upstreams"].([]map[string]interface{})
+		assert.Equal(t, 2, len(a))
+		assert.Equal(t, "1.1.1.1:53", a[0]["upstream"])
+		assert.Equal(t, uint64(10), a[0]["num_requests"])
+		assert.Equal(t, uint64(0), a[0]["num_errors"])
+		assert.InDelta(t, 0.01, a[0]["p99"], 0.01*histAccuracy)
+		assert.Equal(t, "8.8.8.8:53", a[1]["upstream"])
+		assert.Equal(t, uint64(3), a[1]["num_requests"])
+		assert.Equal(t, uint64(2), a[1]["num_errors"])
+		assert.Equal(t, uint64(1), a[1]["num_timeouts"])
+		assert.Equal(t, 0.1, a[1]["avg_response_time"])
+		assert.InDelta(t, 0.1, a[1]["p50"], 0.1*histAccuracy)
+	}
+	check(s.getData())
+
+	// the data is stored in DB
+	s.Close()
+	s, _ = createObject(conf)
+	check(s.getData())
+
+	s.Close()
+	os.Remove(conf.Filename)
+}
+
+func TestStatsRange(t *testing.T) {
+	minute := int32(newMinuteID())
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+		MinuteID: func() uint32 {
+			return uint32(atomic.LoadInt32(&minute))
+		},
+	}
+	os.Remove(conf.Filename)
+	s, _ := createObject(conf)
+
+	e := Entry{}
+	e.Domain = "domain"
+	e.Client = net.ParseIP("127.0.0.1")
+	e.Result = RFiltered
+	s.Update(e)
+	e.Result = RNotFiltered
+	s.Update(e)
+	atomic.AddInt32(&minute, 2)
+	s.Update(e)
+
+	// minute-resolution data
+	minuteTime := func(m int32) string {
+		return time.Unix(int64(m)*60, 0).UTC().Format(time.RFC3339)
+	}
+	q := url.Values{}
+	q.Set("from", minuteTime(minute-3))
+	q.Set("to", minuteTime(minute+1))
+	q.Set("step", "1m")
+	p, err := s.parseRangeParams(q)
+	assert.Nil(t, err)
+	d, err := s.getRangeData(p)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, 2, 0, 1}, d["dns_queries"])
+	assert.Equal(t, []uint64{0, 1, 0, 0}, d["blocked_filtering"])
+	assert.Equal(t, []uint64{0, 0, 0, 0}, d["servfail"])
+	assert.Equal(t, uint64(3), d["num_dns_queries"])
+	assert.Equal(t, uint64(60), d["step"])
+	assert.Equal(t, minuteTime(minute-3), d["from"])
+	assert.Nil(t, d["top_queried_domains"])
+
+	// minute-resolution data isn't available for this time
+	q.Set("from", minuteTime(minute-defaultMinuteUnits))
+	p, _ = s.parseRangeParams(q)
+	_, err = s.getRangeData(p)
+	assert.NotNil(t, err)
+
+	// the default range: all available minute-resolution data
+	for _, step := range []string{"1m", "5m", "10m", "15m", "30m"} {
+		p, err = s.parseRangeParams(url.Values{"step": []string{step}, "to": []string{minuteTime(minute + 1)}})
+		assert.Nil(t, err, step)
+		d, err = s.getRangeData(p)
+		assert.Nil(t, err, step)
+		if err == nil {
+			assert.Equal(t, uint64(3), d["num_dns_queries"], step)
+		}
+	}
+
+	// hourly data
+	p, err = s.parseRangeParams(url.Values{"step": []string{"1h"}})
+	assert.Nil(t, err)
+	d, err = s.getRangeData(p)
+	assert.Nil(t, err)
+	a := d["dns_queries"].([]uint64)
+	assert.Equal(t, 24, len(a))
+	assert.Equal(t, uint64(3), a[len(a)-1])
+	m := d["top_queried_domains"].([]map[string]uint64)
+	assert.Equal(t, uint64(2), m[0]["domain"])
+
+	_, err = s.parseRangeParams(url.Values{"step": []string{"30s"}})
+	assert.NotNil(t, err)
+	step, err := parseStep("7d")
+	assert.Nil(t, err)
+	assert.Equal(t, 7*24*time.Hour, step)
+
+	s.Close()
+	os.Remove(conf.Filename)
+}
+
 func TestLargeNumbers(t *testing.T) {
 	var hour int32
 	hour = 1
@@ -11,13 +11,19 @@ import (
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	bolt "go.etcd.io/bbolt"
 )
 
 const (
-	maxDomains = 100 // max number of top domains to store in file or return via Get()
-	maxClients = 100 // max number of top clients to store in file or return via Get()
+	maxDomains       = 100 // max number of top domains to store in file or return via Get()
+	maxClients       = 100 // max number of top clients to store in file or return via Get()
+	maxClientDomains = 10  // max number of top domains to store in file for each client
+	maxUpstreams     = 50  // max number of upstream servers to store in file
+
+	defaultMinuteUnits = 60      // default number of minute-resolution units
+	maxMinuteUnits     = 24 * 60 // max number of minute-resolution units
 )
 
 // statsCtx - global context
@@ -26,21 +32,66 @@ type statsCtx struct {
 	conf *Config
 
 	unit     *unit      // the current unit
-	unitLock sync.Mutex // protect 'unit'
+	unitLock sync.Mutex // protect 'unit' and 'minutes'
+
+	// the recent minute-resolution units (in memory only)
+	// it's a ring buffer: the unit for minute ID is stored at index (ID % length)
+	minutes []minuteUnit
 }
 
 // data for 1 time unit
 type unit struct {
 	id uint32 // unit ID.  Default: absolute hour since Jan 1, 1970
 
-	nTotal  uint64   // total requests
-	nResult []uint64 // number of requests per one result
-	timeSum uint64   // sum of processing time of all requests (usec)
+	nTotal  uint64    // total requests
+	nResult []uint64  // number of requests per one result
+	timeSum uint64    // sum of processing time of all requests (usec)
+	hist    histogram // processing time of all requests (usec)
 
 	// top:
 	domains        map[string]uint64 // number of requests per domain
 	blockedDomains map[string]uint64 // number of blocked requests per domain
 	clients        map[string]uint64 // number of requests per client
+
+	// breakdowns:
+	qtypes  map[string]uint64 // number of requests per question type
+	rcodes  map[string]uint64 // number of responses per response code
+	protos  map[string]uint64 // number of requests per client protocol
+	nCached uint64            // number of responses from the cache
+
+	// per-upstream data
+	upstreams map[string]*upstreamUnit
+
+	// per-client data (if enabled)
+	clientStats map[string]*clientUnit
+}
+
+// data for 1 upstream server for 1 time unit
+type upstreamUnit struct {
+	nRequests uint64    // total requests
+	nErrors   uint64    // number of failed requests (including timeouts)
+	nTimeouts uint64    // number of requests failed because of timeout
+	timeSum   uint64    // sum of response time of all requests (usec)
+	hist      histogram // response time of all requests (usec)
+}
+
+// data for 1 minute
+// It contains only the counters, there are no top domains and clients.
+type minuteUnit struct {
+	id uint32 // absolute minute since Jan 1, 1970
+
+	nTotal    uint64    // total requests
+	nResult   []uint64  // number of requests per one result
+	timeSum   uint64    // sum of processing time of all requests (usec)
+	hist      histogram // processing time of all requests (usec)
+	nServfail uint64    // number of SERVFAIL responses
+}
+
+// data for 1 client for 1 time unit
+type clientUnit struct {
+	nTotal   uint64            // total requests
+	nBlocked uint64            // number of requests blocked by filters (the same as num_blocked_filtering)
+	domains  map[string]uint64 // number of requests per domain
 }
 
 // name-count pair
@@ -59,6 +110,34 @@ type unitDB struct {
 	Clients        []countPair
 
 	TimeAvg uint32 // usec
+
+	ClientStats []clientUnitDB
+
+	QTypes  []countPair
+	RCodes  []countPair
+	Protos  []countPair
+	NCached uint64
+
+	Hist      histogram
+	Upstreams []upstreamUnitDB
+}
+
+// structure for storing per-upstream data in file
+type upstreamUnitDB struct {
+	Name      string
+	NRequests uint64
+	NErrors   uint64
+	NTimeouts uint64
+	TimeAvg   uint32 // usec
+	Hist      histogram
+}
+
+// structure for storing per-client data in file
+type clientUnitDB struct {
+	Name     string
+	NTotal   uint64
+	NBlocked uint64
+	Domains  []countPair
 }
 
 func createObject(conf Config) (*statsCtx, error) {
@@ -72,6 +151,21 @@ func createObject(conf Config) (*statsCtx, error) {
 	if conf.UnitID == nil {
 		s.conf.UnitID = newUnitID
 	}
+	if conf.MinuteID == nil {
+		s.conf.MinuteID = newMinuteID
+	}
+	if s.conf.MinuteUnits == 0 {
+		s.conf.MinuteUnits = defaultMinuteUnits
+	} else if s.conf.MinuteUnits > maxMinuteUnits {
+		s.conf.MinuteUnits = maxMinuteUnits
+	}
+	s.minutes = make([]minuteUnit, s.conf.MinuteUnits)
+
+	var err error
+	s.conf.ignored, err = util.NewIgnoreList(conf.IgnoredDomains, conf.IgnoredClients)
+	if err != nil {
+		log.Error("Stats: ignore list: %s", err)
+	}
 
 	if !s.dbOpen() {
 		return nil, fmt.Errorf("open database")
@@ -158,6 +252,24 @@ func newUnitID() uint32 {
 	return uint32(time.Now().Unix() / (60 * 60))
 }
 
+// Get minute ID for the current minute
+func newMinuteID() uint32 {
+	return uint32(time.Now().Unix() / 60)
+}
+
+// Get the minute-resolution unit with the specified ID; the stale data in its slot is reset
+// unitLock must be held
+func (s *statsCtx) getMinuteUnit(id uint32) *minuteUnit {
+	m := &s.minutes[id%uint32(len(s.minutes))]
+	if m.id != id || m.nResult == nil {
+		*m = minuteUnit{
+			id:      id,
+			nResult: make([]uint64, rLast),
+		}
+	}
+	return m
+}
+
 // Initialize a unit
 func (s *statsCtx) initUnit(u *unit, id uint32) {
 	u.id = id
@@ -165,6 +277,11 @@ func (s *statsCtx) initUnit(u *unit, id uint32) {
 	u.domains = make(map[string]uint64)
 	u.blockedDomains = make(map[string]uint64)
 	u.clients = make(map[string]uint64)
+	u.qtypes = make(map[string]uint64)
+	u.rcodes = make(map[string]uint64)
+	u.protos = make(map[string]uint64)
+	u.upstreams = make(map[string]*upstreamUnit)
+	u.clientStats = make(map[string]*clientUnit)
 }
 
 // Open a DB transaction
@@ -306,6 +423,47 @@ func serialize(u *unit) *unitDB {
 	udb.Domains = convertMapToArray(u.domains, maxDomains)
 	udb.BlockedDomains = convertMapToArray(u.blockedDomains, maxDomains)
 	udb.Clients = convertMapToArray(u.clients, maxClients)
+	udb.QTypes = convertMapToArray(u.qtypes, len(u.qtypes))
+	udb.RCodes = convertMapToArray(u.rcodes, len(u.rcodes))
+	udb.Protos = convertMapToArray(u.protos, len(u.protos))
+	udb.NCached = u.nCached
+	udb.Hist = u.hist.clone()
+
+	for name, uu := range u.upstreams {
+		uudb := upstreamUnitDB{
+			Name:      name,
+			NRequests: uu.nRequests,
+			NErrors:   uu.nErrors,
+			NTimeouts: uu.nTimeouts,
+			Hist:      uu.hist.clone(),
+		}
+		if uu.nRequests != 0 {
+			uudb.TimeAvg = uint32(uu.timeSum / uu.nRequests)
+		}
+		udb.Upstreams = append(udb.Upstreams, uudb)
+	}
+	sort.Slice(udb.Upstreams, func(i, j int) bool {
+		return udb.Upstreams[i].NRequests > udb.Upstreams[j].NRequests
+	})
+	if len(udb.Upstreams) > maxUpstreams {
+		udb.Upstreams = udb.Upstreams[:maxUpstreams]
+	}
+
+	for name, cu := range u.clientStats {
+		cudb := clientUnitDB{
+			Name:     name,
+			NTotal:   cu.nTotal,
+			NBlocked: cu.nBlocked,
+			Domains:  convertMapToArray(cu.domains, maxClientDomains),
+		}
+		udb.ClientStats = append(udb.ClientStats, cudb)
+	}
+	sort.Slice(udb.ClientStats, func(i, j int) bool {
+		return udb.ClientStats[i].NTotal > udb.ClientStats[j].NTotal
+	})
+	if len(udb.ClientStats) > maxClients {
+		udb.ClientStats = udb.ClientStats[:maxClients]
+	}
 	return &udb
 }
 
@@ -324,6 +482,29 @@ func deserialize(u *unit, udb *unitDB) {
 	u.blockedDomains = convertArrayToMap(udb.BlockedDomains)
 	u.clients = convertArrayToMap(udb.Clients)
 	u.timeSum = uint64(udb.TimeAvg) * u.nTotal
+	u.qtypes = convertArrayToMap(udb.QTypes)
+	u.rcodes = convertArrayToMap(udb.RCodes)
+	u.protos = convertArrayToMap(udb.Protos)
+	u.nCached = udb.NCached
+	u.hist = udb.Hist
+
+	for _, uudb := range udb.Upstreams {
+		u.upstreams[uudb.Name] = &upstreamUnit{
+			nRequests: uudb.NRequests,
+			nErrors:   uudb.NErrors,
+			nTimeouts: uudb.NTimeouts,
+			timeSum:   uint64(uudb.TimeAvg) * uudb.NRequests,
+			hist:      uudb.Hist,
+		}
+	}
+
+	for _, cudb := range udb.ClientStats {
+		u.clientStats[cudb.Name] = &clientUnit{
+			nTotal:   cudb.NTotal,
+			nBlocked: cudb.NBlocked,
+			domains:  convertArrayToMap(cudb.Domains),
+		}
+	}
 }
 
 func (s *statsCtx) flushUnitToDB(tx *bolt.Tx, id uint32, udb *unitDB) bool {
@@ -390,8 +571,28 @@ func (s *statsCtx) setLimit(limitDays int) {
 	log.Debug("Stats: set limit: %d", limitDays)
 }
 
+func (s *statsCtx) setPerClient(enabled bool) {
+	conf := *s.conf
+	conf.PerClient = enabled
+	s.conf = &conf
+	log.Debug("Stats: set per-client: %t", enabled)
+}
+
+func (s *statsCtx) setIgnored(domains []string, clients []string, ignored *util.IgnoreList) {
+	conf := *s.conf
+	conf.IgnoredDomains = domains
+	conf.IgnoredClients = clients
+	conf.ignored = ignored
+	s.conf = &conf
+	log.Debug("Stats: set ignore list: %d domains, %d clients", len(domains), len(clients))
+}
+
 func (s *statsCtx) WriteDiskConfig(dc *DiskConfig) {
 	dc.Interval = s.conf.limit / 24
+	dc.PerClient = s.conf.PerClient
+	dc.MinuteUnits = s.conf.MinuteUnits
+	dc.IgnoredDomains = s.conf.IgnoredDomains
+	dc.IgnoredClients = s.conf.IgnoredClients
 }
 
 func (s *statsCtx) Close() {
@@ -431,7 +632,10 @@ func (s *statsCtx) clear() {
 
 	u := unit{}
 	s.initUnit(&u, s.conf.UnitID())
-	_ = s.swapUnit(&u)
+	s.unitLock.Lock()
+	s.unit = &u
+	s.minutes = make([]minuteUnit, len(s.minutes))
+	s.unitLock.Unlock()
 
 	err := os.Remove(s.conf.Filename)
 	if err != nil {
@@ -469,6 +673,9 @@ func (s *statsCtx) Update(e Entry) {
 		!(len(e.Client) == 4 || len(e.Client) == 16) {
 		return
 	}
+	if s.conf.ignored.Match(e.Domain, e.Client) {
+		return
+	}
 	client := s.getClientIP(e.Client.String())
 
 	s.unitLock.Lock()
@@ -485,13 +692,99 @@ func (s *statsCtx) Update(e Entry) {
 	u.clients[client]++
 	u.timeSum += uint64(e.Time)
 	u.nTotal++
+
+	if len(e.QType) != 0 {
+		u.qtypes[e.QType]++
+	}
+	if len(e.RCode) != 0 {
+		u.rcodes[e.RCode]++
+	}
+	if len(e.Proto) != 0 {
+		u.protos[e.Proto]++
+	}
+	if e.Cached {
+		u.nCached++
+	}
+	u.hist.add(e.Time)
+
+	m := s.getMinuteUnit(s.conf.MinuteID())
+	m.nResult[e.Result]++
+	m.timeSum += uint64(e.Time)
+	m.hist.add(e.Time)
+	m.nTotal++
+	if e.RCode == "SERVFAIL" {
+		m.nServfail++
+	}
+
+	if s.conf.PerClient {
+		cu, ok := u.clientStats[client]
+		if !ok {
+			cu = &clientUnit{domains: make(map[string]uint64)}
+			u.clientStats[client] = cu
+		}
+		cu.nTotal++
+		if e.Result == RFiltered {
+			cu.nBlocked++
+		}
+		cu.domains[e.Domain]++
+	}
+	s.unitLock.Unlock()
+}
+
+func (s *statsCtx) UpdateUpstream(e UpstreamEntry) {
+	if len(e.Upstream) == 0 {
+		return
+	}
+
+	s.unitLock.Lock()
+	u := s.unit
+	if u == nil {
+		s.unitLock.Unlock()
+		return
+	}
+
+	uu, ok := u.upstreams[e.Upstream]
+	if !ok {
+		uu = &upstreamUnit{}
+		u.upstreams[e.Upstream] = uu
+	}
+	uu.nRequests++
+	if e.Error {
+		uu.nErrors++
+	}
+	if e.Timeout {
+		uu.nTimeouts++
+	}
+	uu.timeSum += uint64(e.Time)
+	uu.hist.add(e.Time)
 	s.unitLock.Unlock()
 }
 
 func (s *statsCtx) loadUnits(limit uint32) ([]*unitDB, uint32) {
+	s.unitLock.Lock()
+	curID := s.unit.id
+	s.unitLock.Unlock()
+
+	firstID := curID - limit + 1
+	units := s.loadUnitRange(firstID, curID) //per-hour units
+	if units == nil {
+		return nil, 0
+	}
+
+	if len(units) != int(limit) {
+		log.Fatalf("len(units) != limit: %d %d", len(units), limit)
+	}
+
+	return units, firstID
+}
+
+// Load the units with IDs from firstID to lastID (inclusive)
+// The data for the current unit is taken from memory.
+// If a unit doesn't exist (or it's in the future), an empty unit is returned in its place.
+func (s *statsCtx) loadUnitRange(firstID, lastID uint32) []*unitDB {
 	tx := s.beginTxn(false)
 	if tx == nil {
-		return nil, 0
+		return nil
 	}
 
 	s.unitLock.Lock()
@@ -499,10 +792,14 @@ func (s *statsCtx) loadUnits(limit uint32) ([]*unitDB, uint32) {
 	curID := s.unit.id
 	s.unitLock.Unlock()
 
-	units := []*unitDB{} //per-hour units
-	firstID := curID - limit + 1
-	for i := firstID; i != curID; i++ {
-		u := s.loadUnitFromDB(tx, i)
+	units := []*unitDB{}
+	for id := firstID; id != lastID+1; id++ {
+		var u *unitDB
+		if id == curID {
+			u = curUnit
+		} else if id < curID {
+			u = s.loadUnitFromDB(tx, id)
+		}
 		if u == nil {
 			u = &unitDB{}
 			u.NResult = make([]uint64, rLast)
@@ -512,13 +809,66 @@ func (s *statsCtx) loadUnits(limit uint32) ([]*unitDB, uint32) {
 
 	_ = tx.Rollback()
 
-	units = append(units, curUnit)
+	return units
+}
 
-	if len(units) != int(limit) {
-		log.Fatalf("len(units) != limit: %d %d", len(units), limit)
+// Get the minute-resolution units with IDs from firstID to lastID (inclusive)
+// The data is returned in the same format as hourly units, but without top domains and clients,
+// only SERVFAIL response code is counted, and there's no per-upstream data.
+func (s *statsCtx) loadMinuteRange(firstID, lastID uint32) []*unitDB {
+	s.unitLock.Lock()
+	defer s.unitLock.Unlock()
+
+	units := []*unitDB{}
+	for id := firstID; id != lastID+1; id++ {
+		u := &unitDB{}
+		u.NResult = make([]uint64, rLast)
+		m := &s.minutes[id%uint32(len(s.minutes))]
+		if m.id == id && m.nResult != nil {
+			u.NTotal = m.nTotal
+			copy(u.NResult, m.nResult)
+			if m.nTotal != 0 {
+				u.TimeAvg = uint32(m.timeSum / m.nTotal)
+			}
+			u.RCodes = []countPair{{Name: "SERVFAIL", Count: m.nServfail}}
+			u.Hist = m.hist.clone()
+		}
+		units = append(units, u)
 	}
+	return units
+}
 
-	return units, firstID
+// Get the array of per-time-unit values:
+// If time-unit is an hour, just add values from each unit to an array.
+// If time-unit is a day, aggregate per-hour data into days.
+func aggregateUnits(units []*unitDB, firstID uint32, timeUnit TimeUnit, get func(u *unitDB) uint64) []uint64 {
+	a := []uint64{}
+	if timeUnit == Hours {
+		for _, u := range units {
+			a = append(a, get(u))
+		}
+		return a
+	}
+
+	// 720 hours may span 31 days, so we skip data for the first day in this case
+	firstDayID := (firstID + 24 - 1) / 24 * 24 // align_ceil(24)
+
+	var sum uint64
+	id := firstDayID
+	nextDayID := firstDayID + 24
+	for i := firstDayID - firstID; int(i) != len(units); i++ {
+		sum += get(units[i])
+		if id == nextDayID {
+			a = append(a, sum)
+			sum = 0
+			nextDayID += 24
+		}
+		id++
+	}
+	if id <= nextDayID {
+		a = append(a, sum)
+	}
+	return a
 }
 
 /* Algorithm:
@@ -565,110 +915,38 @@ func (s *statsCtx) getData() map[string]interface{} {
 
 	// per time unit counters:
 
-	// 720 hours may span 31 days, so we skip data for the first day in this case
-	firstDayID := (firstID + 24 - 1) / 24 * 24 // align_ceil(24)
-
-	a := []uint64{}
-	if timeUnit == Hours {
-		for _, u := range units {
-			a = append(a, u.NTotal)
-		}
-	} else {
-		var sum uint64
-		id := firstDayID
-		nextDayID := firstDayID + 24
-		for i := firstDayID - firstID; int(i) != len(units); i++ {
-			sum += units[i].NTotal
-			if id == nextDayID {
-				a = append(a, sum)
-				sum = 0
-				nextDayID += 24
-			}
-			id++
-		}
-		if id <= nextDayID {
-			a = append(a, sum)
-		}
-		if len(a) != int(limit/24) {
-			log.Fatalf("len(a) != limit: %d %d", len(a), limit)
-		}
+	a := aggregateUnits(units, firstID, timeUnit, func(u *unitDB) uint64 { return u.NTotal })
+	if timeUnit == Days && len(a) != int(limit/24) {
+		log.Fatalf("len(a) != limit: %d %d", len(a), limit)
 	}
 	d["dns_queries"] = a
 
-	a = []uint64{}
-	if timeUnit == Hours {
-		for _, u := range units {
-			a = append(a, u.NResult[RFiltered])
-		}
-	} else {
-		var sum uint64
-		id := firstDayID
-		nextDayID := firstDayID + 24
-		for i := firstDayID - firstID; int(i) != len(units); i++ {
-			sum += units[i].NResult[RFiltered]
-			if id == nextDayID {
-				a = append(a, sum)
-				sum = 0
-				nextDayID += 24
-			}
-			id++
-		}
-		if id <= nextDayID {
-			a = append(a, sum)
-		}
-	}
-	d["blocked_filtering"] = a
+	d["blocked_filtering"] = aggregateUnits(units, firstID, timeUnit,
+		func(u *unitDB) uint64 { return u.NResult[RFiltered] })
+	d["replaced_safebrowsing"] = aggregateUnits(units, firstID, timeUnit,
+		func(u *unitDB) uint64 { return u.NResult[RSafeBrowsing] })
+	d["replaced_parental"] = aggregateUnits(units, firstID, timeUnit,
+		func(u *unitDB) uint64 { return u.NResult[RParental] })
+	d["servfail"] = aggregateUnits(units, firstID, timeUnit,
+		func(u *unitDB) uint64 { return findCount(u.RCodes, "SERVFAIL") })
+
+	addTopData(d, units)
+	addBreakdownData(d, units)
+	addTotalData(d, units)
+	addLatencyData(d, units)
+	addUpstreamData(d, units)
 
-	a = []uint64{}
-	if timeUnit == Hours {
-		for _, u := range units {
-			a = append(a, u.NResult[RSafeBrowsing])
-		}
-	} else {
-		var sum uint64
-		id := firstDayID
-		nextDayID := firstDayID + 24
-		for i := firstDayID - firstID; int(i) != len(units); i++ {
-			sum += units[i].NResult[RSafeBrowsing]
-			if id == nextDayID {
-				a = append(a, sum)
-				sum = 0
-				nextDayID += 24
-			}
-			id++
-		}
-		if id <= nextDayID {
-			a = append(a, sum)
-		}
-	}
-	d["replaced_safebrowsing"] = a
-
-	a = []uint64{}
-	if timeUnit == Hours {
-		for _, u := range units {
-			a = append(a, u.NResult[RParental])
-		}
-	} else {
-		var sum uint64
-		id := firstDayID
-		nextDayID := firstDayID + 24
-		for i := firstDayID - firstID; int(i) != len(units); i++ {
-			sum += units[i].NResult[RParental]
-			if id == nextDayID {
-				a = append(a, sum)
-				sum = 0
-				nextDayID += 24
-			}
-			id++
-		}
-		if id <= nextDayID {
-			a = append(a, sum)
-		}
+	d["time_units"] = "hours"
+	if timeUnit == Days {
+		d["time_units"] = "days"
 	}
-	d["replaced_parental"] = a
 
-	// top counters:
+	return d
+}
 
+// Add top counters to the output data:
+// queries/domain, queries/blocked-domain, queries/client
+func addTopData(d map[string]interface{}, units []*unitDB) {
 	m := map[string]uint64{}
 	for _, u := range units {
 		for _, it := range u.Domains {
@@ -695,9 +973,114 @@ func (s *statsCtx) getData() map[string]interface{} {
 	}
 	a2 = convertMapToArray(m, maxClients)
 	d["top_clients"] = convertTopArray(a2)
+}
 
-	// total counters:
+// Get the count for the name
+func findCount(a []countPair, name string) uint64 {
+	for _, it := range a {
+		if it.Name == name {
+			return it.Count
+		}
+	}
+	return 0
+}
 
+// Add the number of requests per question type, response code and client protocol
+// and the number of responses from the cache to the output data
+func addBreakdownData(d map[string]interface{}, units []*unitDB) {
+	qtypes := map[string]uint64{}
+	rcodes := map[string]uint64{}
+	protos := map[string]uint64{}
+	var nCached uint64
+	for _, u := range units {
+		for _, it := range u.QTypes {
+			qtypes[it.Name] += it.Count
+		}
+		for _, it := range u.RCodes {
+			rcodes[it.Name] += it.Count
+		}
+		for _, it := range u.Protos {
+			protos[it.Name] += it.Count
+		}
+		nCached += u.NCached
+	}
+
+	d["query_types"] = convertTopArray(convertMapToArray(qtypes, len(qtypes)))
+	d["response_codes"] = convertTopArray(convertMapToArray(rcodes, len(rcodes)))
+	d["client_protos"] = convertTopArray(convertMapToArray(protos, len(protos)))
+	d["num_cached"] = nCached
+}
+
+// Add processing time percentiles to the output data
+func addLatencyData(d map[string]interface{}, units []*unitDB) {
+	h := histogram{}
+	for _, u := range units {
+		h.merge(u.Hist)
+	}
+	d["processing_time_percentiles"] = h.percentiles()
+}
+
+// Add the data for the upstream servers with the most number of requests:
+// requests, errors, timeouts, average response time and percentiles
+func addUpstreamData(d map[string]interface{}, units []*unitDB) {
+	type upstreamSum struct {
+		nRequests uint64
+		nErrors   uint64
+		nTimeouts uint64
+		timeSum   uint64
+		hist      histogram
+	}
+	m := map[string]*upstreamSum{}
+	for _, u := range units {
+		for _, uu := range u.Upstreams {
+			sum, ok := m[uu.Name]
+			if !ok {
+				sum = &upstreamSum{}
+				m[uu.Name] = sum
+			}
+			sum.nRequests += uu.NRequests
+			sum.nErrors += uu.NErrors
+			sum.nTimeouts += uu.NTimeouts
+			sum.timeSum += uint64(uu.TimeAvg) * uu.NRequests
+			sum.hist.merge(uu.Hist)
+		}
+	}
+
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return m[names[i]].nRequests > m[names[j]].nRequests
+	})
+	if len(names) > maxUpstreams {
+		names = names[:maxUpstreams]
+	}
+
+	a := []map[string]interface{}{}
+	for _, name := range names {
+		sum := m[name]
+		avgTime := float64(0)
+		if sum.nRequests != 0 {
+			avgTime = float64(sum.timeSum/sum.nRequests) / 1000000
+		}
+		ent := map[string]interface{}{
+			"upstream":          name,
+			"num_requests":      sum.nRequests,
+			"num_errors":        sum.nErrors,
+			"num_timeouts":      sum.nTimeouts,
+			"avg_response_time": avgTime,
+		}
+		for k, v := range sum.hist.percentiles() {
+			ent[k] = v
+		}
+		a = append(a, ent)
+	}
+	d["top_upstreams"] = a
+}
+
+// Add total counters and the average processing time to the output data
+func addTotalData(d map[string]interface{}, units []*unitDB) {
 	sum := unitDB{}
 	sum.NResult = make([]uint64, rLast)
 	timeN := 0
@@ -724,6 +1107,73 @@ func (s *statsCtx) getData() map[string]interface{} {
 		avgTime = float64(sum.TimeAvg/uint32(timeN)) / 1000000
 	}
 	d["avg_processing_time"] = avgTime
+}
+
+// Find the client's data in the unit
+func findClientUnit(u *unitDB, client string) *clientUnitDB {
+	for i := range u.ClientStats {
+		if u.ClientStats[i].Name == client {
+			return &u.ClientStats[i]
+		}
+	}
+	return nil
+}
+
+// Get statistics data for 1 client:
+// . per time unit counters: DNS-queries, blocked
+// . top queried domains
+// . total counters: DNS-queries, blocked
+func (s *statsCtx) getClientData(client string) map[string]interface{} {
+	limit := s.conf.limit
+	client = s.getClientIP(client)
+
+	d := map[string]interface{}{}
+	timeUnit := Hours
+	if limit/24 > 7 {
+		timeUnit = Days
+	}
+
+	units, firstID := s.loadUnits(limit)
+	if units == nil {
+		return nil
+	}
+
+	total := func(u *unitDB) uint64 {
+		cu := findClientUnit(u, client)
+		if cu == nil {
+			return 0
+		}
+		return cu.NTotal
+	}
+	blocked := func(u *unitDB) uint64 {
+		cu := findClientUnit(u, client)
+		if cu == nil {
+			return 0
+		}
+		return cu.NBlocked
+	}
+	d["dns_queries"] = aggregateUnits(units, firstID, timeUnit, total)
+	d["blocked_filtering"] = aggregateUnits(units, firstID, timeUnit, blocked)
+
+	m := map[string]uint64{}
+	var nTotal, nBlocked uint64
+	for _, u := range units {
+		cu := findClientUnit(u, client)
+		if cu == nil {
+			continue
+		}
+		nTotal += cu.NTotal
+		nBlocked += cu.NBlocked
+		for _, it := range cu.Domains {
+			m[it.Name] += it.Count
+		}
+	}
+	a := convertMapToArray(m, maxDomains)
+	d["top_queried_domains"] = convertTopArray(a)
+
+	d["client"] = client
+	d["num_dns_queries"] = nTotal
+	d["num_blocked_filtering"] = nBlocked
 
 	d["time_units"] = "hours"
 	if timeUnit == Days {
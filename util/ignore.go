@@ -0,0 +1,88 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// IgnoreList - the list of domain names and clients which requests must not be recorded
+type IgnoreList struct {
+	domains []string     // exact domain names and wildcard patterns, e.g. "*.example.org"
+	ips     []net.IP     // client IP addresses
+	nets    []*net.IPNet // client subnets
+}
+
+// NewIgnoreList - create a new ignore list
+// domains: domain names or wildcard patterns ("*" matches any sequence of characters)
+// clients: IP addresses or CIDR subnets
+func NewIgnoreList(domains []string, clients []string) (*IgnoreList, error) {
+	l := &IgnoreList{}
+
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if len(d) == 0 {
+			return nil, fmt.Errorf("empty domain name")
+		}
+		_, err := path.Match(d, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain pattern %s: %s", d, err)
+		}
+		l.domains = append(l.domains, d)
+	}
+
+	for _, c := range clients {
+		c = strings.TrimSpace(c)
+		if strings.IndexByte(c, '/') != -1 {
+			_, ipnet, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client subnet %s: %s", c, err)
+			}
+			l.nets = append(l.nets, ipnet)
+			continue
+		}
+
+		ip := net.ParseIP(c)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid client IP address %s", c)
+		}
+		l.ips = append(l.ips, ip)
+	}
+
+	return l, nil
+}
+
+// Match - return TRUE if the request from this client for this domain name must be ignored
+// domain: lower-case domain name without the last dot
+// ip: client IP address (optional)
+func (l *IgnoreList) Match(domain string, ip net.IP) bool {
+	if l == nil {
+		return false
+	}
+
+	for _, d := range l.domains {
+		if d == domain {
+			return true
+		}
+		ok, _ := path.Match(d, domain)
+		if ok {
+			return true
+		}
+	}
+
+	if ip == nil {
+		return false
+	}
+	for _, i := range l.ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
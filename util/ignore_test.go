@@ -0,0 +1,33 @@
+package util
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreList(t *testing.T) {
+	l, err := NewIgnoreList([]string{"health.example.org", "*.Monitor.example.org."},
+		[]string{"192.168.1.10", "10.0.0.0/8"})
+	assert.Nil(t, err)
+
+	assert.True(t, l.Match("health.example.org", nil))
+	assert.True(t, l.Match("a.b.monitor.example.org", nil))
+	assert.False(t, l.Match("monitor.example.org", nil))
+	assert.False(t, l.Match("example.org", nil))
+
+	assert.True(t, l.Match("example.org", net.ParseIP("192.168.1.10")))
+	assert.True(t, l.Match("example.org", net.ParseIP("10.1.2.3")))
+	assert.False(t, l.Match("example.org", net.ParseIP("192.168.1.11")))
+
+	var empty *IgnoreList
+	assert.False(t, empty.Match("example.org", net.ParseIP("10.1.2.3")))
+
+	_, err = NewIgnoreList([]string{"[a"}, nil)
+	assert.NotNil(t, err)
+	_, err = NewIgnoreList(nil, []string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+	_, err = NewIgnoreList(nil, []string{"host"})
+	assert.NotNil(t, err)
+}